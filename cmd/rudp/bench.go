@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reliable-udp/protocol/wire"
+	"reliable-udp/util/simnet"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const benchBufferSize = 64 << 10
+
+type benchConfig struct {
+	// Number of the streams sending in parallel.
+	Streams int
+	// How long to send for, unless there's a size to send.
+	Duration time.Duration
+	// Total number of bytes to send over all the streams, or zero to send for the duration.
+	Size uint64
+}
+
+// The outcome of a benchmark run, as seen by the client.
+type benchReport struct {
+	Network        string         `json:"network"`
+	Streams        int            `json:"streams"`
+	Bytes          uint64         `json:"bytes"`
+	Seconds        float64        `json:"seconds"`
+	Goodput        float64        `json:"goodput_bps"`
+	Retransmits    uint64         `json:"retransmits"`
+	RetransmitRate float64        `json:"retransmit_rate"`
+	RTT            rttPercentiles `json:"rtt_ms"`
+	CPUPerGB       float64        `json:"cpu_seconds_per_gb"`
+}
+
+type rttPercentiles struct {
+	Min float64 `json:"min"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func bench(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	server := fs.Bool("s", false, "run as the server, receiving the data of the clients")
+	laddr := fs.String("l", "", "address to listen on (default :9001 for the server, :0 otherwise)")
+	var cfg benchConfig
+	fs.IntVar(&cfg.Streams, "P", 4, "number of parallel streams")
+	fs.DurationVar(&cfg.Duration, "t", 10*time.Second, "how long to send for")
+	fs.Uint64Var(&cfg.Size, "n", 0, "number of bytes to send instead of sending for a duration")
+	jsonOut := fs.Bool("json", false, "print the report as JSON")
+	sim := fs.Bool("sim", false, "run in-process over the simulated network instead of the loopback")
+	var scfg simnet.Config
+	fs.Float64Var(&scfg.Loss, "loss", 0, "probability of a datagram getting lost over the simulated network")
+	fs.DurationVar(&scfg.Latency, "latency", 0, "one-way delay of the simulated network")
+	fs.DurationVar(&scfg.Jitter, "jitter", 0, "maximum random delay on top of the latency of the simulated network")
+	fs.IntVar(&scfg.Bandwidth, "bandwidth", 0, "bandwidth of the simulated network in bytes per second")
+	fs.Parse(args)
+	if cfg.Streams <= 0 || fs.NArg() > 1 || (*server && (fs.NArg() > 0 || *sim)) || (*sim && fs.NArg() > 0) {
+		return errUsage
+	}
+
+	if *server {
+		if *laddr == "" {
+			*laddr = ":9001"
+		}
+		l, err := wire.Listen(*laddr, wireConfig)
+		if err != nil {
+			return err
+		}
+		log.Infof("Listening on %s", l.LocalAddr())
+		return benchServe(ctx, l)
+	}
+
+	if *laddr == "" {
+		*laddr = ":0"
+	}
+	var r benchReport
+	var err error
+	switch {
+	case fs.NArg() > 0:
+		r, err = benchRemote(ctx, *laddr, fs.Arg(0), cfg)
+	case *sim:
+		r, err = benchSimnet(ctx, scfg, cfg)
+	default:
+		r, err = benchLoopback(ctx, cfg)
+	}
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	r.print(os.Stdout)
+	return nil
+}
+
+// Receive and discard the data over every stream of every peer, until the context is done.
+func benchServe(ctx context.Context, l *wire.Listener) error {
+	for {
+		p, err := l.AcceptContext(ctx)
+		if err != nil {
+			if err == ctx.Err() {
+				break
+			}
+			l.Close()
+			return err
+		}
+		log.Debugf("Accepted peer %s", p.RemoteAddr())
+		go func() {
+			for {
+				s, err := p.AcceptStreamContext(ctx)
+				if err != nil {
+					return
+				}
+				go func() {
+					defer s.Close()
+					if _, err := io.Copy(io.Discard, &streamIO{ctx, s}); err != nil && ctx.Err() == nil {
+						log.Debugf("Receiving from %s: %v", p.RemoteAddr(), err)
+					}
+				}()
+			}
+		}()
+	}
+	return l.Close()
+}
+
+func benchRemote(ctx context.Context, laddr, raddr string, cfg benchConfig) (benchReport, error) {
+	addr, err := net.ResolveUDPAddr("udp", raddr)
+	if err != nil {
+		return benchReport{}, err
+	}
+	l, err := wire.Listen(laddr, wireConfig)
+	if err != nil {
+		return benchReport{}, err
+	}
+	defer l.Close()
+	r, err := benchClient(ctx, l, addr.String(), cfg)
+	r.Network = "udp"
+	return r, err
+}
+
+// Run both the server and the client in-process over the loopback.
+// The CPU time then includes the server's share as well.
+func benchLoopback(ctx context.Context, cfg benchConfig) (benchReport, error) {
+	srv, err := wire.Listen("127.0.0.1:0", wireConfig)
+	if err != nil {
+		return benchReport{}, err
+	}
+	cl, err := wire.Listen("127.0.0.1:0", wireConfig)
+	if err != nil {
+		srv.Close()
+		return benchReport{}, err
+	}
+	r, err := benchInProcess(ctx, srv, cl, cfg)
+	r.Network = "loopback"
+	return r, err
+}
+
+// Run both the server and the client in-process over the simulated network, advancing along with the wall clock.
+func benchSimnet(ctx context.Context, scfg simnet.Config, cfg benchConfig) (benchReport, error) {
+	n := simnet.New(time.Now().UnixNano(), scfg)
+	stop := n.Realtime(time.Millisecond)
+	defer stop()
+	listen := func() (*wire.Listener, error) {
+		conn, err := n.Listen(nil)
+		if err != nil {
+			return nil, err
+		}
+		l := wire.NewListener(wireConfig)
+		if err := l.Serve(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	srv, err := listen()
+	if err != nil {
+		return benchReport{}, err
+	}
+	cl, err := listen()
+	if err != nil {
+		srv.Close()
+		return benchReport{}, err
+	}
+	r, err := benchInProcess(ctx, srv, cl, cfg)
+	r.Network = "sim"
+	return r, err
+}
+
+func benchInProcess(ctx context.Context, srv, cl *wire.Listener, cfg benchConfig) (benchReport, error) {
+	sctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		benchServe(sctx, srv)
+	}()
+	r, err := benchClient(ctx, cl, srv.LocalAddr().String(), cfg)
+	cl.Close()
+	cancel()
+	<-done
+	return r, err
+}
+
+// Send over the parallel streams to the peer at the address, for the duration or until the size has been sent.
+func benchClient(ctx context.Context, l *wire.Listener, raddr string, cfg benchConfig) (benchReport, error) {
+	// Every stream sends at least a byte, since a stream sending zero bytes sends until the context is done
+	if cfg.Size > 0 && cfg.Size < uint64(cfg.Streams) {
+		cfg.Streams = int(cfg.Size)
+	}
+	r := benchReport{Streams: cfg.Streams}
+	p, err := l.Peer(raddr)
+	if err != nil {
+		return r, err
+	}
+	sctx := ctx
+	if cfg.Size == 0 {
+		var cancel context.CancelFunc
+		sctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	cpu := cpuTime()
+	start := time.Now()
+	errs := make(chan error, cfg.Streams)
+	for i := 0; i < cfg.Streams; i++ {
+		n := cfg.Size / uint64(cfg.Streams)
+		if i == 0 {
+			n += cfg.Size % uint64(cfg.Streams)
+		}
+		go func() {
+			errs <- benchStream(sctx, p, n)
+		}()
+	}
+	for i := 0; i < cfg.Streams; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return r, err
+	}
+	if err := ctx.Err(); err != nil {
+		return r, err
+	}
+	elapsed := time.Since(start)
+	cpu = cpuTime() - cpu
+
+	stats := p.Stats()
+	r.Bytes = stats.BytesSent
+	r.Seconds = elapsed.Seconds()
+	if r.Seconds > 0 {
+		r.Goodput = float64(r.Bytes) * 8 / r.Seconds
+	}
+	r.Retransmits = stats.Retransmits
+	r.RetransmitRate = stats.RetransmitRate()
+	r.RTT = percentiles(p.RTTSamples())
+	if r.Bytes > 0 {
+		r.CPUPerGB = cpu.Seconds() / (float64(r.Bytes) / 1e9)
+	}
+	return r, nil
+}
+
+// Send n bytes over a new stream, or keep sending until the context is done if n is zero.
+func benchStream(ctx context.Context, p *wire.Peer, n uint64) error {
+	s, err := p.OpenStreamContext(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	buf := make([]byte, benchBufferSize)
+	for sent := uint64(0); n == 0 || sent < n; {
+		b := buf
+		if n > 0 && n-sent < uint64(len(b)) {
+			b = b[:n-sent]
+		}
+		m, err := s.WriteContext(ctx, b)
+		sent += uint64(m)
+		if err != nil {
+			// Running out of time is how the benchmark for a duration ends
+			if ctx.Err() != nil {
+				break
+			}
+			s.Close()
+			return err
+		}
+	}
+	return s.Close()
+}
+
+// Nearest-rank percentiles of the samples, in milliseconds.
+func percentiles(samples []time.Duration) rttPercentiles {
+	if len(samples) <= 0 {
+		return rttPercentiles{}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	at := func(p float64) float64 {
+		i := int(p*float64(len(samples))+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(samples) {
+			i = len(samples) - 1
+		}
+		return float64(samples[i]) / float64(time.Millisecond)
+	}
+	return rttPercentiles{
+		Min: at(0),
+		P50: at(0.5),
+		P90: at(0.9),
+		P99: at(0.99),
+		Max: at(1),
+	}
+}
+
+func (r benchReport) print(w io.Writer) {
+	fmt.Fprintf(w, "network      %s\n", r.Network)
+	fmt.Fprintf(w, "streams      %d\n", r.Streams)
+	fmt.Fprintf(w, "sent         %s in %.2fs\n", formatBytes(r.Bytes), r.Seconds)
+	fmt.Fprintf(w, "goodput      %.1f Mbit/s\n", r.Goodput/1e6)
+	fmt.Fprintf(w, "retransmits  %d (%.2f%%)\n", r.Retransmits, r.RetransmitRate*100)
+	fmt.Fprintf(w, "rtt          min %.2fms p50 %.2fms p90 %.2fms p99 %.2fms max %.2fms\n",
+		r.RTT.Min, r.RTT.P50, r.RTT.P90, r.RTT.P99, r.RTT.Max)
+	if r.CPUPerGB > 0 {
+		fmt.Fprintf(w, "cpu          %.2fs per GB\n", r.CPUPerGB)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"reliable-udp/util/simnet"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBench(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Test sending a fixed size over the lossy simulated network reports the retransmissions
+	{
+		scfg := simnet.Config{Loss: 0.05, Latency: 2 * time.Millisecond}
+		r, err := benchSimnet(ctx, scfg, benchConfig{Streams: 3, Size: 200000})
+		require.Nil(err)
+		require.Equal("sim", r.Network)
+		require.Equal(uint64(200000), r.Bytes)
+		require.NotZero(r.Retransmits)
+		require.NotZero(r.Goodput)
+		require.GreaterOrEqual(r.RTT.P50, 4.0)
+	}
+
+	// Test sending fewer bytes than there are streams leaves the spare streams out
+	{
+		r, err := benchLoopback(ctx, benchConfig{Streams: 4, Size: 2})
+		require.Nil(err)
+		require.Equal(uint64(2), r.Bytes)
+		require.Equal(2, r.Streams)
+	}
+
+	// Test sending for a duration over the loopback stops in time
+	{
+		r, err := benchLoopback(ctx, benchConfig{Streams: 2, Duration: 200 * time.Millisecond})
+		require.Nil(err)
+		require.NotZero(r.Bytes)
+		require.Less(r.Seconds, 2.0)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	require := require.New(t)
+	require.Equal(rttPercentiles{}, percentiles(nil))
+
+	samples := make([]time.Duration, 0, 100)
+	for i := 100; i > 0; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(rttPercentiles{Min: 1, P50: 50, P90: 90, P99: 99, Max: 100}, percentiles(samples))
+}
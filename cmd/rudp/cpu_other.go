@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "time"
+
+// The CPU time isn't measured on this platform.
+func cpuTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/util/pcapng"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+func dissect(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dissect", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	var in io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	return dissectCapture(bufio.NewReader(in), os.Stdout)
+}
+
+// Print every frame of every UDP datagram in the capture, one frame per line.
+// Packets other than UDP datagrams get skipped.
+func dissectCapture(in io.Reader, out io.Writer) error {
+	r, err := pcapng.NewReader(in)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSOURCE\tDESTINATION\tDIR\tFRAME\tSTREAM\tSEQ\tOFFSET\tLEN\tINFO")
+	var start time.Time
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tw.Flush()
+			return err
+		}
+		src, dst, payload, err := pcapng.DecodeUDP(p.LinkType, p.Data)
+		if err != nil {
+			continue
+		}
+		if start.IsZero() {
+			start = p.Time
+		}
+		prefix := fmt.Sprintf("%.6f\t%s\t%s\t%s", p.Time.Sub(start).Seconds(), src, dst, p.Direction)
+		frames, err := frame.DecodePacket(payload)
+		for _, f := range frames {
+			sid, seq, off, info := describeFrame(f.Data)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", prefix, f.Type(), sid, seq, off, f.Length(), info)
+		}
+		if err != nil {
+			fmt.Fprintf(tw, "%s\tmalformed\t-\t-\t-\t%d\t%v\n", prefix, len(payload), err)
+		}
+	}
+	return tw.Flush()
+}
+
+// The stream ID, sequence number and offset of the frame, or a dash for the ones it doesn't have,
+// followed by the details specific to the frame type.
+func describeFrame(d frame.Data) (sid, seq, off, info string) {
+	sid, seq, off = "-", "-", "-"
+	itoa := func(n uint16) string {
+		return strconv.Itoa(int(n))
+	}
+	switch v := d.(type) {
+	case *frame.Fin:
+		sid = itoa(v.StreamID.Uint16())
+		if v.StreamID == 0 {
+			info = "connection"
+		}
+	case *frame.Handshake:
+		sid = itoa(v.StreamID.Uint16())
+		info = fmt.Sprintf("version %d, message %d bytes", v.Version, v.Length)
+	case *frame.HandshakeAck:
+		sid = itoa(v.StreamID.Uint16())
+		info = fmt.Sprintf("frame size %d", v.Size)
+	case *frame.Stream:
+		sid, seq, off = itoa(v.StreamID.Uint16()), itoa(v.Sequence), itoa(v.Offset)
+		info = fmt.Sprintf("chunk %d bytes", len(v.Chunk))
+	case *frame.StreamAck:
+		sid, seq = itoa(v.StreamID.Uint16()), itoa(v.Sequence)
+	case *frame.Repair:
+		sid, seq, off = itoa(v.StreamID.Uint16()), itoa(v.Sequence), itoa(v.Offset)
+		info = fmt.Sprintf("%d chunks, %d bytes", v.Count, v.Length)
+	case *frame.VersionNegotiation:
+		sid = itoa(v.StreamID.Uint16())
+		info = fmt.Sprintf("versions %v", v.Versions)
+	case *frame.MaxStreams:
+		info = fmt.Sprintf("%s count %d", v.Direction, v.Count)
+	case *frame.StreamsBlocked:
+		info = fmt.Sprintf("%s limit %d", v.Direction, v.Limit)
+	case *frame.Shutdown:
+		sid = itoa(v.StreamID.Uint16())
+		if v.Ack {
+			info = "ack"
+		}
+	case *frame.Refusal:
+		sid = itoa(v.StreamID.Uint16())
+		info = v.Reason.String()
+	case *frame.Ping:
+		if v.Ack {
+			info = "ack"
+		}
+	}
+	return sid, seq, off, info
+}
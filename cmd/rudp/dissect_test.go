@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reliable-udp/protocol/wire"
+	"reliable-udp/util/pcapng"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDissect(t *testing.T) {
+	require := require.New(t)
+	var capture bytes.Buffer
+	w, err := pcapng.NewWriter(&capture, pcapng.LinkTypeRaw)
+	require.Nil(err)
+	a, err := wire.Listen("127.0.0.1:0", &wire.Config{Capture: w})
+	require.Nil(err)
+	defer a.Close()
+	b, err := wire.Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer b.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		p, err := b.AcceptContext(ctx)
+		if err != nil {
+			return
+		}
+		s, err := p.AcceptStreamContext(ctx)
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 5)
+		if _, err := s.ReadContext(ctx, buf); err == nil {
+			s.WriteContext(ctx, buf)
+		}
+	}()
+	p, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	s, err := p.OpenStreamContext(ctx)
+	require.Nil(err)
+	_, err = s.WriteContext(ctx, []byte("hello"))
+	require.Nil(err)
+	buf := make([]byte, 5)
+	_, err = s.ReadContext(ctx, buf)
+	require.Nil(err)
+	require.Nil(a.Close())
+
+	// Test every frame exchanged shows up in both directions
+	{
+		var out bytes.Buffer
+		require.Nil(dissectCapture(bytes.NewReader(capture.Bytes()), &out))
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.True(strings.HasPrefix(lines[0], "TIME"))
+		require.Contains(out.String(), " in  ")
+		require.Contains(out.String(), " out  ")
+		require.Contains(out.String(), "  handshake  ")
+		require.Contains(out.String(), "  handshake ACK  ")
+		require.Contains(out.String(), "  stream  ")
+		require.Contains(out.String(), "  stream ACK  ")
+		for _, line := range lines[1:] {
+			require.NotContains(line, "malformed")
+		}
+	}
+
+	// Test a capture that isn't one gets refused
+	{
+		var out bytes.Buffer
+		require.Equal(pcapng.ErrNotPcapng, dissectCapture(strings.NewReader("not a capture"), &out))
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reliable-udp/protocol/qlog"
+	"reliable-udp/protocol/wire"
+	"reliable-udp/util/logging"
+	"reliable-udp/util/pcapng"
+	"sort"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errUsage = errors.New("invalid usage")
+
+// The config of every listener, logging what goes on in the protocol through logrus.
+var wireConfig = &wire.Config{
+	Logger: logging.Logrus(log.StandardLogger()),
+}
+
+// How long the servers let their peers finish the streams in flight once told to stop.
+const shutdownGrace = 10 * time.Second
+
+// Shut the listener down, giving its peers the grace period to finish their streams.
+func shutdown(l *wire.Listener) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	return l.Shutdown(ctx)
+}
+
+type command struct {
+	run   func(ctx context.Context, args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"serve":   {serve, "serve [-l addr] [-o dir]\tReceive the files sent to the address into the directory"},
+	"recv":    {serve, "recv [-l addr] [-o dir]\tSame as serve"},
+	"dissect": {dissect, "dissect capture.pcapng\tPrint the frames of the datagrams captured with RUDP_CAPTURE, reading the capture from stdin with -"},
+	"bench":   {bench, "bench [-s] [-P streams] [-t duration | -n bytes] [-json] [-sim] [host:port]\tMeasure the throughput against a bench server at host:port, or in-process without one (see rudp bench -h for every flag)"},
+	"socks":   {socks, "socks [-l addr] [-stats interval] [-allow prefixes] [-socks addr host:port]\tProxy the SOCKS5 connections accepted on -socks over streams to the server at host:port, or serve as that server. The server connects its peers to any destination, so serving beyond loopback calls for -allow"},
+	"tunnel":  {tunnel, "tunnel [-l addr] [-stats interval] (-tcp addr host:port | -to host:port)\tForward the TCP connections accepted on -tcp over streams to host:port, or the accepted streams to the TCP target -to"},
+	"send":    {send, "send [-l addr] [-q] host:port file...\tSend the files to the receiver at host:port"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	// The protocol logs at the debug level, such as the retransmissions, only show up with RUDP_LOG_LEVEL=debug
+	if level := os.Getenv("RUDP_LOG_LEVEL"); level != "" {
+		lvl, err := log.ParseLevel(level)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.SetLevel(lvl)
+	}
+	// Every datagram sent and received gets captured with RUDP_CAPTURE=file.pcapng, see rudp dissect
+	if path := os.Getenv("RUDP_CAPTURE"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w, err := pcapng.NewWriter(f, pcapng.LinkTypeRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+		wireConfig.Capture = w
+	}
+	// The connection of every peer gets traced into its own qlog file in RUDP_QLOGDIR
+	if dir := os.Getenv("RUDP_QLOGDIR"); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		wireConfig.Tracer = qlog.Dir(dir)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-ch
+		log.Infof("Received signal %+v", sig)
+		cancel()
+	}()
+	err := cmd.run(ctx, os.Args[2:])
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, "usage: rudp %s\n", cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: rudp <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  rudp %s\n", commands[name].usage)
+	}
+}
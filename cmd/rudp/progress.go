@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reliable-udp/protocol/wire"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const progressInterval = 500 * time.Millisecond
+
+// Progress of the file transfer, printed out periodically while the transfer is going on.
+type progress struct {
+	// The number of bytes transferred, kept first for the 64-bit alignment.
+	done uint64
+
+	peer *wire.Peer
+	// Where to print the progress out, if anywhere.
+	out   io.Writer
+	name  string
+	total uint64
+	off   uint64
+	start time.Time
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func (pr *progress) begin(name string, total, off uint64) {
+	pr.name = name
+	pr.total = total
+	pr.off = off
+	atomic.StoreUint64(&pr.done, off)
+	pr.start = time.Now()
+	if pr.out == nil {
+		return
+	}
+	pr.stop = make(chan struct{})
+	pr.wg.Add(1)
+	go func() {
+		defer pr.wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pr.print("\r")
+			case <-pr.stop:
+				pr.print("\n")
+				return
+			}
+		}
+	}()
+}
+
+// Count the written data as transferred.
+func (pr *progress) Write(b []byte) (int, error) {
+	atomic.AddUint64(&pr.done, uint64(len(b)))
+	return len(b), nil
+}
+
+func (pr *progress) end() {
+	if pr.stop == nil {
+		return
+	}
+	close(pr.stop)
+	pr.wg.Wait()
+}
+
+func (pr *progress) print(end string) {
+	done := atomic.LoadUint64(&pr.done)
+	percent := 100.0
+	if pr.total > 0 {
+		percent = float64(done) * 100 / float64(pr.total)
+	}
+	elapsed := time.Since(pr.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(done-pr.off) / elapsed
+	}
+	stats := pr.peer.Stats()
+	fmt.Fprintf(pr.out, "%s %5.1f%% %s/%s %s/s rtt %s retx %d (%.1f%%)%s",
+		pr.name, percent, formatBytes(done), formatBytes(pr.total), formatBytes(uint64(rate)),
+		stats.RTT.Round(10*time.Microsecond), stats.Retransmits, stats.RetransmitRate()*100, end)
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"reliable-udp/protocol/wire"
+)
+
+func send(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	laddr := fs.String("l", ":0", "local address to send from")
+	quiet := fs.Bool("q", false, "don't show the progress")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return errUsage
+	}
+	raddr, err := net.ResolveUDPAddr("udp", fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	l, err := wire.Listen(*laddr, wireConfig)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	p, err := l.Peer(raddr.String())
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args()[1:] {
+		pr := &progress{peer: p}
+		if !*quiet {
+			pr.out = os.Stderr
+		}
+		if _, err := sendFile(ctx, p, path, pr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"reliable-udp/protocol/wire"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func serve(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("l", ":9000", "address to listen on")
+	dir := fs.String("o", ".", "directory to save the received files into")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	l, err := wire.Listen(*addr, wireConfig)
+	if err != nil {
+		return err
+	}
+	log.Infof("Listening on %s", l.LocalAddr())
+	for {
+		p, err := l.AcceptContext(ctx)
+		if err != nil {
+			if err == ctx.Err() {
+				break
+			}
+			l.Close()
+			return err
+		}
+		log.Infof("Accepted peer %s", p.RemoteAddr())
+		// The files being received get to finish while shutting down
+		go servePeer(context.WithoutCancel(ctx), p, *dir)
+	}
+	return shutdown(l)
+}
+
+// Receive the files over every stream the peer opens, until the peer is closed.
+func servePeer(ctx context.Context, p *wire.Peer, dir string) {
+	for {
+		s, err := p.AcceptStreamContext(ctx)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer s.Close()
+			h, off, err := receiveFile(ctx, s, dir)
+			if err != nil {
+				log.Errorf("Receiving %q from %s: %v", h.Name, p.RemoteAddr(), err)
+				return
+			}
+			log.Infof("Received %q from %s, %s resumed at %s", h.Name, p.RemoteAddr(), formatBytes(h.Size), formatBytes(off))
+		}()
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reliable-udp/protocol/wire"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SOCKS5, see RFC 1928. Only the CONNECT command without authentication is supported.
+const (
+	socksVersion     = 5
+	socksNoAuth      = 0
+	socksNoMethods   = 0xff
+	socksConnect     = 1
+	socksAddrIPv4    = 1
+	socksAddrDomain  = 3
+	socksAddrIPv6    = 4
+	socksDialTimeout = 10 * time.Second
+)
+
+// Reply codes, which the server also sends back over the stream to tell how dialing the destination went.
+const (
+	socksSucceeded          byte = 0
+	socksGeneralFailure     byte = 1
+	socksNetworkUnreachable byte = 3
+	socksHostUnreachable    byte = 4
+	socksConnectionRefused  byte = 5
+	socksCommandUnsupported byte = 7
+	socksAddrUnsupported    byte = 8
+)
+
+var (
+	errSocksVersion = errors.New("unsupported SOCKS version")
+	errSocksAuth    = errors.New("no supported SOCKS authentication method")
+	// The destination goes over the stream as host:port prefixed with its length as uint8.
+	errSocksDestination = errors.New("invalid destination")
+	errSocksAllow       = errors.New("invalid IP address or prefix")
+)
+
+func socks(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("socks", flag.ExitOnError)
+	laddr := fs.String("l", "", "address to listen on (default 127.0.0.1:9003 for the server, :0 for the client)")
+	proxy := fs.String("socks", "", "client: address to accept the SOCKS5 connections on, each sent over a new stream to host:port")
+	allow := fs.String("allow", "", "server: comma separated IP addresses and prefixes of the peers allowed to connect, any peer by default")
+	interval := fs.Duration("stats", 0, "log the stats of the open connections at the interval")
+	fs.Parse(args)
+
+	ts := newTunnels()
+	if *interval > 0 {
+		go ts.report(ctx, *interval)
+	}
+	switch {
+	case *proxy != "" && fs.NArg() == 1:
+		if *laddr == "" {
+			*laddr = ":0"
+		}
+		raddr, err := net.ResolveUDPAddr("udp", fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		l, err := wire.Listen(*laddr, wireConfig)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		tl, err := net.Listen("tcp", *proxy)
+		if err != nil {
+			return err
+		}
+		log.Infof("Proxying SOCKS5 on %s through %s", tl.Addr(), raddr)
+		return socksClient(ctx, tl, l, raddr.String(), ts)
+	case *proxy == "" && fs.NArg() == 0:
+		if *laddr == "" {
+			*laddr = "127.0.0.1:9003"
+		}
+		filter, err := socksAllow(*allow)
+		if err != nil {
+			return err
+		}
+		config := *wireConfig
+		config.AcceptFilter = filter
+		l, err := wire.Listen(*laddr, &config)
+		if err != nil {
+			return err
+		}
+		log.Infof("Serving SOCKS5 connections on %s", l.LocalAddr())
+		// Every peer gets to connect to any destination the server can reach
+		if filter == nil && !l.LocalAddr().IP.IsLoopback() {
+			log.Warn("Serving SOCKS5 to any peer, restrict them with -allow")
+		}
+		return socksServer(ctx, l, ts)
+	}
+	return errUsage
+}
+
+// The filter accepting the peers whose address is one of the comma separated IP addresses or prefixes.
+// It's nil for an empty list, accepting every peer.
+func socksAllow(list string) (func(*net.UDPAddr) bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		// A single address is the prefix of its full length
+		if ip := net.ParseIP(s); ip != nil {
+			bits := net.IPv6len * 8
+			if ip.To4() != nil {
+				bits = net.IPv4len * 8
+			}
+			s += "/" + strconv.Itoa(bits)
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errSocksAllow, s)
+		}
+		nets = append(nets, n)
+	}
+	return func(raddr *net.UDPAddr) bool {
+		for _, n := range nets {
+			if n.Contains(raddr.IP) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// Take the SOCKS5 connections accepted by the TCP listener, sending every CONNECT request
+// over a new stream to the peer at the address, until the context is done.
+func socksClient(ctx context.Context, tl net.Listener, l *wire.Listener, raddr string, ts *tunnels) error {
+	go func() {
+		<-ctx.Done()
+		tl.Close()
+	}()
+	for {
+		conn, err := tl.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			if err := socksConnectOver(ctx, conn, l, raddr, ts); err != nil {
+				log.Debugf("SOCKS5 connection from %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func socksConnectOver(ctx context.Context, conn net.Conn, l *wire.Listener, raddr string, ts *tunnels) error {
+	dst, err := socksHandshake(conn)
+	if err != nil {
+		return err
+	}
+	s, err := openStream(ctx, l, raddr)
+	if err != nil {
+		socksReply(conn, socksGeneralFailure)
+		return err
+	}
+	rw := &streamIO{ctx, s}
+	reply := []byte{socksGeneralFailure}
+	if _, err := rw.Write(append([]byte{byte(len(dst))}, dst...)); err == nil {
+		_, err = io.ReadFull(rw, reply)
+	}
+	if err := socksReply(conn, reply[0]); err != nil || reply[0] != socksSucceeded {
+		s.Close()
+		if err == nil {
+			err = fmt.Errorf("connecting %s failed with reply %d", dst, reply[0])
+		}
+		return err
+	}
+	ts.run(ctx, conn, s)
+	return nil
+}
+
+// Negotiate the authentication method and read the CONNECT request, returning its destination as host:port.
+// Any other command gets refused.
+func socksHandshake(conn net.Conn) (string, error) {
+	b := make([]byte, 2, 256)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+	if b[0] != socksVersion {
+		return "", errSocksVersion
+	}
+	methods := b[:b[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksNoMethods)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksNoMethods {
+		return "", errSocksAuth
+	}
+
+	// VER CMD RSV ATYP, followed by the address and the port
+	b = b[:4]
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return "", err
+	}
+	if b[0] != socksVersion {
+		return "", errSocksVersion
+	}
+	var host string
+	switch b[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if b[3] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return "", err
+		}
+		name := make([]byte, b[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		socksReply(conn, socksAddrUnsupported)
+		return "", errSocksDestination
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	if b[1] != socksConnect {
+		socksReply(conn, socksCommandUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", b[1])
+	}
+	dst := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	if len(dst) > 255 {
+		socksReply(conn, socksGeneralFailure)
+		return "", errSocksDestination
+	}
+	return dst, nil
+}
+
+// Reply to the request, without telling the bound address, which is of no use over the stream.
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// Dial the destination of every stream of every peer, replying with how it went and relaying the data
+// once connected, until the context is done.
+func socksServer(ctx context.Context, l *wire.Listener, ts *tunnels) error {
+	for {
+		p, err := l.AcceptContext(ctx)
+		if err != nil {
+			if err == ctx.Err() {
+				break
+			}
+			l.Close()
+			return err
+		}
+		log.Infof("Accepted peer %s", p.RemoteAddr())
+		go func() {
+			for {
+				s, err := p.AcceptStreamContext(ctx)
+				if err != nil {
+					return
+				}
+				go func() {
+					if err := socksDial(ctx, s, ts); err != nil {
+						log.Debugf("SOCKS5 connection from %s: %v", p.RemoteAddr(), err)
+						s.Close()
+					}
+				}()
+			}
+		}()
+	}
+	return shutdown(l)
+}
+
+func socksDial(ctx context.Context, s *wire.Stream, ts *tunnels) error {
+	rw := &streamIO{ctx, s}
+	b := make([]byte, 1, 256)
+	if _, err := io.ReadFull(rw, b); err != nil {
+		return err
+	}
+	dst := b[:b[0]]
+	if _, err := io.ReadFull(rw, dst); err != nil {
+		return err
+	}
+	d := net.Dialer{Timeout: socksDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", string(dst))
+	if err != nil {
+		_, _ = rw.Write([]byte{socksReplyOf(err)})
+		return err
+	}
+	if _, err := rw.Write([]byte{socksSucceeded}); err != nil {
+		conn.Close()
+		return err
+	}
+	ts.run(ctx, conn, s)
+	return nil
+}
+
+// The reply code telling why dialing has failed.
+func socksReplyOf(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socksConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socksNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr), errors.Is(err, os.ErrDeadlineExceeded):
+		return socksHostUnreachable
+	}
+	return socksGeneralFailure
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+func TestSocks(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(err)
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	srv, err := wire.Listen("127.0.0.1:0", &wire.Config{TransportParams: frame.TransportParams{IdleTimeout: 200 * time.Millisecond}})
+	require.Nil(err)
+	defer srv.Close()
+	go socksServer(ctx, srv, newTunnels())
+
+	l, err := wire.Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer l.Close()
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(err)
+	go socksClient(ctx, tl, l, srv.LocalAddr().String(), newTunnels())
+
+	dialer, err := proxy.SOCKS5("tcp", tl.Addr().String(), nil, proxy.Direct)
+	require.Nil(err)
+
+	// Test the connections to the destination get relayed over the streams
+	for _, dst := range []string{echo.Addr().String(), net.JoinHostPort("localhost", portOf(echo.Addr()))} {
+		conn, err := dialer.Dial("tcp", dst)
+		require.Nil(err, dst)
+		_, err = conn.Write([]byte("Hello, world!"))
+		require.Nil(err)
+		b := make([]byte, 13)
+		_, err = io.ReadFull(conn, b)
+		require.Nil(err)
+		require.Equal("Hello, world!", string(b))
+		conn.Close()
+	}
+
+	// Test the connections keep getting relayed once the peer has gone idle
+	{
+		p, err := l.Peer(srv.LocalAddr().String())
+		require.Nil(err)
+		require.Eventually(func() bool {
+			q, err := l.Peer(srv.LocalAddr().String())
+			return err == nil && q != p
+		}, 5*time.Second, 10*time.Millisecond)
+		conn, err := dialer.Dial("tcp", echo.Addr().String())
+		require.Nil(err)
+		_, err = conn.Write([]byte("Hello, world!"))
+		require.Nil(err)
+		b := make([]byte, 13)
+		_, err = io.ReadFull(conn, b)
+		require.Nil(err)
+		require.Equal("Hello, world!", string(b))
+		conn.Close()
+	}
+
+	// Test failing to connect to the destination gets replied to the client
+	{
+		closed, err := net.Listen("tcp", "127.0.0.1:0")
+		require.Nil(err)
+		closed.Close()
+		_, err = dialer.Dial("tcp", closed.Addr().String())
+		require.NotNil(err)
+		require.Contains(err.Error(), "connection refused")
+	}
+}
+
+func TestSocksReplyOf(t *testing.T) {
+	require := require.New(t)
+	require.Equal(socksConnectionRefused, socksReplyOf(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	require.Equal(socksHostUnreachable, socksReplyOf(&net.DNSError{Err: "no such host"}))
+	require.Equal(socksGeneralFailure, socksReplyOf(io.ErrUnexpectedEOF))
+}
+
+func TestSocksAllow(t *testing.T) {
+	require := require.New(t)
+	allowed, err := socksAllow("10.0.0.1, 192.168.0.0/16,::1")
+	require.Nil(err)
+	require.True(allowed(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)}))
+	require.False(allowed(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 2)}))
+	require.True(allowed(&net.UDPAddr{IP: net.IPv4(192, 168, 1, 2)}))
+	require.True(allowed(&net.UDPAddr{IP: net.IPv6loopback}))
+	require.False(allowed(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}))
+
+	allowed, err = socksAllow("")
+	require.Nil(err)
+	require.Nil(allowed)
+	_, err = socksAllow("10.0.0.1,localhost")
+	require.True(errors.Is(err, errSocksAllow))
+}
+
+func portOf(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
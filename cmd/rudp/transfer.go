@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reliable-udp/protocol/wire"
+)
+
+// A file transfer takes a stream of its own. The sender starts with the header,
+// to which the receiver replies with the offset to resume from, which is how much of the file
+// the receiver already has from an earlier attempt. The sender then sends the rest of the file,
+// and the receiver finally replies with the status once it has verified the whole file.
+const (
+	// Name length uint16 + Size uint64 + MD5 Hash (128-bit), followed by the name
+	headerBaseSize = 2 + 8 + md5.Size
+	nameMaxSize    = 255
+	// The partly received file is kept under its name with the suffix until it's complete.
+	partSuffix = ".part"
+	copySize   = 256 << 10
+)
+
+const (
+	statusOK byte = iota
+	statusHashMismatch
+)
+
+var (
+	errNameInvalid   = errors.New("invalid file name")
+	errOffsetInvalid = errors.New("resume offset past the end of the file")
+	errHashMismatch  = errors.New("file hash mismatch")
+)
+
+type header struct {
+	Name string
+	Size uint64
+	Hash [md5.Size]byte
+}
+
+func (h header) write(w io.Writer) error {
+	if len(h.Name) > nameMaxSize {
+		return errNameInvalid
+	}
+	b := make([]byte, headerBaseSize, headerBaseSize+len(h.Name))
+	binary.BigEndian.PutUint16(b, uint16(len(h.Name)))
+	binary.BigEndian.PutUint64(b[2:], h.Size)
+	copy(b[10:], h.Hash[:])
+	_, err := w.Write(append(b, h.Name...))
+	return err
+}
+
+func readHeader(r io.Reader) (header, error) {
+	var h header
+	b := make([]byte, headerBaseSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return h, err
+	}
+	name := make([]byte, binary.BigEndian.Uint16(b))
+	if len(name) > nameMaxSize {
+		return h, errNameInvalid
+	}
+	if _, err := io.ReadFull(r, name); err != nil {
+		return h, err
+	}
+	h.Name = string(name)
+	h.Size = binary.BigEndian.Uint64(b[2:])
+	copy(h.Hash[:], b[10:])
+	return h, nil
+}
+
+// Only plain file names are taken, so the sender never gets to write outside the directory.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+		return errNameInvalid
+	}
+	return nil
+}
+
+// Reading and writing the stream gives up once the context is done.
+type streamIO struct {
+	ctx context.Context
+	s   *wire.Stream
+}
+
+func (rw *streamIO) Read(b []byte) (int, error) {
+	return rw.s.ReadContext(rw.ctx, b)
+}
+
+func (rw *streamIO) Write(b []byte) (int, error) {
+	return rw.s.WriteContext(rw.ctx, b)
+}
+
+// Send the file over a new stream, resuming from where the receiver has left off.
+// Returns the offset the transfer has resumed from.
+func sendFile(ctx context.Context, p *wire.Peer, path string, pr *progress) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	h := header{
+		Name: filepath.Base(path),
+		Size: uint64(info.Size()),
+	}
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return 0, err
+	}
+	copy(h.Hash[:], hash.Sum(nil))
+
+	s, err := p.OpenStreamContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	rw := &streamIO{ctx, s}
+	if err := h.write(rw); err != nil {
+		return 0, err
+	}
+	var off uint64
+	if err := binary.Read(rw, binary.BigEndian, &off); err != nil {
+		return 0, err
+	}
+	if off > h.Size {
+		return 0, errOffsetInvalid
+	}
+	if _, err := f.Seek(int64(off), io.SeekStart); err != nil {
+		return off, err
+	}
+
+	pr.begin(h.Name, h.Size, off)
+	defer pr.end()
+	// The progress only counts the data once the receiver has acknowledged it
+	if _, err := io.CopyBuffer(io.MultiWriter(rw, pr), f, make([]byte, copySize)); err != nil {
+		return off, err
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(rw, status); err != nil {
+		return off, err
+	}
+	if status[0] != statusOK {
+		return off, errHashMismatch
+	}
+	return off, nil
+}
+
+// Receive the file over the stream into the directory, resuming from the partly received file if there's any.
+// Returns the header of the file and the offset the transfer has resumed from.
+func receiveFile(ctx context.Context, s *wire.Stream, dir string) (header, uint64, error) {
+	rw := &streamIO{ctx, s}
+	h, err := readHeader(rw)
+	if err != nil {
+		return h, 0, err
+	}
+	if err := checkName(h.Name); err != nil {
+		return h, 0, err
+	}
+	path := filepath.Join(dir, h.Name)
+	f, err := os.OpenFile(path+partSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return h, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return h, 0, err
+	}
+	off := uint64(info.Size())
+	// The partly received file can't be of the same file if it's larger
+	if off > h.Size {
+		off = 0
+	}
+	if err := f.Truncate(int64(off)); err != nil {
+		return h, 0, err
+	}
+	if _, err := f.Seek(int64(off), io.SeekStart); err != nil {
+		return h, 0, err
+	}
+	if err := binary.Write(rw, binary.BigEndian, off); err != nil {
+		return h, off, err
+	}
+	// Whatever has been written to the file so far is kept for resuming, even if the copying fails
+	if _, err := io.CopyN(f, rw, int64(h.Size-off)); err != nil {
+		return h, off, err
+	}
+
+	// The part received before resuming gets verified as well
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return h, off, err
+	}
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return h, off, err
+	}
+	if !bytes.Equal(hash.Sum(nil), h.Hash[:]) {
+		// Start over next time, since there's no telling which part is broken
+		f.Close()
+		os.Remove(path + partSuffix)
+		_, _ = rw.Write([]byte{statusHashMismatch})
+		return h, off, errHashMismatch
+	}
+	if err := f.Close(); err != nil {
+		return h, off, err
+	}
+	if err := os.Rename(path+partSuffix, path); err != nil {
+		return h, off, err
+	}
+	_, err = rw.Write([]byte{statusOK})
+	return h, off, err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"reliable-udp/protocol/wire"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransfer(t *testing.T) {
+	require := require.New(t)
+	a, err := wire.Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer a.Close()
+	b, err := wire.Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer b.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	src, dst := t.TempDir(), t.TempDir()
+	data := make([]byte, 300000)
+	_, err = rand.Read(data)
+	require.Nil(err)
+	path := filepath.Join(src, "data.bin")
+	require.Nil(os.WriteFile(path, data, 0644))
+
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	type result struct {
+		h   header
+		off uint64
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		bpeer, err := b.AcceptContext(ctx)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		for {
+			s, err := bpeer.AcceptStreamContext(ctx)
+			if err != nil {
+				return
+			}
+			h, off, err := receiveFile(ctx, s, dst)
+			s.Close()
+			results <- result{h, off, err}
+		}
+	}()
+
+	// Test the file arrives in full under its name
+	{
+		off, err := sendFile(ctx, apeer, path, &progress{peer: apeer})
+		require.Nil(err)
+		require.Zero(off)
+		r := <-results
+		require.Nil(r.err)
+		require.Equal("data.bin", r.h.Name)
+		received, err := os.ReadFile(filepath.Join(dst, "data.bin"))
+		require.Nil(err)
+		require.Equal(data, received)
+		require.NotZero(apeer.Stats().BytesSent)
+	}
+
+	// Test the transfer resumes from the partly received file
+	{
+		require.Nil(os.Remove(filepath.Join(dst, "data.bin")))
+		require.Nil(os.WriteFile(filepath.Join(dst, "data.bin"+partSuffix), data[:100000], 0644))
+		off, err := sendFile(ctx, apeer, path, &progress{peer: apeer})
+		require.Nil(err)
+		require.Equal(uint64(100000), off)
+		r := <-results
+		require.Nil(r.err)
+		require.Equal(uint64(100000), r.off)
+		received, err := os.ReadFile(filepath.Join(dst, "data.bin"))
+		require.Nil(err)
+		require.Equal(data, received)
+		_, err = os.Stat(filepath.Join(dst, "data.bin"+partSuffix))
+		require.True(os.IsNotExist(err))
+	}
+
+	// Test a broken partly received file fails the verification and gets removed
+	{
+		require.Nil(os.WriteFile(filepath.Join(dst, "data.bin"+partSuffix), make([]byte, 100000), 0644))
+		_, err := sendFile(ctx, apeer, path, &progress{peer: apeer})
+		require.Equal(errHashMismatch, err)
+		r := <-results
+		require.Equal(errHashMismatch, r.err)
+		_, err = os.Stat(filepath.Join(dst, "data.bin"+partSuffix))
+		require.True(os.IsNotExist(err))
+	}
+}
+
+func TestCheckName(t *testing.T) {
+	require := require.New(t)
+	require.Nil(checkName("data.bin"))
+	for _, name := range []string{"", ".", "..", "../data.bin", "dir/data.bin", "/data.bin"} {
+		require.Equal(errNameInvalid, checkName(name), name)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire"
+	"reliable-udp/protocol/wire/interop"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func tunnel(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tunnel", flag.ExitOnError)
+	laddr := fs.String("l", "", "address to listen on (default :9002 for the exit end, :0 for the entry end)")
+	entry := fs.String("tcp", "", "entry end: TCP address to accept the connections on, each forwarded over a new stream to host:port")
+	target := fs.String("to", "", "exit end: TCP address to dial for every stream accepted")
+	interval := fs.Duration("stats", 0, "log the stats of the open tunnels at the interval")
+	fs.Parse(args)
+
+	ts := newTunnels()
+	if *interval > 0 {
+		go ts.report(ctx, *interval)
+	}
+	switch {
+	case *entry != "" && *target == "" && fs.NArg() == 1:
+		if *laddr == "" {
+			*laddr = ":0"
+		}
+		raddr, err := net.ResolveUDPAddr("udp", fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		l, err := wire.Listen(*laddr, wireConfig)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		tl, err := net.Listen("tcp", *entry)
+		if err != nil {
+			return err
+		}
+		log.Infof("Forwarding %s to %s", tl.Addr(), raddr)
+		return tunnelEntry(ctx, tl, l, raddr.String(), ts)
+	case *target != "" && *entry == "" && fs.NArg() == 0:
+		if *laddr == "" {
+			*laddr = ":9002"
+		}
+		l, err := wire.Listen(*laddr, wireConfig)
+		if err != nil {
+			return err
+		}
+		log.Infof("Forwarding %s to %s", l.LocalAddr(), *target)
+		return tunnelExit(ctx, l, *target, ts)
+	}
+	return errUsage
+}
+
+// Forward every connection accepted by the TCP listener over a new stream to the peer at the address,
+// until the context is done.
+func tunnelEntry(ctx context.Context, tl net.Listener, l *wire.Listener, raddr string, ts *tunnels) error {
+	go func() {
+		<-ctx.Done()
+		tl.Close()
+	}()
+	for {
+		conn, err := tl.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			s, err := openStream(ctx, l, raddr)
+			if err != nil {
+				log.Errorf("Opening a stream for %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			ts.run(ctx, conn, s)
+		}()
+	}
+}
+
+// Open a new stream to the peer at the address. The peer gets closed once it goes idle or restarts,
+// so a new peer takes its place rather than failing every stream from then on.
+func openStream(ctx context.Context, l *wire.Listener, raddr string) (*wire.Stream, error) {
+	p, err := l.Peer(raddr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := p.OpenStreamContext(ctx)
+	// The peer may have got closed since, which the listener finds out about in the meantime
+	if errors.Is(err, wire.ErrPeerAlreadyClosed) || errors.Is(err, interop.ErrPeerAlreadyClosed) {
+		if p, err = l.Peer(raddr); err != nil {
+			return nil, err
+		}
+		s, err = p.OpenStreamContext(ctx)
+	}
+	return s, err
+}
+
+// Forward every stream of every peer to a new connection to the target, until the context is done.
+func tunnelExit(ctx context.Context, l *wire.Listener, target string, ts *tunnels) error {
+	for {
+		p, err := l.AcceptContext(ctx)
+		if err != nil {
+			if err == ctx.Err() {
+				break
+			}
+			l.Close()
+			return err
+		}
+		log.Infof("Accepted peer %s", p.RemoteAddr())
+		go func() {
+			var d net.Dialer
+			for {
+				s, err := p.AcceptStreamContext(ctx)
+				if err != nil {
+					return
+				}
+				go func() {
+					conn, err := d.DialContext(ctx, "tcp", target)
+					if err != nil {
+						log.Errorf("Dialing %s for %s: %v", target, p.RemoteAddr(), err)
+						s.Close()
+						return
+					}
+					ts.run(ctx, conn, s)
+				}()
+			}
+		}()
+	}
+	return shutdown(l)
+}
+
+// Stats of a single tunnel between a TCP connection and a stream.
+type tunnelStats struct {
+	// Bytes from the connection over the stream, and the other way around, kept first for the 64-bit alignment.
+	sent     uint64
+	received uint64
+
+	id    int
+	conn  string
+	sid   frame.StreamID
+	start time.Time
+}
+
+func (t *tunnelStats) log(msg string) {
+	log.Infof("Tunnel %d %s <-> stream %d %s after %s, sent %s, received %s", t.id, t.conn, t.sid, msg,
+		time.Since(t.start).Round(time.Millisecond), formatBytes(atomic.LoadUint64(&t.sent)),
+		formatBytes(atomic.LoadUint64(&t.received)))
+}
+
+// The open tunnels.
+type tunnels struct {
+	mu     sync.Mutex
+	nextId int
+	open   map[int]*tunnelStats
+}
+
+func newTunnels() *tunnels {
+	return &tunnels{open: make(map[int]*tunnelStats)}
+}
+
+// Copy the data between the connection and the stream both ways until both are done,
+// passing on the end of either direction as a half-close. Closes both once done.
+func (ts *tunnels) run(ctx context.Context, conn net.Conn, s *wire.Stream) {
+	t := ts.add(conn, s)
+	defer ts.remove(t)
+	rw := &streamIO{ctx, s}
+	errs := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(&countingWriter{rw, &t.sent}, conn)
+		if err == nil {
+			err = s.CloseWrite()
+		}
+		errs <- err
+	}()
+	go func() {
+		_, err := io.Copy(&countingWriter{conn, &t.received}, rw)
+		if err == nil {
+			err = closeWrite(conn)
+		}
+		errs <- err
+	}()
+	var err error
+	for i := 0; i < 2; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+			// Tear down both directions, which gets the other one out of copying
+			conn.Close()
+			s.Close()
+		}
+	}
+	conn.Close()
+	s.Close()
+	if err != nil && ctx.Err() == nil {
+		t.log(fmt.Sprintf("failed (%v)", err))
+		return
+	}
+	t.log("closed")
+}
+
+func (ts *tunnels) add(conn net.Conn, s *wire.Stream) *tunnelStats {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.nextId++
+	t := &tunnelStats{
+		id:    ts.nextId,
+		conn:  conn.RemoteAddr().String(),
+		sid:   s.StreamID(),
+		start: time.Now(),
+	}
+	ts.open[t.id] = t
+	return t
+}
+
+func (ts *tunnels) remove(t *tunnelStats) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.open, t.id)
+}
+
+// Log the stats of the open tunnels at the interval, until the context is done.
+func (ts *tunnels) report(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		ts.mu.Lock()
+		open := make([]*tunnelStats, 0, len(ts.open))
+		for _, t := range ts.open {
+			open = append(open, t)
+		}
+		ts.mu.Unlock()
+		sort.Slice(open, func(i, j int) bool {
+			return open[i].id < open[j].id
+		})
+		for _, t := range open {
+			t.log("open")
+		}
+	}
+}
+
+// Shut down the write side of the connection, if it supports half-closing like TCP does.
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	atomic.AddUint64(cw.n, uint64(n))
+	return n, err
+}
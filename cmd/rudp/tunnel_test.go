@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTunnel(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The target replies only once the client is done writing, which takes the half-close to get through
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b, err := io.ReadAll(conn)
+				if err != nil {
+					return
+				}
+				conn.Write(append([]byte("echo: "), b...))
+			}()
+		}
+	}()
+
+	// The peers go idle between the connections, which the entry end gets over with a new peer
+	exit, err := wire.Listen("127.0.0.1:0", &wire.Config{TransportParams: frame.TransportParams{IdleTimeout: 200 * time.Millisecond}})
+	require.Nil(err)
+	defer exit.Close()
+	ets := newTunnels()
+	go tunnelExit(ctx, exit, target.Addr().String(), ets)
+
+	l, err := wire.Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer l.Close()
+	entry, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(err)
+	ts := newTunnels()
+	go tunnelEntry(ctx, entry, l, exit.LocalAddr().String(), ts)
+	forward := func() {
+		conn, err := net.Dial("tcp", entry.Addr().String())
+		require.Nil(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("Hello, world!"))
+		require.Nil(err)
+		require.Nil(conn.(*net.TCPConn).CloseWrite())
+		reply, err := io.ReadAll(conn)
+		require.Nil(err)
+		require.Equal("echo: Hello, world!", string(reply))
+	}
+
+	// Test the connections get forwarded both ways with the half-close
+	for i := 0; i < 3; i++ {
+		forward()
+	}
+
+	// Test the connections keep getting forwarded once the peer has gone idle
+	{
+		p, err := l.Peer(exit.LocalAddr().String())
+		require.Nil(err)
+		require.Eventually(func() bool {
+			q, err := l.Peer(exit.LocalAddr().String())
+			return err == nil && q != p
+		}, 5*time.Second, 10*time.Millisecond)
+		forward()
+	}
+
+	// Test the tunnels are gone once both ends are done
+	require.Eventually(func() bool {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		ets.mu.Lock()
+		defer ets.mu.Unlock()
+		return len(ts.open) == 0 && len(ets.open) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(4, ts.nextId)
+	require.Equal(4, ets.nextId)
+}
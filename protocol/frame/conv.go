@@ -25,6 +25,14 @@ func Uint32ToBytes(v uint32) []byte {
 	return b
 }
 
+func AppendUint16(dst []byte, v uint16) []byte {
+	return append(dst, byte(v>>8), byte(v))
+}
+
+func AppendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
 func BytesToMD5Hash(b []byte) []byte {
 	hash := md5.Sum(b)
 	return hash[:]
@@ -2,6 +2,9 @@ package frame
 
 type Serializer interface {
 	Bytes() []byte
+	// Append the serialized bytes to the buffer and return the extended buffer,
+	// allowing the caller to reuse buffers instead of allocating for every frame.
+	AppendBytes(dst []byte) []byte
 }
 
 type Data interface {
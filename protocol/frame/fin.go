@@ -13,11 +13,24 @@ type Fin struct {
 }
 
 func DecodeFin(b []byte) (*Fin, error) {
+	f := &Fin{}
+	if err := f.Decode(b); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Decode the frame into the receiver.
+func (f *Fin) Decode(b []byte) error {
 	sid, err := DecodeStreamID(b)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if len(b) > StreamIDSize {
+		return ErrTrailingBytes
 	}
-	return &Fin{sid}, nil
+	f.StreamID = sid
+	return nil
 }
 
 func (Fin) Type() FrameType {
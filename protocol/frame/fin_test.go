@@ -13,6 +13,8 @@ func TestFin(t *testing.T) {
 	{
 		_, err := DecodeFin(make([]byte, 0))
 		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodeFin(make([]byte, StreamIDSize+1))
+		require.Equal(ErrTrailingBytes, err)
 	}
 
 	// Test encode/decode corectness
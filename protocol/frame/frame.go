@@ -1,8 +1,9 @@
 package frame
 
 import (
-	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 )
 
 const (
@@ -18,8 +19,28 @@ var (
 	ErrBufferUnderflow  = errors.New("buffer underflow")
 	ErrFrameTypeUnknown = errors.New("unknown frame type")
 	ErrFrameDataMissing = errors.New("missing frame data")
+	ErrFrameTooLarge    = errors.New("frame too large")
+	ErrTrailingBytes    = errors.New("trailing bytes after frame")
+	ErrReservedNonZero  = errors.New("reserved field not zero")
+	ErrPaddingNonZero   = errors.New("padding not zero")
+	ErrExtensionInvalid = errors.New("extension frame type out of range")
+	ErrHashSize         = errors.New("hash not of MD5 size")
 )
 
+// DecodeError tells which frame type failed to decode and why.
+type DecodeError struct {
+	Type FrameType
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %s frame: %v", e.Type, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
 type FrameType uint8
 
 const (
@@ -31,11 +52,42 @@ const (
 	HandshakeAckType
 	StreamType
 	StreamAckType
+	RepairType
+	VersionNegotiationType
+	MaxStreamsType
+	StreamsBlockedType
+	ShutdownType
+	RefusalType
+	PingType
 )
 
-type dataDecoderFunc func([]byte) (Data, error)
+var frameTypeNames = map[FrameType]string{
+	UnknownType:            "unknown",
+	FinType:                "FIN",
+	HandshakeType:          "handshake",
+	HandshakeAckType:       "handshake ACK",
+	StreamType:             "stream",
+	StreamAckType:          "stream ACK",
+	RepairType:             "repair",
+	VersionNegotiationType: "version negotiation",
+	MaxStreamsType:         "max streams",
+	StreamsBlockedType:     "streams blocked",
+	ShutdownType:           "shutdown",
+	RefusalType:            "refusal",
+	PingType:               "ping",
+}
+
+func (ft FrameType) String() string {
+	if name, ok := frameTypeNames[ft]; ok {
+		return name
+	}
+	if ft.Extension() {
+		return fmt.Sprintf("extension %#02x", uint8(ft))
+	}
+	return fmt.Sprintf("type %d", uint8(ft))
+}
 
-var dataDecoders = map[FrameType]dataDecoderFunc{
+var dataDecoders = map[FrameType]Decoder{
 	FinType: func(b []byte) (Data, error) {
 		return DecodeFin(b)
 	},
@@ -51,6 +103,27 @@ var dataDecoders = map[FrameType]dataDecoderFunc{
 	StreamAckType: func(b []byte) (Data, error) {
 		return DecodeStreamAck(b)
 	},
+	RepairType: func(b []byte) (Data, error) {
+		return DecodeRepair(b)
+	},
+	VersionNegotiationType: func(b []byte) (Data, error) {
+		return DecodeVersionNegotiation(b)
+	},
+	MaxStreamsType: func(b []byte) (Data, error) {
+		return DecodeMaxStreams(b)
+	},
+	StreamsBlockedType: func(b []byte) (Data, error) {
+		return DecodeStreamsBlocked(b)
+	},
+	ShutdownType: func(b []byte) (Data, error) {
+		return DecodeShutdown(b)
+	},
+	RefusalType: func(b []byte) (Data, error) {
+		return DecodeRefusal(b)
+	},
+	PingType: func(b []byte) (Data, error) {
+		return DecodePing(b)
+	},
 }
 
 // Frame headers consist of frame type and data length.
@@ -70,22 +143,57 @@ func Encode(data Data) []byte {
 	return New(data).Bytes()
 }
 
+// EncodeTo appends the encoded frame to the buffer and returns the extended buffer.
+func EncodeTo(dst []byte, data Data) []byte {
+	return New(data).AppendBytes(dst)
+}
+
+// Decode exactly a single frame out of the buffer.
+// Decoded frames own their bytes, meaning none of them alias the buffer they're decoded from.
+// The caller is free to reuse the buffer as soon as the decoding returns.
+// Unknown non-critical frames are only skipped while decoding packets, here they're still unknown.
 func Decode(b []byte) (*Frame, error) {
+	f, n, err := decodeNext(b)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, ErrFrameTypeUnknown
+	}
+	if n < len(b) {
+		return nil, ErrTrailingBytes
+	}
+	return f, nil
+}
+
+// Decode the first frame in the buffer and return the number of bytes it occupies,
+// so the caller may continue decoding the frames coalesced after it.
+// The frame is nil if it's an unknown non-critical frame to be skipped.
+func decodeNext(b []byte) (*Frame, int, error) {
 	if len(b) < FrameBaseSize {
-		return nil, ErrBufferUnderflow
+		return nil, 0, ErrBufferUnderflow
 	}
 	ft := FrameType(b[0])
 	length := int(BytesToUint16(b[1:3]))
 	raw := b[3:]
 	if len(raw) < length {
-		return nil, ErrBufferUnderflow
+		return nil, 0, ErrBufferUnderflow
+	}
+	if length > FrameDataMaxSize {
+		return nil, 0, ErrFrameTooLarge
 	}
 	raw = raw[:length]
 	data, err := DecodeData(ft, raw)
+	if err == ErrFrameTypeUnknown && !ft.Critical() {
+		return nil, FrameBaseSize + length, nil
+	}
 	if err != nil {
-		return nil, err
+		if err != ErrFrameTypeUnknown {
+			err = &DecodeError{ft, err}
+		}
+		return nil, 0, err
 	}
-	return &Frame{data}, nil
+	return &Frame{data}, FrameBaseSize + length, nil
 }
 
 func (f Frame) Length() int {
@@ -95,6 +203,32 @@ func (f Frame) Length() int {
 	return 0
 }
 
+// The size of the frame on the wire, including the headers.
+func (f Frame) Size() int {
+	return FrameBaseSize + f.Length()
+}
+
+// Validate the frame fits within a single packet and its fields fit their sizes on the wire.
+// Encoding a frame doesn't validate it, since the length header would silently get truncated
+// for data larger than 65535 bytes. Adding the frame to a packet does, see Packet.Add.
+func (f Frame) Validate() error {
+	if f.Length() > FrameDataMaxSize {
+		return ErrFrameTooLarge
+	}
+	return validateData(f.Data)
+}
+
+// Validate the fields of the data which would otherwise get encoded into a different frame.
+func validateData(data Data) error {
+	switch v := data.(type) {
+	case Handshake:
+		return v.Validate()
+	case *Handshake:
+		return v.Validate()
+	}
+	return nil
+}
+
 func (f Frame) Type() FrameType {
 	if f.Data == nil {
 		return UnknownType
@@ -103,22 +237,25 @@ func (f Frame) Type() FrameType {
 }
 
 func (f Frame) Bytes() []byte {
-	var buf bytes.Buffer
-	if f.Data != nil {
-		ft, data := f.Data.Type(), f.Data.Bytes()
-		dlen := len(data)
-		buf.WriteByte(byte(ft))
-		buf.Write(Uint16ToBytes(uint16(dlen)))
-		buf.Write(data)
-	} else {
-		buf.WriteByte(byte(UnknownType))
-		buf.Write(Uint16ToBytes(0))
+	return f.AppendBytes(nil)
+}
+
+func (f Frame) AppendBytes(dst []byte) []byte {
+	if f.Data == nil {
+		dst = append(dst, byte(UnknownType))
+		return AppendUint16(dst, 0)
 	}
-	return buf.Bytes()
+	// Reserve the length header and fill it once the data has been appended
+	start := len(dst)
+	dst = append(dst, byte(f.Data.Type()), 0, 0)
+	dst = f.Data.AppendBytes(dst)
+	dlen := len(dst) - start - FrameBaseSize
+	binary.BigEndian.PutUint16(dst[start+1:], uint16(dlen))
+	return dst
 }
 
 func DecodeData(ft FrameType, b []byte) (Data, error) {
-	if decode, ok := dataDecoders[ft]; ok {
+	if decode, ok := decoder(ft); ok {
 		return decode(b)
 	}
 	return nil, ErrFrameTypeUnknown
@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -16,6 +17,10 @@ func (unknownData) Bytes() []byte {
 	return make([]byte, 0)
 }
 
+func (unknownData) AppendBytes(dst []byte) []byte {
+	return dst
+}
+
 func TestFrame(t *testing.T) {
 	require := require.New(t)
 
@@ -25,6 +30,31 @@ func TestFrame(t *testing.T) {
 		require.Equal(ErrBufferUnderflow, err)
 	}
 
+	// Test decode on trailing bytes
+	{
+		_, err := Decode(append(Encode(Fin{StreamID: 1}), 0))
+		require.Equal(ErrTrailingBytes, err)
+	}
+
+	// Test decode errors tell the frame type
+	{
+		b := Encode(Fin{StreamID: 1})
+		b[0] = byte(StreamType)
+		_, err := Decode(b)
+		derr, ok := err.(*DecodeError)
+		require.True(ok)
+		require.Equal(StreamType, derr.Type)
+		require.True(errors.Is(err, ErrBufferUnderflow))
+	}
+
+	// Test frames too large
+	{
+		f := New(Stream{StreamID: 1, Chunk: make([]byte, StreamChunkMaxSize+1)})
+		require.Equal(ErrFrameTooLarge, f.Validate())
+		_, err := Decode(f.Bytes())
+		require.Equal(ErrFrameTooLarge, err)
+	}
+
 	// Test decode on nil packet data
 	{
 		f := Frame{}
@@ -0,0 +1,252 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Every decoder takes untrusted bytes off the wire. Whatever the input, decoding must not panic,
+// and a successfully decoded frame must encode back into exactly the same bytes.
+
+func FuzzDecode(f *testing.F) {
+	f.Add(Encode(Fin{StreamID: 1}))
+	f.Add(Encode(Handshake{StreamID: 1, Length: 13, Hash: BytesToMD5Hash([]byte("Hello, world!"))}))
+	f.Add(Encode(HandshakeAck{StreamID: 1, Size: FrameMaxSize}))
+	f.Add(Encode(HandshakeAck{StreamID: 1, Size: FrameMaxSize, Extensions: []FrameType{ExtensionTypeMin}}))
+	f.Add(Encode(Stream{StreamID: 1, Sequence: 2, Offset: 3, Chunk: []byte("Hello, world!")}))
+	f.Add(Encode(StreamAck{StreamID: 1, Sequence: 2}))
+	f.Add(Encode(Repair{StreamID: 1, Sequence: 2, Offset: 3, Count: 4, Length: 5, Parity: []byte("Hello")}))
+	f.Add(Encode(VersionNegotiation{StreamID: 1, Versions: []uint32{1, 2}}))
+	f.Add(Encode(MaxStreams{Count: 1}))
+	f.Add(Encode(StreamsBlocked{Limit: 1}))
+	f.Add(Encode(Shutdown{StreamID: 1, Ack: true}))
+	f.Add(Encode(Refusal{StreamID: 1, Reason: RefusalPeerLimit}))
+	f.Add(Encode(Ping{Ack: true}))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		fr, err := Decode(b)
+		if err != nil {
+			checkDecodeError(t, err)
+			return
+		}
+		if err := fr.Validate(); err != nil {
+			t.Fatalf("decoded frame is invalid: %v", err)
+		}
+		// Handshake padding may be truncated along the path, yet it always gets encoded in full.
+		// Transport parameters are only kept by their values, dropping any unknown ones.
+		if fr.Type() == HandshakeType || fr.Type() == HandshakeAckType {
+			return
+		}
+		if actual := fr.Bytes(); !bytes.Equal(b, actual) {
+			t.Fatalf("round trip mismatch: %x != %x", b, actual)
+		}
+	})
+}
+
+func FuzzDecodePacket(f *testing.F) {
+	p := NewPacket(PacketMaxSize)
+	p.Add(StreamAck{StreamID: 1, Sequence: 1})
+	p.Add(Stream{StreamID: 1, Sequence: 2, Chunk: []byte("Hello, world!")})
+	p.Add(Fin{StreamID: 1})
+	f.Add(p.Bytes())
+	f.Fuzz(func(t *testing.T, b []byte) {
+		frames, err := DecodePacket(b)
+		if err != nil {
+			checkDecodeError(t, err)
+			return
+		}
+		// Skipped non-critical frames don't count towards the decoded bytes
+		size := 0
+		for _, fr := range frames {
+			size += fr.Size()
+		}
+		if size > len(b) {
+			t.Fatalf("decoded %d bytes out of %d", size, len(b))
+		}
+	})
+}
+
+func FuzzDecodeFin(f *testing.F) {
+	f.Add(Fin{StreamID: 1}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeFin(b)
+	})
+}
+
+func FuzzDecodeHandshake(f *testing.F) {
+	f.Add(Handshake{StreamID: 1, Length: 13, Hash: BytesToMD5Hash([]byte("Hello, world!"))}.Bytes())
+	f.Add(Handshake{StreamID: 1, Hash: BytesToMD5Hash(nil), Extensions: []FrameType{ExtensionTypeMin}}.Bytes())
+	f.Fuzz(func(t *testing.T, b []byte) {
+		h, err := DecodeHandshake(b)
+		if err != nil {
+			checkDecodeError(t, err)
+			return
+		}
+		actual, err := DecodeHandshake(h.Bytes())
+		if err != nil {
+			t.Fatalf("re-decode failed: %v", err)
+		}
+		h.Padding, actual.Padding = 0, 0
+		if !reflect.DeepEqual(h, actual) {
+			t.Fatalf("round trip mismatch: %+v != %+v", h, actual)
+		}
+	})
+}
+
+func FuzzDecodeHandshakeAck(f *testing.F) {
+	f.Add(HandshakeAck{StreamID: 1, Size: FrameMaxSize}.Bytes())
+	f.Add(HandshakeAck{StreamID: 1, Size: FrameMaxSize, Params: TransportParams{MaxStreams: 1, Features: FeatureFEC}}.Bytes())
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ha, err := DecodeHandshakeAck(b)
+		if err != nil {
+			checkDecodeError(t, err)
+			return
+		}
+		actual, err := DecodeHandshakeAck(ha.Bytes())
+		if err != nil {
+			t.Fatalf("re-decode failed: %v", err)
+		}
+		if !reflect.DeepEqual(ha, actual) {
+			t.Fatalf("round trip mismatch: %+v != %+v", ha, actual)
+		}
+	})
+}
+
+func FuzzDecodeStream(f *testing.F) {
+	f.Add(Stream{StreamID: 1, Sequence: 2, Offset: 3, Chunk: []byte("Hello, world!")}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeStream(b)
+	})
+}
+
+func FuzzDecodeStreamAck(f *testing.F) {
+	f.Add(StreamAck{StreamID: 1, Sequence: 2}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeStreamAck(b)
+	})
+}
+
+func FuzzDecodeRepair(f *testing.F) {
+	f.Add(Repair{StreamID: 1, Sequence: 2, Offset: 3, Count: 4, Length: 5, Parity: []byte("Hello")}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeRepair(b)
+	})
+}
+
+func FuzzDecodeVersionNegotiation(f *testing.F) {
+	f.Add(VersionNegotiation{StreamID: 1, Versions: []uint32{1, 2}}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeVersionNegotiation(b)
+	})
+}
+
+func FuzzDecodeMaxStreams(f *testing.F) {
+	f.Add(MaxStreams{Count: 1}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeMaxStreams(b)
+	})
+}
+
+func FuzzDecodeStreamsBlocked(f *testing.F) {
+	f.Add(StreamsBlocked{Limit: 1}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeStreamsBlocked(b)
+	})
+}
+
+func FuzzDecodeShutdown(f *testing.F) {
+	f.Add(Shutdown{StreamID: 1}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeShutdown(b)
+	})
+}
+
+func FuzzDecodeRefusal(f *testing.F) {
+	f.Add(Refusal{StreamID: 1, Reason: RefusalDenied}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodeRefusal(b)
+	})
+}
+
+func FuzzDecodePing(f *testing.F) {
+	f.Add(Ping{}.Bytes())
+	fuzzRoundTrip(f, func(b []byte) (Data, error) {
+		return DecodePing(b)
+	})
+}
+
+// Rebuilding out of any decoded repair frame and received chunk must never panic.
+func FuzzRepairRebuild(f *testing.F) {
+	f.Add(Repair{StreamID: 1, Sequence: 2, Offset: 3, Count: 2, Length: 5, Parity: []byte("Hello")}.Bytes(), []byte("Hi"))
+	f.Add(Repair{StreamID: 1, Count: 2}.Bytes(), make([]byte, 100))
+	f.Fuzz(func(t *testing.T, b []byte, chunk []byte) {
+		r, err := DecodeRepair(b)
+		if err != nil {
+			return
+		}
+		s := r.Rebuild(r.Sequence+1, []*Stream{{StreamID: r.StreamID, Sequence: r.Sequence, Chunk: chunk}})
+		if s != nil && len(s.Chunk) > len(r.Parity) {
+			t.Fatalf("rebuilt chunk longer than the parity: %d > %d", len(s.Chunk), len(r.Parity))
+		}
+	})
+}
+
+// Round trip property of the stream frame over arbitrary field values.
+func FuzzStreamRoundTrip(f *testing.F) {
+	f.Add(uint16(1), uint16(2), uint16(3), []byte("Hello, world!"))
+	f.Fuzz(func(t *testing.T, sid, seq, off uint16, chunk []byte) {
+		if len(chunk) > StreamChunkMaxSize {
+			chunk = chunk[:StreamChunkMaxSize]
+		}
+		expected := Stream{StreamID: StreamID(sid), Sequence: seq, Offset: off, Chunk: chunk}
+		fr, err := Decode(Encode(expected))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := fr.Data.(*Stream)
+		if actual.StreamID != expected.StreamID || actual.Sequence != seq || actual.Offset != off {
+			t.Fatalf("round trip mismatch: %+v != %+v", actual, expected)
+		}
+		if !bytes.Equal(actual.Chunk, chunk) {
+			t.Fatalf("chunk mismatch")
+		}
+	})
+}
+
+func fuzzRoundTrip(f *testing.F, decode func([]byte) (Data, error)) {
+	f.Fuzz(func(t *testing.T, b []byte) {
+		data, err := decode(b)
+		if err != nil {
+			checkDecodeError(t, err)
+			return
+		}
+		if actual := data.Bytes(); !bytes.Equal(b, actual) {
+			t.Fatalf("round trip mismatch: %x != %x", b, actual)
+		}
+	})
+}
+
+var decodeErrors = []error{
+	ErrBufferUnderflow,
+	ErrFrameTypeUnknown,
+	ErrFrameTooLarge,
+	ErrTrailingBytes,
+	ErrReservedNonZero,
+	ErrPaddingNonZero,
+	ErrRepairEmpty,
+	ErrVersionsEmpty,
+	ErrExtensionInvalid,
+	ErrParamInvalid,
+	ErrDirectionInvalid,
+}
+
+// Decoding must only ever fail with one of the known errors.
+func checkDecodeError(t *testing.T, err error) {
+	for _, expected := range decodeErrors {
+		if errors.Is(err, expected) {
+			return
+		}
+	}
+	t.Fatalf("unexpected decode error: %v", err)
+}
@@ -1,27 +1,33 @@
 package frame
 
-import (
-	"bytes"
-)
+import "crypto/md5"
 
 const (
-	// StreamID + Length uint16 + Reserved uint8 + MD5 Hash (128-bit)
-	HandshakeBaseSize = StreamIDSize + 19
-	// StreamID + Size uint16
-	HandshakeAckBaseSize = StreamIDSize + 2
-	// The default padding size for the handshake.
+	// StreamID + Version uint32 + Length uint16 + Reserved uint8 + MD5 Hash (128-bit)
+	// + Extension count uint8 + Parameters length uint8
+	HandshakeBaseSize = StreamIDSize + 25
+	// StreamID + Size uint16 + Extension count uint8 + Parameters length uint8
+	HandshakeAckBaseSize = StreamIDSize + 4
+	// The default padding size for the handshake without any extensions or parameters.
 	HandshakeDefaultPaddingSize = FrameDataMaxSize - HandshakeBaseSize
 )
 
 // Handshake frame is the first frame to send to a peer to start the information exchange
 type Handshake struct {
 	StreamID
+	// The protocol version the handshake is offered in.
+	// The peer replies with the version negotiation frame if it doesn't support the version.
+	Version uint32
 	// How much data the peer should receive in a single stream.
 	Length uint16
-	// Reserved for hash related information.
+	// Reserved for hash related information. It must be zero until it's put to use.
 	Reserved uint8
 	// Hash for data integrity check after the peer has received all the data chunks.
 	Hash []byte
+	// Extension frame types we understand, offered for the peer to pick from.
+	Extensions []FrameType
+	// Our transport parameters.
+	Params TransportParams
 	// The received length of the padding. It may not equal to the default padding size.
 	// Padding is used for peer to determine the size of a single packet it could receive.
 	// The peer is expected to return the size back to us by sending the handshake ACK frame.
@@ -29,21 +35,62 @@ type Handshake struct {
 }
 
 func DecodeHandshake(b []byte) (*Handshake, error) {
+	h := &Handshake{}
+	if err := h.Decode(b); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Decode the frame into the receiver. The hash gets copied into the receiver's own
+// hash buffer, which is reused across calls to avoid allocations.
+func (h *Handshake) Decode(b []byte) error {
 	length := len(b)
 	if length < HandshakeBaseSize {
-		return nil, ErrBufferUnderflow
+		return ErrBufferUnderflow
+	}
+	if length > FrameDataMaxSize {
+		return ErrTrailingBytes
+	}
+	if b[8] != 0 {
+		return ErrReservedNonZero
+	}
+	// The extensions and the parameters follow the hash, then comes the padding
+	off := HandshakeBaseSize - 1 + int(b[HandshakeBaseSize-2])
+	if length < off+1 {
+		return ErrBufferUnderflow
+	}
+	if err := checkExtensions(b[HandshakeBaseSize-1 : off]); err != nil {
+		return err
 	}
-	sid, err := DecodeStreamID(b)
+	var params TransportParams
+	n, err := decodeParams(&params, b[off:])
 	if err != nil {
-		return nil, err
+		return err
+	}
+	size := off + n
+	for _, c := range b[size:] {
+		if c != 0 {
+			return ErrPaddingNonZero
+		}
+	}
+	h.StreamID = StreamID(BytesToUint16(b))
+	h.Version = BytesToUint32(b[2:])
+	h.Length = BytesToUint16(b[6:])
+	h.Reserved = b[8]
+	h.Hash = append(h.Hash[:0], b[9:HandshakeBaseSize-2]...)
+	h.Extensions = decodeExtensions(h.Extensions[:0], b[HandshakeBaseSize-1:off])
+	h.Params = params
+	h.Padding = length - size
+	return nil
+}
+
+// Validate the hash is of MD5 size, since the hash takes up its fixed size on the wire.
+func (h Handshake) Validate() error {
+	if len(h.Hash) != md5.Size {
+		return ErrHashSize
 	}
-	return &Handshake{
-		StreamID: sid,
-		Length:   BytesToUint16(b[2:]),
-		Reserved: b[4],
-		Hash:     b[5:HandshakeBaseSize],
-		Padding:  length - HandshakeBaseSize,
-	}, nil
+	return nil
 }
 
 func (Handshake) Type() FrameType {
@@ -51,13 +98,22 @@ func (Handshake) Type() FrameType {
 }
 
 func (h Handshake) Bytes() []byte {
-	var buf bytes.Buffer
-	buf.Write(h.StreamID.Bytes())
-	buf.Write(Uint16ToBytes(h.Length))
-	buf.WriteByte(h.Reserved)
-	buf.Write(h.Hash)
-	buf.Write(make([]byte, HandshakeDefaultPaddingSize))
-	return buf.Bytes()
+	return h.AppendBytes(make([]byte, 0, FrameDataMaxSize))
+}
+
+func (h Handshake) AppendBytes(dst []byte) []byte {
+	start := len(dst)
+	dst = h.StreamID.AppendBytes(dst)
+	dst = AppendUint32(dst, h.Version)
+	dst = AppendUint16(dst, h.Length)
+	dst = append(dst, h.Reserved)
+	// The hash takes up its fixed size whatever its length, see Validate
+	var hash [md5.Size]byte
+	copy(hash[:], h.Hash)
+	dst = append(dst, hash[:]...)
+	dst = appendExtensions(dst, h.Extensions)
+	dst = appendParams(dst, h.Params)
+	return append(dst, make([]byte, FrameDataMaxSize-(len(dst)-start))...)
 }
 
 // Handshake ACK frame is the first frame to send back to the peer.
@@ -65,18 +121,45 @@ type HandshakeAck struct {
 	StreamID
 	// The size of data we can receive in a single frame.
 	Size uint16
+	// Extension frame types picked out of the ones offered by the handshake.
+	Extensions []FrameType
+	// Our transport parameters.
+	Params TransportParams
 }
 
 func DecodeHandshakeAck(b []byte) (*HandshakeAck, error) {
+	ha := &HandshakeAck{}
+	if err := ha.Decode(b); err != nil {
+		return nil, err
+	}
+	return ha, nil
+}
+
+// Decode the frame into the receiver.
+func (ha *HandshakeAck) Decode(b []byte) error {
 	if len(b) < HandshakeAckBaseSize {
-		return nil, ErrBufferUnderflow
+		return ErrBufferUnderflow
+	}
+	off := HandshakeAckBaseSize - 1 + int(b[HandshakeAckBaseSize-2])
+	if len(b) < off+1 {
+		return ErrBufferUnderflow
 	}
-	sid, err := DecodeStreamID(b)
+	if err := checkExtensions(b[HandshakeAckBaseSize-1 : off]); err != nil {
+		return err
+	}
+	var params TransportParams
+	n, err := decodeParams(&params, b[off:])
 	if err != nil {
-		return nil, err
+		return err
 	}
-	size := BytesToUint16(b[2:])
-	return &HandshakeAck{sid, size}, nil
+	if len(b) > off+n {
+		return ErrTrailingBytes
+	}
+	ha.StreamID = StreamID(BytesToUint16(b))
+	ha.Size = BytesToUint16(b[2:])
+	ha.Extensions = decodeExtensions(ha.Extensions[:0], b[HandshakeAckBaseSize-1:off])
+	ha.Params = params
+	return nil
 }
 
 func (HandshakeAck) Type() FrameType {
@@ -84,8 +167,40 @@ func (HandshakeAck) Type() FrameType {
 }
 
 func (ha HandshakeAck) Bytes() []byte {
-	var buf bytes.Buffer
-	buf.Write(ha.StreamID.Bytes())
-	buf.Write(Uint16ToBytes(ha.Size))
-	return buf.Bytes()
+	return ha.AppendBytes(make([]byte, 0, HandshakeAckBaseSize+len(ha.Extensions)))
+}
+
+func (ha HandshakeAck) AppendBytes(dst []byte) []byte {
+	dst = ha.StreamID.AppendBytes(dst)
+	dst = AppendUint16(dst, ha.Size)
+	dst = appendExtensions(dst, ha.Extensions)
+	return appendParams(dst, ha.Params)
+}
+
+func checkExtensions(b []byte) error {
+	for _, c := range b {
+		if !FrameType(c).Extension() {
+			return ErrExtensionInvalid
+		}
+	}
+	return nil
+}
+
+func decodeExtensions(dst []FrameType, b []byte) []FrameType {
+	for _, c := range b {
+		dst = append(dst, FrameType(c))
+	}
+	return dst
+}
+
+// Extensions past the maximum count get left out.
+func appendExtensions(dst []byte, extensions []FrameType) []byte {
+	if len(extensions) > ExtensionMaxCount {
+		extensions = extensions[:ExtensionMaxCount]
+	}
+	dst = append(dst, byte(len(extensions)))
+	for _, ft := range extensions {
+		dst = append(dst, byte(ft))
+	}
+	return dst
 }
@@ -13,6 +13,23 @@ func TestHandshake(t *testing.T) {
 	{
 		_, err := DecodeHandshake(make([]byte, 0))
 		require.Equal(ErrBufferUnderflow, err)
+
+		b := Handshake{StreamID: 1, Hash: BytesToMD5Hash(nil)}.Bytes()
+		_, err = DecodeHandshake(append(b, 0))
+		require.Equal(ErrTrailingBytes, err)
+
+		b = Handshake{StreamID: 1, Reserved: 1, Hash: BytesToMD5Hash(nil)}.Bytes()
+		_, err = DecodeHandshake(b)
+		require.Equal(ErrReservedNonZero, err)
+
+		b = Handshake{StreamID: 1, Hash: BytesToMD5Hash(nil)}.Bytes()
+		b[len(b)-1] = 1
+		_, err = DecodeHandshake(b)
+		require.Equal(ErrPaddingNonZero, err)
+
+		b = Handshake{StreamID: 1, Hash: BytesToMD5Hash(nil), Extensions: []FrameType{StreamType}}.Bytes()
+		_, err = DecodeHandshake(b)
+		require.Equal(ErrExtensionInvalid, err)
 	}
 
 	// Test encode/decode corectness
@@ -22,6 +39,7 @@ func TestHandshake(t *testing.T) {
 
 		expected := Handshake{
 			StreamID: StreamID(1),
+			Version:  ProtocolVersion,
 			Length:   uint16(len(data)),
 			Reserved: 0,
 			Hash:     hash,
@@ -30,11 +48,38 @@ func TestHandshake(t *testing.T) {
 		actual, err := DecodeHandshake(expected.Bytes())
 		require.Nil(err)
 		require.Equal(expected.StreamID, actual.StreamID)
+		require.Equal(expected.Version, actual.Version)
 		require.Equal(expected.Length, actual.Length)
 		require.Equal(expected.Reserved, actual.Reserved)
 		require.Equal(expected.Hash, actual.Hash)
 		require.Equal(expected.Padding, actual.Padding)
 	}
+
+	// Test extensions take up the padding
+	{
+		expected := Handshake{
+			StreamID:   StreamID(1),
+			Hash:       BytesToMD5Hash(nil),
+			Extensions: []FrameType{ExtensionTypeMin, ExtensionTypeMin | ExtensionNonCritical},
+		}
+		b := expected.Bytes()
+		require.Len(b, FrameDataMaxSize)
+		actual, err := DecodeHandshake(b)
+		require.Nil(err)
+		require.Equal(expected.Extensions, actual.Extensions)
+		require.Equal(HandshakeDefaultPaddingSize-2, actual.Padding)
+	}
+
+	// Test hashes not of MD5 size are invalid, while they still take up the hash's fixed size
+	{
+		for _, hash := range [][]byte{nil, make([]byte, 15), make([]byte, FrameMaxSize)} {
+			h := Handshake{StreamID: StreamID(1), Hash: hash}
+			require.Equal(ErrHashSize, New(h).Validate())
+			require.Equal(ErrHashSize, NewPacket(PacketMaxSize).Add(h))
+			require.Len(h.Bytes(), FrameDataMaxSize)
+		}
+		require.Nil(New(Handshake{StreamID: StreamID(1), Hash: BytesToMD5Hash(nil)}).Validate())
+	}
 }
 
 func TestHandshakeAck(t *testing.T) {
@@ -44,17 +89,25 @@ func TestHandshakeAck(t *testing.T) {
 	{
 		_, err := DecodeHandshakeAck(make([]byte, 0))
 		require.Equal(ErrBufferUnderflow, err)
+
+		b := HandshakeAck{StreamID: 1, Extensions: []FrameType{ExtensionTypeMin}}.Bytes()
+		_, err = DecodeHandshakeAck(b[:len(b)-1])
+		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodeHandshakeAck(append(b, 0))
+		require.Equal(ErrTrailingBytes, err)
 	}
 
 	// Test encode/decode corectness
 	{
 		expected := HandshakeAck{
-			StreamID: StreamID(1),
-			Size:     65535,
+			StreamID:   StreamID(1),
+			Size:       65535,
+			Extensions: []FrameType{ExtensionTypeMin},
 		}
 		actual, err := DecodeHandshakeAck(expected.Bytes())
 		require.Nil(err)
 		require.Equal(expected.StreamID, actual.StreamID)
 		require.Equal(expected.Size, actual.Size)
+		require.Equal(expected.Extensions, actual.Extensions)
 	}
 }
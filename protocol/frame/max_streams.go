@@ -0,0 +1,99 @@
+package frame
+
+import "errors"
+
+const (
+	// Direction uint8 + Count uint16
+	MaxStreamsBaseSize = 3
+	// Direction uint8 + Limit uint16
+	StreamsBlockedBaseSize = 3
+)
+
+var ErrDirectionInvalid = errors.New("invalid stream direction")
+
+// Max streams frame raises the number of concurrent streams in the direction the peer may open towards us.
+// The limit only ever goes up, a lower count than the current limit is ignored.
+type MaxStreams struct {
+	Direction
+	Count uint16
+}
+
+func DecodeMaxStreams(b []byte) (*MaxStreams, error) {
+	ms := &MaxStreams{}
+	if err := ms.Decode(b); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Decode the frame into the receiver.
+func (ms *MaxStreams) Decode(b []byte) error {
+	if len(b) < MaxStreamsBaseSize {
+		return ErrBufferUnderflow
+	}
+	if len(b) > MaxStreamsBaseSize {
+		return ErrTrailingBytes
+	}
+	if Direction(b[0]) > Unidirectional {
+		return ErrDirectionInvalid
+	}
+	ms.Direction = Direction(b[0])
+	ms.Count = BytesToUint16(b[1:])
+	return nil
+}
+
+func (MaxStreams) Type() FrameType {
+	return MaxStreamsType
+}
+
+func (ms MaxStreams) Bytes() []byte {
+	return ms.AppendBytes(make([]byte, 0, MaxStreamsBaseSize))
+}
+
+func (ms MaxStreams) AppendBytes(dst []byte) []byte {
+	return AppendUint16(append(dst, byte(ms.Direction)), ms.Count)
+}
+
+// Streams blocked frame tells the peer we'd like to open more streams than its limit allows,
+// so the peer may decide to raise the limit with the max streams frame.
+type StreamsBlocked struct {
+	Direction
+	// The limit we're blocked at.
+	Limit uint16
+}
+
+func DecodeStreamsBlocked(b []byte) (*StreamsBlocked, error) {
+	sb := &StreamsBlocked{}
+	if err := sb.Decode(b); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// Decode the frame into the receiver.
+func (sb *StreamsBlocked) Decode(b []byte) error {
+	if len(b) < StreamsBlockedBaseSize {
+		return ErrBufferUnderflow
+	}
+	if len(b) > StreamsBlockedBaseSize {
+		return ErrTrailingBytes
+	}
+	if Direction(b[0]) > Unidirectional {
+		return ErrDirectionInvalid
+	}
+	sb.Direction = Direction(b[0])
+	sb.Limit = BytesToUint16(b[1:])
+	return nil
+}
+
+func (StreamsBlocked) Type() FrameType {
+	return StreamsBlockedType
+}
+
+func (sb StreamsBlocked) Bytes() []byte {
+	return sb.AppendBytes(make([]byte, 0, StreamsBlockedBaseSize))
+}
+
+func (sb StreamsBlocked) AppendBytes(dst []byte) []byte {
+	return AppendUint16(append(dst, byte(sb.Direction)), sb.Limit)
+}
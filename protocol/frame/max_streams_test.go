@@ -0,0 +1,59 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxStreams(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		_, err := DecodeMaxStreams(make([]byte, 0))
+		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodeMaxStreams(make([]byte, MaxStreamsBaseSize+1))
+		require.Equal(ErrTrailingBytes, err)
+	}
+
+	// Test encode/decode corectness
+	{
+		expected := MaxStreams{Direction: Unidirectional, Count: 300}
+		actual, err := DecodeMaxStreams(expected.Bytes())
+		require.Nil(err)
+		require.Equal(expected.Direction, actual.Direction)
+		require.Equal(expected.Count, actual.Count)
+
+		b := expected.Bytes()
+		b[0] = 2
+		_, err = DecodeMaxStreams(b)
+		require.Equal(ErrDirectionInvalid, err)
+	}
+}
+
+func TestStreamsBlocked(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		_, err := DecodeStreamsBlocked(make([]byte, 0))
+		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodeStreamsBlocked(make([]byte, StreamsBlockedBaseSize+1))
+		require.Equal(ErrTrailingBytes, err)
+	}
+
+	// Test encode/decode corectness
+	{
+		expected := StreamsBlocked{Direction: Unidirectional, Limit: 300}
+		actual, err := DecodeStreamsBlocked(expected.Bytes())
+		require.Nil(err)
+		require.Equal(expected.Direction, actual.Direction)
+		require.Equal(expected.Limit, actual.Limit)
+
+		b := expected.Bytes()
+		b[0] = 2
+		_, err = DecodeStreamsBlocked(b)
+		require.Equal(ErrDirectionInvalid, err)
+	}
+}
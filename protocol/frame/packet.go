@@ -0,0 +1,107 @@
+package frame
+
+import "errors"
+
+const (
+	// Maximum size of a single packet, which is a single UDP datagram carrying one or more frames.
+	// It's bound by the path MTU, hence it equals to the maximum size of a single frame.
+	PacketMaxSize = FrameMaxSize
+)
+
+var ErrPacketFull = errors.New("packet full")
+
+// Packet coalesces multiple frames into a single datagram, so small frames such as ACKs
+// don't each cost a whole datagram on the wire.
+type Packet struct {
+	buf     []byte
+	maxSize int
+	count   int
+}
+
+func NewPacket(maxSize int) *Packet {
+	if maxSize <= 0 {
+		maxSize = PacketMaxSize
+	}
+	return &Packet{
+		buf:     make([]byte, 0, maxSize),
+		maxSize: maxSize,
+	}
+}
+
+// Add appends the frame to the packet, failing with ErrPacketFull if it doesn't fit,
+// with ErrFrameTooLarge if it wouldn't fit in any packet, or with the error of the invalid frame, see Frame.Validate.
+// An empty packet always accepts a valid frame, even if it exceeds the maximum packet size,
+// so a single frame larger than a shrunk maximum packet size still gets sent out on its own.
+func (p *Packet) Add(data Data) error {
+	if err := validateData(data); err != nil {
+		return err
+	}
+	n := len(p.buf)
+	p.buf = EncodeTo(p.buf, data)
+	if len(p.buf)-n-FrameBaseSize > FrameDataMaxSize {
+		p.buf = p.buf[:n]
+		return ErrFrameTooLarge
+	}
+	if p.count > 0 && len(p.buf) > p.maxSize {
+		p.buf = p.buf[:n]
+		return ErrPacketFull
+	}
+	p.count++
+	return nil
+}
+
+// The number of frames in the packet.
+func (p *Packet) Count() int {
+	return p.count
+}
+
+// The size of the packet on the wire.
+func (p *Packet) Size() int {
+	return len(p.buf)
+}
+
+// The remaining space in the packet before it reaches the maximum packet size.
+func (p *Packet) Remaining() int {
+	if n := p.maxSize - len(p.buf); n > 0 {
+		return n
+	}
+	return 0
+}
+
+func (p *Packet) MaxSize() int {
+	return p.maxSize
+}
+
+func (p *Packet) Empty() bool {
+	return p.count <= 0
+}
+
+func (p *Packet) Bytes() []byte {
+	return p.buf
+}
+
+func (p *Packet) Reset() {
+	p.buf = p.buf[:0]
+	p.count = 0
+}
+
+// DecodePacket decodes every frame coalesced in a single datagram.
+// Just like Decode, the decoded frames own their bytes.
+func DecodePacket(b []byte) ([]*Frame, error) {
+	if len(b) <= 0 {
+		return nil, ErrBufferUnderflow
+	}
+	frames := make([]*Frame, 0, 1)
+	for len(b) > 0 {
+		f, n, err := decodeNext(b)
+		if err != nil {
+			return frames, err
+		}
+		// Unknown non-critical frames get skipped, so the packet may end up without any frame
+		if f != nil {
+			frames = append(frames, f)
+		}
+		b = b[n:]
+	}
+	return frames, nil
+}
@@ -0,0 +1,70 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacket(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		_, err := DecodePacket(make([]byte, 0))
+		require.Equal(ErrBufferUnderflow, err)
+	}
+
+	// Test encode/decode corectness
+	{
+		p := NewPacket(PacketMaxSize)
+		require.Nil(p.Add(StreamAck{StreamID: 1, Sequence: 1}))
+		require.Nil(p.Add(StreamAck{StreamID: 1, Sequence: 2}))
+		require.Nil(p.Add(Stream{StreamID: 2, Sequence: 3, Chunk: []byte("Hello, world!")}))
+		require.Equal(3, p.Count())
+
+		frames, err := DecodePacket(p.Bytes())
+		require.Nil(err)
+		require.Len(frames, 3)
+		require.Equal(StreamAckType, frames[0].Type())
+		require.Equal(uint16(1), frames[0].Data.(*StreamAck).Sequence)
+		require.Equal(StreamAckType, frames[1].Type())
+		require.Equal(uint16(2), frames[1].Data.(*StreamAck).Sequence)
+		require.Equal(StreamType, frames[2].Type())
+		require.Equal([]byte("Hello, world!"), frames[2].Data.(*Stream).Chunk)
+	}
+
+	// Test packet size limit
+	{
+		p := NewPacket(PacketMaxSize)
+		chunk := make([]byte, StreamChunkMaxSize)
+		require.Nil(p.Add(Stream{StreamID: 1, Chunk: chunk}))
+		require.Equal(PacketMaxSize, p.Size())
+		require.Equal(ErrPacketFull, p.Add(StreamAck{StreamID: 1}))
+		require.Equal(1, p.Count())
+
+		p.Reset()
+		require.True(p.Empty())
+		require.Nil(p.Add(StreamAck{StreamID: 1}))
+	}
+
+	// Test frames too large for any packet
+	{
+		p := NewPacket(PacketMaxSize)
+		chunk := make([]byte, StreamChunkMaxSize+1)
+		require.Equal(ErrFrameTooLarge, p.Add(Stream{StreamID: 1, Chunk: chunk}))
+		require.True(p.Empty())
+		require.Zero(p.Size())
+	}
+}
+
+func BenchmarkPacket(b *testing.B) {
+	p := NewPacket(PacketMaxSize)
+	ack := &StreamAck{StreamID: 1}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if p.Add(ack) != nil {
+			p.Reset()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package frame
+
+import (
+	"errors"
+	"time"
+)
+
+// ParamID identifies a single transport parameter in the TLV list.
+type ParamID uint8
+
+const (
+	// Zero is never sent out, it only stands for the unset parameter.
+	ParamUnknown ParamID = iota
+	ParamMaxStreams
+	ParamInitialWindow
+	ParamIdleTimeout
+	ParamMaxFrameSize
+	ParamAckDelay
+	ParamFeatures
+	ParamMaxUniStreams
+)
+
+const (
+	// ID uint8 + Length uint8
+	ParamBaseSize = 2
+	// Maximum size of the encoded transport parameters, as told by the uint8 length prefix.
+	ParamsMaxSize = 0xff
+)
+
+var ErrParamInvalid = errors.New("malformed transport parameter")
+
+// Features are optional protocol features, advertised as a bit set.
+type Features uint32
+
+const (
+	// The peer understands repair frames, so forward error correction may be enabled towards it.
+	FeatureFEC Features = 1 << iota
+)
+
+func (f Features) Has(feature Features) bool {
+	return f&feature == feature
+}
+
+// Transport parameters tell the peer about our limits, so neither side has to guess the other's.
+// They're exchanged as a TLV list with the handshake and handshake ACK frames.
+// Zero values are left out of the list, and the receiving side treats them as unset.
+// Unknown parameters get skipped, allowing new parameters to be added later on.
+type TransportParams struct {
+	// Maximum number of concurrent bidirectional streams the peer may open towards us.
+	MaxStreams uint16
+	// Maximum number of concurrent unidirectional streams the peer may open towards us.
+	MaxUniStreams uint16
+	// Receive window of every stream, the most bytes the peer may have sent us which our reader
+	// hasn't taken yet. A single message of the peer never exceeds it either.
+	InitialWindow uint32
+	// Peers close the connection once there has been no activity for this long,
+	// in millisecond precision.
+	IdleTimeout time.Duration
+	// Maximum size of a single frame we're willing to receive, including the headers.
+	MaxFrameSize uint16
+	// Maximum time we delay our ACK frames for, in millisecond precision.
+	// The peer waits for it on top of the round-trip time before retransmitting.
+	AckDelay time.Duration
+	// Optional features we support.
+	Features Features
+}
+
+// Decode the TLV list into the receiver, overwriting only the parameters present in the list.
+func (tp *TransportParams) Decode(b []byte) error {
+	for len(b) > 0 {
+		if len(b) < ParamBaseSize {
+			return ErrParamInvalid
+		}
+		id, length := ParamID(b[0]), int(b[1])
+		b = b[ParamBaseSize:]
+		if len(b) < length {
+			return ErrParamInvalid
+		}
+		if err := tp.decodeParam(id, b[:length]); err != nil {
+			return err
+		}
+		b = b[length:]
+	}
+	return nil
+}
+
+func (tp *TransportParams) decodeParam(id ParamID, v []byte) error {
+	size := 0
+	switch id {
+	case ParamMaxStreams, ParamMaxUniStreams, ParamMaxFrameSize, ParamAckDelay:
+		size = 2
+	case ParamInitialWindow, ParamIdleTimeout, ParamFeatures:
+		size = 4
+	default:
+		return nil
+	}
+	if len(v) != size {
+		return ErrParamInvalid
+	}
+	switch id {
+	case ParamMaxStreams:
+		tp.MaxStreams = BytesToUint16(v)
+	case ParamInitialWindow:
+		tp.InitialWindow = BytesToUint32(v)
+	case ParamIdleTimeout:
+		tp.IdleTimeout = time.Duration(BytesToUint32(v)) * time.Millisecond
+	case ParamMaxFrameSize:
+		tp.MaxFrameSize = BytesToUint16(v)
+	case ParamAckDelay:
+		tp.AckDelay = time.Duration(BytesToUint16(v)) * time.Millisecond
+	case ParamFeatures:
+		tp.Features = Features(BytesToUint32(v))
+	case ParamMaxUniStreams:
+		tp.MaxUniStreams = BytesToUint16(v)
+	}
+	return nil
+}
+
+// Append the TLV list of the set parameters to the buffer.
+func (tp TransportParams) AppendBytes(dst []byte) []byte {
+	if tp.MaxStreams > 0 {
+		dst = AppendUint16(append(dst, byte(ParamMaxStreams), 2), tp.MaxStreams)
+	}
+	if tp.InitialWindow > 0 {
+		dst = AppendUint32(append(dst, byte(ParamInitialWindow), 4), tp.InitialWindow)
+	}
+	if ms := tp.IdleTimeout.Milliseconds(); ms > 0 {
+		dst = AppendUint32(append(dst, byte(ParamIdleTimeout), 4), uint32(ms))
+	}
+	if tp.MaxFrameSize > 0 {
+		dst = AppendUint16(append(dst, byte(ParamMaxFrameSize), 2), tp.MaxFrameSize)
+	}
+	if ms := tp.AckDelay.Milliseconds(); ms > 0 {
+		dst = AppendUint16(append(dst, byte(ParamAckDelay), 2), uint16(ms))
+	}
+	if tp.Features > 0 {
+		dst = AppendUint32(append(dst, byte(ParamFeatures), 4), uint32(tp.Features))
+	}
+	if tp.MaxUniStreams > 0 {
+		dst = AppendUint16(append(dst, byte(ParamMaxUniStreams), 2), tp.MaxUniStreams)
+	}
+	return dst
+}
+
+// Decode the length-prefixed TLV list at the start of the buffer, returning the bytes it occupies.
+func decodeParams(tp *TransportParams, b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, ErrBufferUnderflow
+	}
+	size := 1 + int(b[0])
+	if len(b) < size {
+		return 0, ErrBufferUnderflow
+	}
+	*tp = TransportParams{}
+	if err := tp.Decode(b[1:size]); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func appendParams(dst []byte, tp TransportParams) []byte {
+	start := len(dst)
+	dst = tp.AppendBytes(append(dst, 0))
+	dst[start] = byte(len(dst) - start - 1)
+	return dst
+}
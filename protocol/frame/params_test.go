@@ -0,0 +1,66 @@
+package frame
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportParams(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		tp := TransportParams{}
+		require.Equal(ErrParamInvalid, tp.Decode([]byte{byte(ParamMaxStreams)}))
+		require.Equal(ErrParamInvalid, tp.Decode([]byte{byte(ParamMaxStreams), 2, 0}))
+		require.Equal(ErrParamInvalid, tp.Decode([]byte{byte(ParamMaxStreams), 1, 0}))
+	}
+
+	// Test encode/decode corectness
+	{
+		expected := TransportParams{
+			MaxStreams:    100,
+			MaxUniStreams: 10,
+			InitialWindow: 65535,
+			IdleTimeout:   30 * time.Second,
+			MaxFrameSize:  FrameMaxSize,
+			AckDelay:      25 * time.Millisecond,
+			Features:      FeatureFEC,
+		}
+		actual := TransportParams{}
+		require.Nil(actual.Decode(expected.AppendBytes(nil)))
+		require.Equal(expected, actual)
+		require.True(actual.Features.Has(FeatureFEC))
+	}
+
+	// Test unset and unknown parameters
+	{
+		require.Empty(TransportParams{}.AppendBytes(nil))
+
+		b := []byte{0xff, 3, 1, 2, 3}
+		b = TransportParams{MaxStreams: 1}.AppendBytes(b)
+		actual := TransportParams{}
+		require.Nil(actual.Decode(b))
+		require.Equal(TransportParams{MaxStreams: 1}, actual)
+	}
+
+	// Test parameters carried by the handshake frames
+	{
+		params := TransportParams{MaxStreams: 8, IdleTimeout: time.Minute}
+		h, err := DecodeHandshake(Handshake{
+			StreamID:   1,
+			Hash:       BytesToMD5Hash(nil),
+			Extensions: []FrameType{ExtensionTypeMin},
+			Params:     params,
+		}.Bytes())
+		require.Nil(err)
+		require.Equal(params, h.Params)
+		require.Equal([]FrameType{ExtensionTypeMin}, h.Extensions)
+
+		ha, err := DecodeHandshakeAck(HandshakeAck{StreamID: 1, Params: params}.Bytes())
+		require.Nil(err)
+		require.Equal(params, ha.Params)
+	}
+}
@@ -0,0 +1,55 @@
+package frame
+
+const (
+	// Flags uint8
+	PingBaseSize = 1
+	// The flag telling the frame answers the peer's ping frame.
+	pingAckFlag = 1
+)
+
+// Ping frame keeps the connection alive while there's nothing else to send, such as while a transfer
+// is paused. The peer answers it with a ping frame of its own having the ACK flag set, so a peer
+// which has gone away stops being heard from and its idle timeout closes the connection.
+type Ping struct {
+	Ack bool
+}
+
+func DecodePing(b []byte) (*Ping, error) {
+	p := &Ping{}
+	if err := p.Decode(b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Decode the frame into the receiver.
+func (p *Ping) Decode(b []byte) error {
+	if len(b) < PingBaseSize {
+		return ErrBufferUnderflow
+	}
+	if len(b) > PingBaseSize {
+		return ErrTrailingBytes
+	}
+	flags := b[0]
+	if flags&^pingAckFlag != 0 {
+		return ErrReservedNonZero
+	}
+	p.Ack = flags&pingAckFlag != 0
+	return nil
+}
+
+func (Ping) Type() FrameType {
+	return PingType
+}
+
+func (p Ping) Bytes() []byte {
+	return p.AppendBytes(make([]byte, 0, PingBaseSize))
+}
+
+func (p Ping) AppendBytes(dst []byte) []byte {
+	var flags byte
+	if p.Ack {
+		flags |= pingAckFlag
+	}
+	return append(dst, flags)
+}
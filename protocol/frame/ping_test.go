@@ -0,0 +1,33 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		_, err := DecodePing(make([]byte, 0))
+		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodePing(make([]byte, PingBaseSize+1))
+		require.Equal(ErrTrailingBytes, err)
+	}
+
+	// Test encode/decode corectness
+	{
+		for _, expected := range []Ping{{}, {Ack: true}} {
+			actual, err := DecodePing(expected.Bytes())
+			require.Nil(err)
+			require.Equal(expected, *actual)
+		}
+
+		b := Ping{Ack: true}.Bytes()
+		b[0] |= 2
+		_, err := DecodePing(b)
+		require.Equal(ErrReservedNonZero, err)
+	}
+}
@@ -0,0 +1,82 @@
+package frame
+
+import "fmt"
+
+// StreamID uint16 + Reason uint8
+const RefusalSize = StreamIDSize + 1
+
+// Why the handshake of a new peer has been refused.
+type RefusalReason uint8
+
+const (
+	RefusalUnspecified RefusalReason = iota
+	// The listener has as many peers as it allows.
+	RefusalPeerLimit
+	// The listener has as many peers from the same IP address as it allows.
+	RefusalSourceLimit
+	// The listener doesn't accept peers from the address.
+	RefusalDenied
+	// The listener is shutting down, so it doesn't accept any new peers.
+	RefusalShutdown
+)
+
+var refusalReasonNames = map[RefusalReason]string{
+	RefusalUnspecified: "unspecified",
+	RefusalPeerLimit:   "peer limit reached",
+	RefusalSourceLimit: "source limit reached",
+	RefusalDenied:      "denied",
+	RefusalShutdown:    "shutting down",
+}
+
+func (r RefusalReason) String() string {
+	if name, ok := refusalReasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason %d", uint8(r))
+}
+
+// Refusal frame replies to the handshake of a peer the listener doesn't accept, so the peer gives up
+// right away rather than retrying the handshake until it times out. Reasons unknown to the receiver
+// are kept as they are, so new reasons may be added without breaking the older peers.
+type Refusal struct {
+	// The stream of the refused handshake.
+	StreamID
+	Reason RefusalReason
+}
+
+func DecodeRefusal(b []byte) (*Refusal, error) {
+	r := &Refusal{}
+	if err := r.Decode(b); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Decode the frame into the receiver.
+func (r *Refusal) Decode(b []byte) error {
+	if len(b) < RefusalSize {
+		return ErrBufferUnderflow
+	}
+	if len(b) > RefusalSize {
+		return ErrTrailingBytes
+	}
+	sid, err := DecodeStreamID(b)
+	if err != nil {
+		return err
+	}
+	r.StreamID = sid
+	r.Reason = RefusalReason(b[StreamIDSize])
+	return nil
+}
+
+func (Refusal) Type() FrameType {
+	return RefusalType
+}
+
+func (r Refusal) Bytes() []byte {
+	return r.AppendBytes(make([]byte, 0, RefusalSize))
+}
+
+func (r Refusal) AppendBytes(dst []byte) []byte {
+	return append(r.StreamID.AppendBytes(dst), byte(r.Reason))
+}
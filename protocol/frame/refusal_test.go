@@ -0,0 +1,30 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefusal(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		_, err := DecodeRefusal(make([]byte, 0))
+		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodeRefusal(make([]byte, RefusalSize+1))
+		require.Equal(ErrTrailingBytes, err)
+	}
+
+	// Test encode/decode corectness, keeping the reasons we don't know about
+	{
+		for _, expected := range []Refusal{{StreamID: 1, Reason: RefusalPeerLimit}, {StreamID: 2, Reason: RefusalReason(200)}} {
+			actual, err := DecodeRefusal(expected.Bytes())
+			require.Nil(err)
+			require.Equal(expected, *actual)
+		}
+		require.Equal("denied", RefusalDenied.String())
+		require.Equal("reason 200", RefusalReason(200).String())
+	}
+}
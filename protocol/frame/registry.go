@@ -0,0 +1,71 @@
+package frame
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	// Frame types from here on are reserved for application-defined extensions,
+	// the frame types below are reserved for the protocol itself.
+	ExtensionTypeMin FrameType = 0x80
+	// Extension frame types with this bit set are non-critical,
+	// meaning peers not knowing them skip them instead of rejecting the whole packet.
+	ExtensionNonCritical FrameType = 0x40
+	// Maximum number of extensions offered in a single handshake.
+	ExtensionMaxCount = 0x100 - int(ExtensionTypeMin)
+)
+
+var (
+	ErrFrameTypeReserved   = errors.New("frame type reserved for the protocol")
+	ErrFrameTypeRegistered = errors.New("frame type already registered")
+	ErrDecoderMissing      = errors.New("missing decoder")
+)
+
+// Decoder decodes the frame data, excluding the frame headers.
+// The decoded data must own its bytes, since the buffer gets reused for the next datagrams.
+type Decoder func(b []byte) (Data, error)
+
+var decodersMu sync.RWMutex
+
+// Register the decoder of an application-defined extension frame type.
+// Frame types are registered once, usually from an init function.
+func Register(ft FrameType, decode Decoder) error {
+	if !ft.Extension() {
+		return ErrFrameTypeReserved
+	}
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	if _, ok := dataDecoders[ft]; ok {
+		return ErrFrameTypeRegistered
+	}
+	if decode == nil {
+		return ErrDecoderMissing
+	}
+	dataDecoders[ft] = decode
+	return nil
+}
+
+// Registered reports whether the frame type is known, either as part of the protocol or as an extension.
+func Registered(ft FrameType) bool {
+	_, ok := decoder(ft)
+	return ok
+}
+
+func decoder(ft FrameType) (Decoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	decode, ok := dataDecoders[ft]
+	return decode, ok
+}
+
+// Extension reports whether the frame type is in the range reserved for extensions.
+func (ft FrameType) Extension() bool {
+	return ft >= ExtensionTypeMin
+}
+
+// Critical reports whether the frame must be understood by the peer receiving it.
+// Every frame type of the protocol itself is critical.
+func (ft FrameType) Critical() bool {
+	return !ft.Extension() || ft&ExtensionNonCritical == 0
+}
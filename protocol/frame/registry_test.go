@@ -0,0 +1,91 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Extension frame carrying an opaque payload.
+type extensionData struct {
+	ft      FrameType
+	Payload []byte
+}
+
+func (e extensionData) Type() FrameType {
+	return e.ft
+}
+
+func (e extensionData) Bytes() []byte {
+	return e.AppendBytes(nil)
+}
+
+func (e extensionData) AppendBytes(dst []byte) []byte {
+	return append(dst, e.Payload...)
+}
+
+func TestRegister(t *testing.T) {
+	require := require.New(t)
+	ft := ExtensionTypeMin + 1
+	defer delete(dataDecoders, ft)
+
+	// Test reserved frame types
+	{
+		require.Equal(ErrFrameTypeReserved, Register(StreamType, nil))
+		require.Equal(ErrFrameTypeReserved, Register(ExtensionTypeMin-1, nil))
+		require.Equal(ErrDecoderMissing, Register(ft, nil))
+		require.False(Registered(ft))
+	}
+
+	// Test encode/decode corectness
+	{
+		_, err := Decode(Encode(extensionData{ft, []byte("Hello")}))
+		require.Equal(ErrFrameTypeUnknown, err)
+
+		require.Nil(Register(ft, func(b []byte) (Data, error) {
+			return &extensionData{ft, append([]byte(nil), b...)}, nil
+		}))
+		require.Equal(ErrFrameTypeRegistered, Register(ft, nil))
+		require.True(Registered(ft))
+
+		f, err := Decode(Encode(extensionData{ft, []byte("Hello")}))
+		require.Nil(err)
+		require.Equal(ft, f.Type())
+		require.Equal([]byte("Hello"), f.Data.(*extensionData).Payload)
+	}
+}
+
+func TestDecodePacketExtensions(t *testing.T) {
+	require := require.New(t)
+	critical := ExtensionTypeMin + 2
+	optional := critical | ExtensionNonCritical
+	require.True(critical.Critical())
+	require.False(optional.Critical())
+	require.True(StreamType.Critical())
+
+	// Test unknown non-critical frames get skipped
+	{
+		p := NewPacket(PacketMaxSize)
+		require.Nil(p.Add(StreamAck{StreamID: 1, Sequence: 1}))
+		require.Nil(p.Add(extensionData{optional, []byte("Hello")}))
+		require.Nil(p.Add(StreamAck{StreamID: 1, Sequence: 2}))
+		frames, err := DecodePacket(p.Bytes())
+		require.Nil(err)
+		require.Len(frames, 2)
+		require.Equal(uint16(2), frames[1].Data.(*StreamAck).Sequence)
+
+		frames, err = DecodePacket(Encode(extensionData{optional, nil}))
+		require.Nil(err)
+		require.Empty(frames)
+	}
+
+	// Test unknown critical frames get rejected
+	{
+		p := NewPacket(PacketMaxSize)
+		require.Nil(p.Add(StreamAck{StreamID: 1, Sequence: 1}))
+		require.Nil(p.Add(extensionData{critical, []byte("Hello")}))
+		frames, err := DecodePacket(p.Bytes())
+		require.Equal(ErrFrameTypeUnknown, err)
+		require.Len(frames, 1)
+	}
+}
@@ -0,0 +1,135 @@
+package frame
+
+import "errors"
+
+const (
+	// StreamID + Sequence uint16 + Offset uint16 + Count uint5 + Length uint11
+	RepairBaseSize = StreamIDSize + 6
+	// Maximum number of stream frames protected by a single repair frame.
+	RepairMaxCount = 1<<5 - 1
+	// Maximum chunk length a repair frame is able to rebuild.
+	RepairMaxLength = 1<<11 - 1
+)
+
+var (
+	ErrRepairOverflow = errors.New("repair count or length overflow")
+	ErrRepairEmpty    = errors.New("repair group empty")
+)
+
+// Repair frame carries the XOR parity of a group of stream frames with consecutive sequences,
+// allowing the peer to rebuild any single lost chunk in the group without a retransmission.
+type Repair struct {
+	StreamID
+	// The sequence of the first stream frame in the group.
+	Sequence uint16
+	// XOR of the offsets of the stream frames in the group.
+	Offset uint16
+	// The number of stream frames in the group.
+	Count uint8
+	// XOR of the chunk lengths of the stream frames in the group.
+	Length uint16
+	// XOR of the chunks in the group, each padded with zeros to the length of the longest chunk.
+	Parity []byte
+}
+
+func DecodeRepair(b []byte) (*Repair, error) {
+	r := &Repair{}
+	if err := r.Decode(b); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Decode the frame into the receiver. The parity gets copied into the receiver's own
+// parity buffer, which is reused across calls to avoid allocations.
+func (r *Repair) Decode(b []byte) error {
+	if len(b) < RepairBaseSize {
+		return ErrBufferUnderflow
+	}
+	packed := BytesToUint16(b[6:])
+	if packed>>11 == 0 {
+		return ErrRepairEmpty
+	}
+	r.StreamID = StreamID(BytesToUint16(b))
+	r.Sequence = BytesToUint16(b[2:])
+	r.Offset = BytesToUint16(b[4:])
+	r.Count = uint8(packed >> 11)
+	r.Length = packed & RepairMaxLength
+	r.Parity = append(r.Parity[:0], b[RepairBaseSize:]...)
+	return nil
+}
+
+// Add the stream frame into the parity of the group.
+func (r *Repair) Add(s *Stream) error {
+	if r.Count >= RepairMaxCount || len(s.Chunk) > RepairMaxLength {
+		return ErrRepairOverflow
+	}
+	r.Offset ^= s.Offset
+	r.Length ^= uint16(len(s.Chunk))
+	for len(r.Parity) < len(s.Chunk) {
+		r.Parity = append(r.Parity, 0)
+	}
+	for i, c := range s.Chunk {
+		r.Parity[i] ^= c
+	}
+	r.Count++
+	return nil
+}
+
+// Covers reports whether the stream frame sequence belongs to the group.
+func (r *Repair) Covers(seq uint16) bool {
+	return seq-r.Sequence < uint16(r.Count)
+}
+
+// Rebuild the single missing stream frame in the group out of the other received frames.
+// Returns nil if the parity is too short for the received chunks or the rebuilt one,
+// which a repair frame from a well-behaved peer never is.
+func (r *Repair) Rebuild(seq uint16, received []*Stream) *Stream {
+	off, length := r.Offset, r.Length
+	for _, s := range received {
+		if len(s.Chunk) > len(r.Parity) {
+			return nil
+		}
+		off ^= s.Offset
+		length ^= uint16(len(s.Chunk))
+	}
+	if int(length) > len(r.Parity) {
+		return nil
+	}
+	chunk := append([]byte(nil), r.Parity...)
+	for _, s := range received {
+		for i, c := range s.Chunk {
+			chunk[i] ^= c
+		}
+	}
+	return &Stream{
+		StreamID: r.StreamID,
+		Sequence: seq,
+		Offset:   off,
+		Chunk:    chunk[:length],
+	}
+}
+
+func (r *Repair) Reset(seq uint16) {
+	r.Sequence = seq
+	r.Offset = 0
+	r.Count = 0
+	r.Length = 0
+	r.Parity = r.Parity[:0]
+}
+
+func (Repair) Type() FrameType {
+	return RepairType
+}
+
+func (r Repair) Bytes() []byte {
+	return r.AppendBytes(make([]byte, 0, RepairBaseSize+len(r.Parity)))
+}
+
+func (r Repair) AppendBytes(dst []byte) []byte {
+	dst = r.StreamID.AppendBytes(dst)
+	dst = AppendUint16(dst, r.Sequence)
+	dst = AppendUint16(dst, r.Offset)
+	dst = AppendUint16(dst, uint16(r.Count)<<11|r.Length&RepairMaxLength)
+	return append(dst, r.Parity...)
+}
@@ -0,0 +1,78 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		_, err := DecodeRepair(make([]byte, 0))
+		require.Equal(ErrBufferUnderflow, err)
+	}
+
+	// Test encode/decode corectness
+	{
+		expected := Repair{
+			StreamID: StreamID(1),
+			Sequence: 2,
+			Offset:   3,
+			Count:    4,
+			Length:   5,
+			Parity:   []byte("Hello"),
+		}
+		actual, err := DecodeRepair(expected.Bytes())
+		require.Nil(err)
+		require.Equal(expected.StreamID, actual.StreamID)
+		require.Equal(expected.Sequence, actual.Sequence)
+		require.Equal(expected.Offset, actual.Offset)
+		require.Equal(expected.Count, actual.Count)
+		require.Equal(expected.Length, actual.Length)
+		require.Equal(expected.Parity, actual.Parity)
+	}
+
+	// Test rebuilding every single missing frame in a group
+	{
+		group := []*Stream{
+			{StreamID: 1, Sequence: 65534, Offset: 0, Chunk: []byte("Hello, ")},
+			{StreamID: 1, Sequence: 65535, Offset: 7, Chunk: []byte("world")},
+			{StreamID: 1, Sequence: 0, Offset: 12, Chunk: []byte("!")},
+		}
+		r := &Repair{StreamID: 1}
+		r.Reset(group[0].Sequence)
+		for _, s := range group {
+			require.Nil(r.Add(s))
+		}
+		require.True(r.Covers(0))
+		require.False(r.Covers(1))
+		for i, expected := range group {
+			received := make([]*Stream, 0, len(group)-1)
+			received = append(received, group[:i]...)
+			received = append(received, group[i+1:]...)
+			actual := r.Rebuild(expected.Sequence, received)
+			require.Equal(expected, actual)
+		}
+	}
+
+	// Test the parity too short for the received chunks or the rebuilt one
+	{
+		r := &Repair{StreamID: 1, Count: 2, Parity: []byte("Hello")}
+		require.Nil(r.Rebuild(1, []*Stream{{StreamID: 1, Chunk: make([]byte, 100)}}))
+		r.Length = 4
+		require.Nil(r.Rebuild(1, []*Stream{{StreamID: 1, Chunk: []byte("Hi")}}))
+	}
+
+	// Test group overflow
+	{
+		r := &Repair{}
+		require.Equal(ErrRepairOverflow, r.Add(&Stream{Chunk: make([]byte, RepairMaxLength+1)}))
+		for i := 0; i < RepairMaxCount; i++ {
+			require.Nil(r.Add(&Stream{}))
+		}
+		require.Equal(ErrRepairOverflow, r.Add(&Stream{}))
+	}
+}
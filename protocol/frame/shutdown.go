@@ -0,0 +1,61 @@
+package frame
+
+const (
+	// StreamID uint16 + Flags uint8
+	ShutdownBaseSize = StreamIDSize + 1
+	// The flag telling the frame acknowledges the peer's shutdown frame.
+	shutdownAckFlag = 1
+)
+
+// Shutdown frame tells the peer we're done sending data over the stream, while we still receive
+// the peer's data, like shutting down the write side of a TCP connection. The peer acknowledges it
+// with a shutdown frame of its own having the ACK flag set, so the frame gets sent out until then.
+type Shutdown struct {
+	StreamID
+	Ack bool
+}
+
+func DecodeShutdown(b []byte) (*Shutdown, error) {
+	s := &Shutdown{}
+	if err := s.Decode(b); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Decode the frame into the receiver.
+func (s *Shutdown) Decode(b []byte) error {
+	if len(b) < ShutdownBaseSize {
+		return ErrBufferUnderflow
+	}
+	if len(b) > ShutdownBaseSize {
+		return ErrTrailingBytes
+	}
+	sid, err := DecodeStreamID(b)
+	if err != nil {
+		return err
+	}
+	flags := b[StreamIDSize]
+	if flags&^shutdownAckFlag != 0 {
+		return ErrReservedNonZero
+	}
+	s.StreamID = sid
+	s.Ack = flags&shutdownAckFlag != 0
+	return nil
+}
+
+func (Shutdown) Type() FrameType {
+	return ShutdownType
+}
+
+func (s Shutdown) Bytes() []byte {
+	return s.AppendBytes(make([]byte, 0, ShutdownBaseSize))
+}
+
+func (s Shutdown) AppendBytes(dst []byte) []byte {
+	var flags byte
+	if s.Ack {
+		flags |= shutdownAckFlag
+	}
+	return append(s.StreamID.AppendBytes(dst), flags)
+}
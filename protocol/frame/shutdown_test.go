@@ -0,0 +1,33 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		_, err := DecodeShutdown(make([]byte, 0))
+		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodeShutdown(make([]byte, ShutdownBaseSize+1))
+		require.Equal(ErrTrailingBytes, err)
+	}
+
+	// Test encode/decode corectness
+	{
+		for _, expected := range []Shutdown{{StreamID: 1}, {StreamID: 2, Ack: true}} {
+			actual, err := DecodeShutdown(expected.Bytes())
+			require.Nil(err)
+			require.Equal(expected, *actual)
+		}
+
+		b := Shutdown{StreamID: 1, Ack: true}.Bytes()
+		b[StreamIDSize] |= 2
+		_, err := DecodeShutdown(b)
+		require.Equal(ErrReservedNonZero, err)
+	}
+}
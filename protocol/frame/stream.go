@@ -1,7 +1,5 @@
 package frame
 
-import "bytes"
-
 const (
 	// uint16
 	StreamIDSize = 2
@@ -14,8 +12,29 @@ const (
 )
 
 // Stream ID is used for multiplexing purposes between streams in a single connection.
+// The lowest bit tells who has initiated the stream, set for the client and clear for the server.
+// The second lowest bit tells the direction of the stream, set for unidirectional streams.
 type StreamID uint16
 
+// Direction of the data flowing through a stream.
+type Direction uint8
+
+const (
+	// Both sides send data over bidirectional streams.
+	Bidirectional Direction = iota
+	// Only the initiating side sends data over unidirectional streams.
+	Unidirectional
+)
+
+var directionNames = map[Direction]string{
+	Bidirectional:  "bidirectional",
+	Unidirectional: "unidirectional",
+}
+
+func (d Direction) String() string {
+	return directionNames[d]
+}
+
 func DecodeStreamID(b []byte) (StreamID, error) {
 	if len(b) < StreamIDSize {
 		return 0, ErrBufferUnderflow
@@ -23,6 +42,23 @@ func DecodeStreamID(b []byte) (StreamID, error) {
 	return StreamID(BytesToUint16(b)), nil
 }
 
+// Build the stream ID out of the sequence number among the streams of the same initiator and direction.
+func NewStreamID(n int, client bool, dir Direction) StreamID {
+	sid := n<<2 | int(dir)<<1
+	if client {
+		sid |= 1
+	}
+	return StreamID(sid)
+}
+
+func (sid StreamID) ClientInitiated() bool {
+	return sid&1 != 0
+}
+
+func (sid StreamID) Direction() Direction {
+	return Direction(sid >> 1 & 1)
+}
+
 func (sid StreamID) Int() int {
 	return int(sid)
 }
@@ -35,6 +71,10 @@ func (sid StreamID) Bytes() []byte {
 	return Uint16ToBytes(uint16(sid))
 }
 
+func (sid StreamID) AppendBytes(dst []byte) []byte {
+	return AppendUint16(dst, uint16(sid))
+}
+
 // Stream frames are chunks of data being sent over the wire, providing stream of data.
 type Stream struct {
 	StreamID
@@ -47,21 +87,32 @@ type Stream struct {
 }
 
 func DecodeStream(b []byte) (*Stream, error) {
-	if len(b) < StreamBaseSize {
-		return nil, ErrBufferUnderflow
-	}
-	sid, err := DecodeStreamID(b)
-	if err != nil {
+	s := &Stream{}
+	if err := s.Decode(b); err != nil {
 		return nil, err
 	}
-	seq := BytesToUint16(b[2:])
-	off := BytesToUint16(b[4:])
+	return s, nil
+}
+
+// Decode the frame into the receiver. The chunk gets copied into the receiver's own
+// chunk buffer, which is reused across calls to avoid allocations.
+func (s *Stream) Decode(b []byte) error {
+	if len(b) < StreamBaseSize {
+		return ErrBufferUnderflow
+	}
 	length := int(BytesToUint16(b[6:]))
 	chunk := b[8:]
 	if len(chunk) < length {
-		return nil, ErrBufferUnderflow
+		return ErrBufferUnderflow
+	}
+	if len(chunk) > length {
+		return ErrTrailingBytes
 	}
-	return &Stream{sid, seq, off, chunk}, nil
+	s.StreamID = StreamID(BytesToUint16(b))
+	s.Sequence = BytesToUint16(b[2:])
+	s.Offset = BytesToUint16(b[4:])
+	s.Chunk = append(s.Chunk[:0], chunk[:length]...)
+	return nil
 }
 
 func (s Stream) Length() int {
@@ -76,18 +127,15 @@ func (Stream) Type() FrameType {
 }
 
 func (s Stream) Bytes() []byte {
-	var buf bytes.Buffer
-	buf.Write(s.StreamID.Bytes())
-	buf.Write(Uint16ToBytes(s.Sequence))
-	buf.Write(Uint16ToBytes(s.Offset))
-	if s.Chunk != nil {
-		clen := uint16(s.Length())
-		buf.Write(Uint16ToBytes(clen))
-		buf.Write(s.Chunk)
-	} else {
-		buf.Write(Uint16ToBytes(0))
-	}
-	return buf.Bytes()
+	return s.AppendBytes(make([]byte, 0, StreamBaseSize+s.Length()))
+}
+
+func (s Stream) AppendBytes(dst []byte) []byte {
+	dst = s.StreamID.AppendBytes(dst)
+	dst = AppendUint16(dst, s.Sequence)
+	dst = AppendUint16(dst, s.Offset)
+	dst = AppendUint16(dst, uint16(s.Length()))
+	return append(dst, s.Chunk...)
 }
 
 // Stream ACK frames are ACK frames to inform the peer that the we peer have successfully received the chunk packet.
@@ -98,15 +146,24 @@ type StreamAck struct {
 }
 
 func DecodeStreamAck(b []byte) (*StreamAck, error) {
+	sa := &StreamAck{}
+	if err := sa.Decode(b); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// Decode the frame into the receiver.
+func (sa *StreamAck) Decode(b []byte) error {
 	if len(b) < StreamAckBaseSize {
-		return nil, ErrBufferUnderflow
+		return ErrBufferUnderflow
 	}
-	sid, err := DecodeStreamID(b)
-	if err != nil {
-		return nil, err
+	if len(b) > StreamAckBaseSize {
+		return ErrTrailingBytes
 	}
-	seq := BytesToUint16(b[2:])
-	return &StreamAck{sid, seq}, nil
+	sa.StreamID = StreamID(BytesToUint16(b))
+	sa.Sequence = BytesToUint16(b[2:])
+	return nil
 }
 
 func (StreamAck) Type() FrameType {
@@ -114,8 +171,10 @@ func (StreamAck) Type() FrameType {
 }
 
 func (sa StreamAck) Bytes() []byte {
-	var buf bytes.Buffer
-	buf.Write(sa.StreamID.Bytes())
-	buf.Write(Uint16ToBytes(sa.Sequence))
-	return buf.Bytes()
+	return sa.AppendBytes(make([]byte, 0, StreamAckBaseSize))
+}
+
+func (sa StreamAck) AppendBytes(dst []byte) []byte {
+	dst = sa.StreamID.AppendBytes(dst)
+	return AppendUint16(dst, sa.Sequence)
 }
@@ -14,6 +14,12 @@ func TestStream(t *testing.T) {
 	{
 		_, err := DecodeStream(make([]byte, 0))
 		require.Equal(ErrBufferUnderflow, err)
+
+		b := Stream{StreamID: 1, Chunk: []byte(data)}.Bytes()
+		_, err = DecodeStream(b[:len(b)-1])
+		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodeStream(append(b, 0))
+		require.Equal(ErrTrailingBytes, err)
 	}
 
 	// Test encode/decode corectness
@@ -31,6 +37,17 @@ func TestStream(t *testing.T) {
 		require.Equal(expected.Offset, actual.Offset)
 		require.Equal(expected.Chunk, actual.Chunk)
 	}
+
+	// Test the decoded frame owns its chunk
+	{
+		b := Stream{StreamID: 1, Chunk: []byte(data)}.Bytes()
+		actual, err := DecodeStream(b)
+		require.Nil(err)
+		for i := range b {
+			b[i] = 0
+		}
+		require.Equal([]byte(data), actual.Chunk)
+	}
 }
 
 func TestStreamAck(t *testing.T) {
@@ -54,3 +71,82 @@ func TestStreamAck(t *testing.T) {
 		require.Equal(expected.Sequence, actual.Sequence)
 	}
 }
+
+func TestStreamDecodeInto(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		var s Stream
+		require.Equal(ErrBufferUnderflow, s.Decode(make([]byte, 0)))
+	}
+
+	// Test the decoded chunk doesn't alias the buffer
+	{
+		b := Stream{StreamID: 1, Sequence: 2, Offset: 3, Chunk: []byte("Hello, world!")}.Bytes()
+		var actual Stream
+		require.Nil(actual.Decode(b))
+		for i := range b {
+			b[i] = 0
+		}
+		require.Equal(StreamID(1), actual.StreamID)
+		require.Equal(uint16(2), actual.Sequence)
+		require.Equal(uint16(3), actual.Offset)
+		require.Equal([]byte("Hello, world!"), actual.Chunk)
+	}
+
+	// Test encode/decode doesn't allocate in steady state
+	{
+		s := &Stream{StreamID: 1, Chunk: make([]byte, StreamChunkMaxSize)}
+		buf := make([]byte, 0, FrameMaxSize)
+		var actual Stream
+		allocs := testing.AllocsPerRun(100, func() {
+			buf = EncodeTo(buf[:0], s)
+			if err := actual.Decode(buf[FrameBaseSize:]); err != nil {
+				t.Fatal(err)
+			}
+		})
+		require.Zero(allocs)
+	}
+}
+
+func BenchmarkStreamEncode(b *testing.B) {
+	s := &Stream{StreamID: 1, Chunk: make([]byte, StreamChunkMaxSize)}
+	buf := make([]byte, 0, FrameMaxSize)
+	b.SetBytes(FrameMaxSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = EncodeTo(buf[:0], s)
+	}
+}
+
+func BenchmarkStreamDecode(b *testing.B) {
+	buf := Stream{StreamID: 1, Chunk: make([]byte, StreamChunkMaxSize)}.Bytes()
+	var s Stream
+	b.SetBytes(FrameMaxSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := s.Decode(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStreamID(t *testing.T) {
+	require := require.New(t)
+
+	// Test the initiator and the direction are encoded in the lowest bits
+	for _, client := range []bool{true, false} {
+		for _, dir := range []Direction{Bidirectional, Unidirectional} {
+			for n := 0; n < 3; n++ {
+				sid := NewStreamID(n, client, dir)
+				require.Equal(client, sid.ClientInitiated())
+				require.Equal(dir, sid.Direction())
+				require.Equal(n, sid.Int()>>2)
+			}
+		}
+	}
+	require.Equal(StreamID(1), NewStreamID(0, true, Bidirectional))
+	require.Equal(StreamID(3), NewStreamID(0, true, Unidirectional))
+	require.Equal(StreamID(4), NewStreamID(1, false, Bidirectional))
+}
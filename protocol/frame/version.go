@@ -0,0 +1,88 @@
+package frame
+
+import "errors"
+
+const (
+	// The protocol version of the frames defined in this package.
+	ProtocolVersion uint32 = 1
+	// Size of a single protocol version.
+	VersionSize = 4
+	// StreamID + Count uint8
+	VersionNegotiationBaseSize = StreamIDSize + 1
+	// Maximum number of versions offered in a single version negotiation frame.
+	VersionNegotiationMaxCount = (FrameDataMaxSize - VersionNegotiationBaseSize) / VersionSize
+)
+
+var ErrVersionsEmpty = errors.New("no versions offered")
+
+// Version negotiation frame is sent back in reply to a handshake offered in a protocol version
+// we don't support, listing the versions we do support in the order of preference.
+type VersionNegotiation struct {
+	// The stream the unsupported handshake was sent for.
+	StreamID
+	Versions []uint32
+}
+
+func DecodeVersionNegotiation(b []byte) (*VersionNegotiation, error) {
+	vn := &VersionNegotiation{}
+	if err := vn.Decode(b); err != nil {
+		return nil, err
+	}
+	return vn, nil
+}
+
+// Decode the frame into the receiver, reusing the receiver's versions buffer.
+func (vn *VersionNegotiation) Decode(b []byte) error {
+	if len(b) < VersionNegotiationBaseSize {
+		return ErrBufferUnderflow
+	}
+	count := int(b[2])
+	if count <= 0 {
+		return ErrVersionsEmpty
+	}
+	size := VersionNegotiationBaseSize + count*VersionSize
+	if len(b) < size {
+		return ErrBufferUnderflow
+	}
+	if len(b) > size {
+		return ErrTrailingBytes
+	}
+	vn.StreamID = StreamID(BytesToUint16(b))
+	vn.Versions = vn.Versions[:0]
+	for i := VersionNegotiationBaseSize; i < size; i += VersionSize {
+		vn.Versions = append(vn.Versions, BytesToUint32(b[i:]))
+	}
+	return nil
+}
+
+// Supports reports whether the version is listed in the frame.
+func (vn VersionNegotiation) Supports(version uint32) bool {
+	for _, v := range vn.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (VersionNegotiation) Type() FrameType {
+	return VersionNegotiationType
+}
+
+func (vn VersionNegotiation) Bytes() []byte {
+	return vn.AppendBytes(make([]byte, 0, VersionNegotiationBaseSize+len(vn.Versions)*VersionSize))
+}
+
+// Versions past the maximum count get left out.
+func (vn VersionNegotiation) AppendBytes(dst []byte) []byte {
+	versions := vn.Versions
+	if len(versions) > VersionNegotiationMaxCount {
+		versions = versions[:VersionNegotiationMaxCount]
+	}
+	dst = vn.StreamID.AppendBytes(dst)
+	dst = append(dst, byte(len(versions)))
+	for _, v := range versions {
+		dst = AppendUint32(dst, v)
+	}
+	return dst
+}
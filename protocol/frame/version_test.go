@@ -0,0 +1,40 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionNegotiation(t *testing.T) {
+	require := require.New(t)
+
+	// Test decode sanity check
+	{
+		_, err := DecodeVersionNegotiation(make([]byte, 0))
+		require.Equal(ErrBufferUnderflow, err)
+
+		_, err = DecodeVersionNegotiation(VersionNegotiation{StreamID: 1}.Bytes())
+		require.Equal(ErrVersionsEmpty, err)
+
+		b := VersionNegotiation{StreamID: 1, Versions: []uint32{1}}.Bytes()
+		_, err = DecodeVersionNegotiation(b[:len(b)-1])
+		require.Equal(ErrBufferUnderflow, err)
+		_, err = DecodeVersionNegotiation(append(b, 0))
+		require.Equal(ErrTrailingBytes, err)
+	}
+
+	// Test encode/decode corectness
+	{
+		expected := VersionNegotiation{
+			StreamID: StreamID(1),
+			Versions: []uint32{3, ProtocolVersion, 0xffffffff},
+		}
+		actual, err := DecodeVersionNegotiation(expected.Bytes())
+		require.Nil(err)
+		require.Equal(expected.StreamID, actual.StreamID)
+		require.Equal(expected.Versions, actual.Versions)
+		require.True(actual.Supports(ProtocolVersion))
+		require.False(actual.Supports(2))
+	}
+}
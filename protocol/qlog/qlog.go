@@ -0,0 +1,270 @@
+// Package qlog writes the traces of the peers' connections in the qlog format, see
+// https://datatracker.ietf.org/doc/draft-ietf-quic-qlog-main-schema/, as JSON text sequences (RFC 7464).
+// The events follow the QUIC event definitions where the protocol has a counterpart of them,
+// the frames without one are written as unknown frames with their own fields.
+package qlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire/interop"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version of the qlog schema the traces follow.
+const Version = "0.3"
+
+// Every record of a JSON text sequence starts with the record separator.
+const recordSeparator = 0x1e
+
+var _ interop.Tracer = (*Tracer)(nil)
+
+// Tracer writes the trace of a single peer's connection. It's safe for concurrent use.
+type Tracer struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	c     io.Closer
+	start time.Time
+	// The first error writing the trace, after which nothing more gets written.
+	err    error
+	closed bool
+}
+
+// Create the tracer of the peer at the address, writing the trace into w starting with the header.
+// The writes are buffered until the tracer gets closed, which closes w too if it's an io.Closer.
+func NewTracer(w io.Writer, raddr *net.UDPAddr, client bool) *Tracer {
+	t := &Tracer{
+		w:     bufio.NewWriter(w),
+		start: time.Now(),
+	}
+	if c, ok := w.(io.Closer); ok {
+		t.c = c
+	}
+	vantage := "server"
+	if client {
+		vantage = "client"
+	}
+	t.write(map[string]any{
+		"qlog_version": Version,
+		"qlog_format":  "JSON-SEQ",
+		"title":        "reliable-udp",
+		"trace": map[string]any{
+			"vantage_point": map[string]any{"name": "reliable-udp", "type": vantage},
+			"common_fields": map[string]any{
+				"group_id":       raddr.String(),
+				"protocol_type":  []string{"RUDP"},
+				"time_format":    "relative",
+				"reference_time": milliseconds(time.Duration(t.start.UnixNano())),
+			},
+		},
+	})
+	return t
+}
+
+// Dir creates the tracer of every peer writing into its own file in the directory,
+// named after the peer's address and the vantage point. The peers whose file can't be created go untraced.
+func Dir(dir string) interop.TracerFunc {
+	return func(raddr *net.UDPAddr, client bool) interop.Tracer {
+		vantage := "server"
+		if client {
+			vantage = "client"
+		}
+		addr := strings.NewReplacer(":", "_", "[", "", "]", "").Replace(raddr.String())
+		name := fmt.Sprintf("%s_%s_%d.sqlog", addr, vantage, time.Now().UnixNano())
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil
+		}
+		return NewTracer(f, raddr, client)
+	}
+}
+
+// The first error writing the trace.
+func (t *Tracer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+func (t *Tracer) PacketSent(size int, frames []frame.Data) {
+	t.event("transport:packet_sent", packet(size, frames))
+}
+
+func (t *Tracer) PacketReceived(size int, frames []frame.Data) {
+	t.event("transport:packet_received", packet(size, frames))
+}
+
+func (t *Tracer) FrameParsed(data frame.Data) {
+	t.event("transport:frames_processed", map[string]any{
+		"frames": []map[string]any{frameObject(data)},
+	})
+}
+
+// The lost frame has gone unacknowledged past the retransmission timeout,
+// which is the counterpart of the probe timeout of QUIC.
+func (t *Tracer) LossDetected(data frame.Data) {
+	t.event("recovery:packet_lost", map[string]any{
+		"header":  map[string]any{"packet_type": "unknown"},
+		"frames":  []map[string]any{frameObject(data)},
+		"trigger": "pto_expired",
+	})
+}
+
+func (t *Tracer) RTTUpdated(latest, smoothed, variance time.Duration) {
+	t.event("recovery:metrics_updated", map[string]any{
+		"latest_rtt":   milliseconds(latest),
+		"smoothed_rtt": milliseconds(smoothed),
+		"rtt_variance": milliseconds(variance),
+	})
+}
+
+func (t *Tracer) CongestionWindowUpdated(window int) {
+	t.event("recovery:metrics_updated", map[string]any{
+		"congestion_window": window,
+	})
+}
+
+func (t *Tracer) StreamStateUpdated(sid frame.StreamID, state interop.StreamState) {
+	t.event("transport:stream_state_updated", map[string]any{
+		"stream_id":   sid.Uint16(),
+		"stream_type": sid.Direction().String(),
+		"new":         state.String(),
+	})
+}
+
+// Close ends the trace, flushing it out. The events after it get ignored.
+func (t *Tracer) Close() {
+	t.event("connectivity:connection_closed", map[string]any{})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if err := t.w.Flush(); err != nil && t.err == nil {
+		t.err = err
+	}
+	if t.c != nil {
+		if err := t.c.Close(); err != nil && t.err == nil {
+			t.err = err
+		}
+	}
+}
+
+func (t *Tracer) event(name string, data map[string]any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.write(map[string]any{
+		"time": milliseconds(time.Since(t.start)),
+		"name": name,
+		"data": data,
+	})
+}
+
+// Write the record out, unless writing has failed before. The caller holds the lock, if there's anyone else.
+func (t *Tracer) write(record map[string]any) {
+	if t.err != nil {
+		return
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		t.err = err
+		return
+	}
+	t.w.WriteByte(recordSeparator)
+	t.w.Write(b)
+	if err := t.w.WriteByte('\n'); err != nil {
+		t.err = err
+	}
+}
+
+func packet(size int, frames []frame.Data) map[string]any {
+	objects := make([]map[string]any, len(frames))
+	for i, d := range frames {
+		objects[i] = frameObject(d)
+	}
+	return map[string]any{
+		"header": map[string]any{"packet_type": "unknown"},
+		"raw":    map[string]any{"length": size},
+		"frames": objects,
+	}
+}
+
+// The qlog frame of the frame. Every frame keeps the name of its frame type,
+// which tells apart the frames written as unknown frames.
+func frameObject(d frame.Data) map[string]any {
+	ft := d.Type()
+	obj := map[string]any{
+		"frame_type":     "unknown",
+		"raw_frame_type": uint8(ft),
+		"name":           ft.String(),
+	}
+	switch v := d.(type) {
+	case *frame.Fin:
+		if v.StreamID == 0 {
+			obj["frame_type"] = "connection_close"
+			obj["error_space"] = "transport"
+			obj["error_code"] = 0
+		} else {
+			obj["stream_id"] = v.StreamID.Uint16()
+		}
+	case *frame.Handshake:
+		obj["stream_id"] = v.StreamID.Uint16()
+		obj["version"] = v.Version
+		obj["length"] = v.Length
+	case *frame.HandshakeAck:
+		obj["stream_id"] = v.StreamID.Uint16()
+		obj["size"] = v.Size
+	case *frame.Stream:
+		obj["frame_type"] = "stream"
+		obj["stream_id"] = v.StreamID.Uint16()
+		obj["sequence"] = v.Sequence
+		obj["offset"] = v.Offset
+		obj["length"] = len(v.Chunk)
+	case *frame.StreamAck:
+		obj["stream_id"] = v.StreamID.Uint16()
+		obj["sequence"] = v.Sequence
+	case *frame.Repair:
+		obj["stream_id"] = v.StreamID.Uint16()
+		obj["sequence"] = v.Sequence
+		obj["offset"] = v.Offset
+		obj["count"] = v.Count
+		obj["length"] = v.Length
+	case *frame.VersionNegotiation:
+		obj["stream_id"] = v.StreamID.Uint16()
+		obj["versions"] = v.Versions
+	case *frame.MaxStreams:
+		obj["frame_type"] = "max_streams"
+		obj["stream_type"] = v.Direction.String()
+		obj["maximum"] = v.Count
+	case *frame.StreamsBlocked:
+		obj["frame_type"] = "streams_blocked"
+		obj["stream_type"] = v.Direction.String()
+		obj["limit"] = v.Limit
+	case *frame.Shutdown:
+		obj["stream_id"] = v.StreamID.Uint16()
+		obj["ack"] = v.Ack
+	case *frame.Refusal:
+		obj["stream_id"] = v.StreamID.Uint16()
+		obj["reason"] = v.Reason.String()
+	case *frame.Ping:
+		obj["frame_type"] = "ping"
+		obj["ack"] = v.Ack
+	}
+	return obj
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,158 @@
+package qlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"reliable-udp/protocol/wire"
+	"reliable-udp/protocol/wire/interop"
+	"reliable-udp/util/simnet"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Buffer closed by the tracer, safe to read once closed.
+type buffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *buffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+// Split the JSON text sequence into its records.
+func records(t *testing.T, b []byte) []map[string]any {
+	var recs []map[string]any
+	for _, rec := range bytes.Split(b, []byte{recordSeparator})[1:] {
+		require.Equal(t, byte('\n'), rec[len(rec)-1])
+		var m map[string]any
+		require.Nil(t, json.Unmarshal(rec, &m))
+		recs = append(recs, m)
+	}
+	return recs
+}
+
+func TestTracer(t *testing.T) {
+	require := require.New(t)
+	n := simnet.New(1, simnet.Config{Loss: 0.05, Latency: 5 * time.Millisecond})
+	stop := n.Realtime(time.Millisecond)
+	defer stop()
+	var mu sync.Mutex
+	traces := make(map[bool]*buffer)
+	tracer := func(raddr *net.UDPAddr, client bool) interop.Tracer {
+		mu.Lock()
+		defer mu.Unlock()
+		traces[client] = &buffer{}
+		return NewTracer(traces[client], raddr, client)
+	}
+	serve := func() *wire.Listener {
+		conn, err := n.Listen(nil)
+		require.Nil(err)
+		l := wire.NewListener(&wire.Config{Tracer: tracer})
+		require.Nil(l.Serve(conn))
+		t.Cleanup(func() {
+			l.Close()
+		})
+		return l
+	}
+	a, b := serve(), serve()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	data := make([]byte, 100000)
+	_, err = rand.Read(data)
+	require.Nil(err)
+	cherr := make(chan error, 1)
+	go func() {
+		s, err := apeer.OpenStreamContext(ctx)
+		if err != nil {
+			cherr <- err
+			return
+		}
+		if _, err := s.WriteContext(ctx, data); err != nil {
+			cherr <- err
+			return
+		}
+		cherr <- s.Close()
+	}()
+	bpeer, err := b.AcceptContext(ctx)
+	require.Nil(err)
+	s, err := bpeer.AcceptStreamContext(ctx)
+	require.Nil(err)
+	got, err := io.ReadAll(s)
+	require.Nil(err)
+	require.Equal(data, got)
+	require.Nil(<-cherr)
+	require.Nil(apeer.Close())
+	require.Nil(bpeer.Close())
+
+	// Test both sides get traced from the header on, closing the writer with the tracer
+	mu.Lock()
+	defer mu.Unlock()
+	for client, trace := range traces {
+		require.True(trace.closed)
+		recs := records(t, trace.Bytes())
+		header := recs[0]
+		require.Equal(Version, header["qlog_version"])
+		require.Equal("JSON-SEQ", header["qlog_format"])
+		vantage := header["trace"].(map[string]any)["vantage_point"].(map[string]any)
+		require.Equal(client, vantage["type"] == "client")
+
+		names := make(map[string]int)
+		states := make(map[string]bool)
+		for _, rec := range recs[1:] {
+			name := rec["name"].(string)
+			names[name]++
+			data := rec["data"].(map[string]any)
+			if name == "transport:stream_state_updated" {
+				states[data["new"].(string)] = true
+			}
+			if name == "transport:packet_sent" || name == "transport:packet_received" {
+				require.True(data["raw"].(map[string]any)["length"].(float64) > 0)
+				require.NotEmpty(data["frames"])
+			}
+		}
+		for _, name := range []string{"transport:packet_sent", "transport:packet_received", "transport:frames_processed",
+			"recovery:metrics_updated", "transport:stream_state_updated"} {
+			require.NotZero(names[name], name)
+		}
+		require.Equal(1, names["connectivity:connection_closed"])
+		require.True(states["open"])
+		require.True(states["closed"])
+		// The sender retransmits the chunks lost on the way
+		if client {
+			require.NotZero(names["recovery:packet_lost"])
+		}
+	}
+
+	// Test the trace of every peer gets its own file
+	{
+		dir := t.TempDir()
+		raddr := &net.UDPAddr{IP: net.IPv6loopback, Port: 9000}
+		tr := Dir(dir)(raddr, false)
+		require.NotNil(tr)
+		tr.CongestionWindowUpdated(1000)
+		tr.Close()
+		entries, err := os.ReadDir(dir)
+		require.Nil(err)
+		require.Len(entries, 1)
+		b, err := os.ReadFile(dir + "/" + entries[0].Name())
+		require.Nil(err)
+		recs := records(t, b)
+		require.Len(recs, 3)
+		require.Equal(float64(1000), recs[1]["data"].(map[string]any)["congestion_window"])
+		require.Nil(tr.(*Tracer).Err())
+		require.Nil(Dir(dir+"/missing")(raddr, false))
+	}
+}
@@ -0,0 +1,75 @@
+package wire
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire/interop"
+	"reliable-udp/util/logging"
+	"reliable-udp/util/pcapng"
+	"reliable-udp/util/ratelimit"
+)
+
+// Config of the listener. The zero value of any field uses its default.
+type Config struct {
+	// Protocol versions supported by the listener, in the order of preference.
+	// Rolling out a new protocol version across a fleet is done by prepending it here first,
+	// so the upgraded peers still speak the previous version to the peers yet to upgrade.
+	SupportedVersions []uint32
+	// Application-defined extension frame types understood by the listener, see frame.Register.
+	Extensions []frame.FrameType
+	// Transport parameters telling the peers about the listener's limits.
+	TransportParams frame.TransportParams
+	// Logger recording the peers coming and going, the handshakes, the dropped frames and the retransmissions.
+	// Nothing gets logged by default.
+	Logger logging.Logger
+	// Capture recording every datagram sent and received by the listener, see interop.Tap.
+	// It must be of pcapng.LinkTypeRaw. Nothing gets captured by default.
+	Capture *pcapng.Writer
+	// Creates the tracer of every peer of the listener, see interop.Tracer and the qlog package.
+	// Nothing gets traced by default.
+	Tracer interop.TracerFunc
+	// The most peers at once, past which the handshakes of new peers get refused, see interop.Config.
+	// There's no limit by default.
+	MaxPeers int
+	// The most peers at once from the same IP address. There's no limit by default.
+	MaxPeersPerIP int
+	// Tells whether to accept the new peer at the address, for allow and deny lists.
+	// Every peer gets accepted by default.
+	AcceptFilter func(*net.UDPAddr) bool
+	// Rate limits every peer and every stream starts out with, see Peer.SetRateLimit and Stream.SetRateLimit.
+	// There's no limit by default.
+	PeerRateLimit   ratelimit.Limit
+	StreamRateLimit ratelimit.Limit
+}
+
+func (c *Config) interop() *interop.Config {
+	if c == nil {
+		return nil
+	}
+	return &interop.Config{
+		SupportedVersions: c.SupportedVersions,
+		Extensions:        c.Extensions,
+		TransportParams:   c.TransportParams,
+		Logger:            c.Logger,
+		Tracer:            c.Tracer,
+		MaxPeers:          c.MaxPeers,
+		MaxPeersPerIP:     c.MaxPeersPerIP,
+		AcceptFilter:      c.AcceptFilter,
+		PeerRateLimit:     c.PeerRateLimit,
+		StreamRateLimit:   c.StreamRateLimit,
+	}
+}
+
+func (c *Config) capture() *pcapng.Writer {
+	if c == nil {
+		return nil
+	}
+	return c.Capture
+}
+
+func (c *Config) logger() logging.Logger {
+	if c == nil || c.Logger == nil {
+		return logging.Nop()
+	}
+	return c.Logger
+}
@@ -0,0 +1,101 @@
+package interop
+
+import (
+	"context"
+	"net"
+	"reliable-udp/protocol/frame"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmission(t *testing.T) {
+	require := require.New(t)
+	conn := &captureConn{}
+	denied := net.IPv4(10, 0, 0, 9)
+	iop := New(conn, &Config{
+		MaxPeers:      3,
+		MaxPeersPerIP: 2,
+		AcceptFilter: func(raddr *net.UDPAddr) bool {
+			return !raddr.IP.Equal(denied)
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	addr := func(ip byte, port int) *net.UDPAddr {
+		return &net.UDPAddr{IP: net.IPv4(10, 0, 0, ip), Port: port}
+	}
+	// Receive the handshake of the peer, returning the refusal sent back if any
+	handshake := func(raddr *net.UDPAddr) *frame.Refusal {
+		conn.mu.Lock()
+		n := len(conn.packets)
+		conn.mu.Unlock()
+		iop.receive(frame.Encode(frame.Handshake{StreamID: 1, Version: frame.ProtocolVersion, Hash: make([]byte, 16)}), raddr)
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		if len(conn.packets) == n {
+			return nil
+		}
+		f, err := frame.Decode(conn.packets[n])
+		require.Nil(err)
+		return f.Data.(*frame.Refusal)
+	}
+
+	// Test the peers past the limits get refused, while the handshakes waiting to get accepted count towards them
+	{
+		require.Nil(handshake(addr(1, 1)))
+		require.Nil(handshake(addr(1, 2)))
+		require.Equal(&frame.Refusal{StreamID: 1, Reason: frame.RefusalSourceLimit}, handshake(addr(1, 3)))
+		require.Nil(handshake(addr(2, 1)))
+		require.Equal(&frame.Refusal{StreamID: 1, Reason: frame.RefusalPeerLimit}, handshake(addr(3, 1)))
+		// Retried handshakes of the admitted peers never get refused
+		require.Nil(handshake(addr(1, 1)))
+	}
+
+	// Test the filter denies the peers even below the limits
+	{
+		require.Equal(&frame.Refusal{StreamID: 1, Reason: frame.RefusalDenied}, handshake(&net.UDPAddr{IP: denied, Port: 1}))
+	}
+
+	// Test closing a peer makes room for another
+	{
+		p, err := iop.AcceptPeerContext(ctx)
+		require.Nil(err)
+		require.Equal(addr(1, 1), p.RemoteAddr())
+		require.Equal(&frame.Refusal{StreamID: 1, Reason: frame.RefusalPeerLimit}, handshake(addr(3, 1)))
+		require.Nil(p.Close())
+		require.Nil(handshake(addr(1, 3)))
+		require.Len(iop.sources, 2)
+		require.Equal(2, iop.sources["10.0.0.1"])
+	}
+
+	// Test the handshakes waiting past the timeout stop counting towards the limits and never get accepted
+	{
+		iop.mu.Lock()
+		ph := iop.pending[addr(1, 3).String()]
+		ph.at = ph.at.Add(-AcceptTimeout)
+		iop.pending[addr(1, 3).String()] = ph
+		iop.mu.Unlock()
+		require.Nil(handshake(addr(3, 1)))
+		require.Equal(1, iop.sources["10.0.0.1"])
+		var accepted []*net.UDPAddr
+		for n := 0; n < 3; n++ {
+			p, err := iop.AcceptPeerContext(ctx)
+			require.Nil(err)
+			accepted = append(accepted, p.RemoteAddr())
+		}
+		require.Equal([]*net.UDPAddr{addr(1, 2), addr(2, 1), addr(3, 1)}, accepted)
+	}
+
+	// Test stopping drops the handshakes waiting to get accepted
+	{
+		require.Nil(iop.Peer(addr(3, 1)).Close())
+		require.Nil(handshake(addr(1, 3)))
+		require.Equal(2, iop.sources["10.0.0.1"])
+		iop.StopAccepting()
+		require.Empty(iop.pending)
+		require.Empty(iop.accepts)
+		require.Equal(map[string]int{"10.0.0.1": 1, "10.0.0.2": 1}, iop.sources)
+	}
+}
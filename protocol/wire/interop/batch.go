@@ -0,0 +1,295 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// The number of datagrams to read or write in a single system call.
+	BatchSize = 32
+	// Maximum number of datagrams coalesced into a single segmentation offload send.
+	offloadMaxSegments = 64
+	// Maximum size of the buffer for a single segmentation offload send or receive.
+	offloadMaxSize = 65535 - 8 - 40
+)
+
+// Both ipv4.PacketConn and ipv6.PacketConn share the same message type.
+type batcher interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+type segment struct {
+	b    []byte
+	addr *net.UDPAddr
+}
+
+// Batch connection reads and writes datagrams using recvmmsg/sendmmsg on Linux
+// and falls back to a single datagram per system call elsewhere.
+// On Linux it also uses UDP generic segmentation offload (GSO) to send runs of equal-sized
+// datagrams to the same address as a single buffer, and generic receive offload (GRO) to
+// receive multiple datagrams as a single buffer, whenever the kernel supports them.
+type batchConn struct {
+	*net.UDPConn
+	pc batcher
+
+	rmu     sync.Mutex
+	rmsgs   []ipv4.Message
+	roob    [][]byte
+	pending []segment
+	gro     bool
+
+	wmu    sync.Mutex
+	wmsgs  []ipv4.Message
+	wbufs  [][]byte
+	woob   [][]byte
+	counts []int
+	gso    bool
+}
+
+var _ BatchConn = (*batchConn)(nil)
+
+func NewBatchConn(conn *net.UDPConn) BatchConn {
+	c := &batchConn{
+		UDPConn: conn,
+		rmsgs:   make([]ipv4.Message, BatchSize),
+		roob:    make([][]byte, BatchSize),
+		pending: make([]segment, 0, BatchSize),
+		wmsgs:   make([]ipv4.Message, 0, BatchSize),
+		counts:  make([]int, 0, BatchSize),
+	}
+	if batchSupported {
+		if laddr, ok := conn.LocalAddr().(*net.UDPAddr); ok && laddr.IP.To4() == nil {
+			c.pc = ipv6.NewPacketConn(conn)
+		} else {
+			c.pc = ipv4.NewPacketConn(conn)
+		}
+		c.gso, c.gro = enableOffload(conn)
+	}
+	size := frame.PacketMaxSize
+	if c.gro {
+		size = offloadMaxSize
+	}
+	for i := range c.rmsgs {
+		c.rmsgs[i].Buffers = [][]byte{make([]byte, size)}
+		c.roob[i] = make([]byte, offloadOOBSize)
+	}
+	return c
+}
+
+// Offload reports whether the segmentation offloads are in use for sending and receiving.
+func (c *batchConn) Offload() (gso bool, gro bool) {
+	c.wmu.Lock()
+	gso = c.gso
+	c.wmu.Unlock()
+	return gso, c.gro
+}
+
+func (c *batchConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	ms := []Message{{Buffer: b}}
+	if _, err := c.ReadBatch(ms); err != nil {
+		return 0, nil, err
+	}
+	return ms[0].N, ms[0].Addr, nil
+}
+
+func (c *batchConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	if _, err := c.WriteBatch([]Message{{Buffer: b, Addr: addr}}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *batchConn) ReadBatch(ms []Message) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if len(ms) <= 0 {
+		return 0, nil
+	}
+	if len(c.pending) <= 0 {
+		if err := c.read(); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for n < len(ms) && len(c.pending) > 0 {
+		seg := c.pending[0]
+		ms[n].N = copy(ms[n].Buffer, seg.b)
+		ms[n].Addr = seg.addr
+		c.pending = c.pending[1:]
+		n++
+	}
+	return n, nil
+}
+
+func (c *batchConn) WriteBatch(ms []Message) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	sent := 0
+	if c.gso {
+		n, err := c.writeSegmented(ms)
+		if err == nil || !isOffloadError(err) {
+			return n, err
+		}
+		// The kernel or the device refused the segmentation offload, so stop using it
+		c.gso = false
+		sent = n
+	}
+	n, err := c.write(ms[sent:])
+	return sent + n, err
+}
+
+// Read a batch of datagrams into the pending segments, splitting the GRO buffers if any.
+func (c *batchConn) read() error {
+	n, err := c.readBatch()
+	if err != nil {
+		return err
+	}
+	c.pending = c.pending[:0]
+	for _, m := range c.rmsgs[:n] {
+		addr, _ := m.Addr.(*net.UDPAddr)
+		b := m.Buffers[0][:m.N]
+		size := len(b)
+		if c.gro {
+			if s := parseSegmentSize(m.OOB[:m.NN]); s > 0 {
+				size = s
+			}
+		}
+		for len(b) > size {
+			c.pending = append(c.pending, segment{b[:size], addr})
+			b = b[size:]
+		}
+		c.pending = append(c.pending, segment{b, addr})
+	}
+	return nil
+}
+
+func (c *batchConn) write(ms []Message) (int, error) {
+	sent := 0
+	for sent < len(ms) {
+		end := sent + BatchSize
+		if end > len(ms) {
+			end = len(ms)
+		}
+		c.wmsgs = c.wmsgs[:0]
+		for _, m := range ms[sent:end] {
+			c.wmsgs = append(c.wmsgs, ipv4.Message{
+				Buffers: [][]byte{m.Buffer},
+				Addr:    m.Addr,
+			})
+		}
+		n, err := c.sendAll(c.wmsgs)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// Write the datagrams, coalescing runs of equal-sized datagrams to the same address
+// into single GSO buffers.
+func (c *batchConn) writeSegmented(ms []Message) (int, error) {
+	sent := 0
+	for sent < len(ms) {
+		c.wmsgs = c.wmsgs[:0]
+		c.counts = c.counts[:0]
+		i := sent
+		for i < len(ms) && len(c.wmsgs) < BatchSize {
+			k := len(c.wmsgs)
+			if k >= len(c.wbufs) {
+				c.wbufs = append(c.wbufs, make([]byte, 0, offloadMaxSize))
+				c.woob = append(c.woob, make([]byte, 0, offloadOOBSize))
+			}
+			j := nextSegmentRun(ms, i)
+			m := ipv4.Message{Addr: ms[i].Addr}
+			if j-i > 1 {
+				buf := c.wbufs[k][:0]
+				for _, sm := range ms[i:j] {
+					buf = append(buf, sm.Buffer...)
+				}
+				c.wbufs[k] = buf
+				m.Buffers = [][]byte{buf}
+				m.OOB = appendSegmentSize(c.woob[k][:0], len(ms[i].Buffer))
+			} else {
+				m.Buffers = [][]byte{ms[i].Buffer}
+			}
+			c.wmsgs = append(c.wmsgs, m)
+			c.counts = append(c.counts, j-i)
+			i = j
+		}
+		n, err := c.sendAll(c.wmsgs)
+		for _, count := range c.counts[:n] {
+			sent += count
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func (c *batchConn) readBatch() (int, error) {
+	if c.pc == nil {
+		m := &c.rmsgs[0]
+		n, addr, err := c.UDPConn.ReadFromUDP(m.Buffers[0])
+		if err != nil {
+			return 0, err
+		}
+		m.N, m.NN, m.Addr = n, 0, addr
+		return 1, nil
+	}
+	for i := range c.rmsgs {
+		c.rmsgs[i].OOB = c.roob[i]
+	}
+	return c.pc.ReadBatch(c.rmsgs, 0)
+}
+
+func (c *batchConn) sendAll(ms []ipv4.Message) (int, error) {
+	if c.pc == nil {
+		for i, m := range ms {
+			if _, err := c.UDPConn.WriteToUDP(m.Buffers[0], m.Addr.(*net.UDPAddr)); err != nil {
+				return i, err
+			}
+		}
+		return len(ms), nil
+	}
+	sent := 0
+	for sent < len(ms) {
+		n, err := c.pc.WriteBatch(ms[sent:], 0)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// Find the end of the run of datagrams starting at i that could be sent as a single GSO buffer.
+// Every datagram in the run shares the same address and size, except the last which may be smaller.
+func nextSegmentRun(ms []Message, i int) int {
+	size := len(ms[i].Buffer)
+	total := size
+	j := i + 1
+	for j < len(ms) && j-i < offloadMaxSegments {
+		m := ms[j]
+		if len(m.Buffer) > size || total+len(m.Buffer) > offloadMaxSize || !sameAddr(m.Addr, ms[i].Addr) {
+			break
+		}
+		total += len(m.Buffer)
+		j++
+		if len(m.Buffer) < size {
+			break
+		}
+	}
+	return j
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP) && a.Zone == b.Zone
+}
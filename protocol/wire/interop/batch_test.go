@@ -0,0 +1,139 @@
+package interop
+
+import (
+	"bytes"
+	"net"
+	"reliable-udp/protocol/frame"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchConn(t *testing.T) {
+	require := require.New(t)
+	sender, receiver := loopbackPair(t)
+	sc, rc := NewBatchConn(sender), NewBatchConn(receiver)
+	raddr := receiver.LocalAddr().(*net.UDPAddr)
+
+	// Equal-sized datagrams followed by smaller ones, so both the segmented and plain paths get used
+	sizes := []int{frame.PacketMaxSize, frame.PacketMaxSize, frame.PacketMaxSize, 100, 10, 1000}
+	ms := make([]Message, len(sizes))
+	for i, size := range sizes {
+		ms[i] = Message{Buffer: bytes.Repeat([]byte{byte(i + 1)}, size), Addr: raddr}
+	}
+	n, err := sc.WriteBatch(ms)
+	require.Nil(err)
+	require.Equal(len(ms), n)
+
+	received := make([]Message, 0, len(ms))
+	rms := make([]Message, BatchSize)
+	for i := range rms {
+		rms[i].Buffer = frame.Buffer()
+	}
+	receiver.SetReadDeadline(time.Now().Add(time.Second))
+	for len(received) < len(ms) {
+		n, err := rc.ReadBatch(rms)
+		require.Nil(err)
+		for _, m := range rms[:n] {
+			received = append(received, Message{
+				Buffer: append([]byte(nil), m.Buffer[:m.N]...),
+				Addr:   m.Addr,
+			})
+		}
+	}
+	for i, m := range received {
+		require.Equal(ms[i].Buffer, m.Buffer)
+		require.Equal(sender.LocalAddr().(*net.UDPAddr).Port, m.Addr.Port)
+	}
+}
+
+func BenchmarkLoopbackUDPConn(b *testing.B) {
+	benchmarkLoopback(b, func(conn *net.UDPConn) UDPConn {
+		return conn
+	})
+}
+
+func BenchmarkLoopbackBatchConn(b *testing.B) {
+	benchmarkLoopback(b, func(conn *net.UDPConn) UDPConn {
+		return NewBatchConn(conn)
+	})
+}
+
+func benchmarkLoopback(b *testing.B, wrap func(*net.UDPConn) UDPConn) {
+	sender, receiver := loopbackPair(b)
+	sc, rc := wrap(sender), wrap(receiver)
+	raddr := receiver.LocalAddr().(*net.UDPAddr)
+	packets := make([]Message, BatchSize)
+	for i := range packets {
+		packets[i] = Message{Buffer: make([]byte, frame.PacketMaxSize), Addr: raddr}
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	received := 0
+	go func() {
+		defer wg.Done()
+		buf := frame.Buffer()
+		ms := make([]Message, BatchSize)
+		for i := range ms {
+			ms[i].Buffer = frame.Buffer()
+		}
+		bc, batch := rc.(BatchConn)
+		for received < b.N {
+			// Some datagrams may be dropped by the kernel under load, so stop once the sender goes quiet
+			receiver.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			if batch {
+				n, err := bc.ReadBatch(ms)
+				if err != nil {
+					return
+				}
+				received += n
+			} else {
+				if _, _, err := rc.ReadFromUDP(buf); err != nil {
+					return
+				}
+				received++
+			}
+		}
+	}()
+
+	b.SetBytes(frame.PacketMaxSize)
+	b.ResetTimer()
+	start := time.Now()
+	iop := &Interop{UDPConn: sc}
+	for sent := 0; sent < b.N; sent += len(packets) {
+		n := len(packets)
+		if b.N-sent < n {
+			n = b.N - sent
+		}
+		if err := iop.write(packets[:n]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	wg.Wait()
+	b.StopTimer()
+	elapsed := time.Since(start).Seconds()
+	b.ReportMetric(float64(received*frame.PacketMaxSize)/elapsed/1e6, "goodput-MB/s")
+	b.ReportMetric(float64(b.N-received)/float64(b.N)*100, "%loss")
+}
+
+func loopbackPair(tb testing.TB) (*net.UDPConn, *net.UDPConn) {
+	laddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	sender, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	receiver, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	receiver.SetReadBuffer(4 << 20)
+	sender.SetWriteBuffer(4 << 20)
+	tb.Cleanup(func() {
+		sender.Close()
+		receiver.Close()
+	})
+	return sender, receiver
+}
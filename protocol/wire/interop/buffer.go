@@ -0,0 +1,24 @@
+package interop
+
+import (
+	"reliable-udp/protocol/frame"
+	"sync"
+)
+
+// Pool of packet buffers to avoid allocating a new buffer for every datagram.
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, frame.PacketMaxSize)
+		return &b
+	},
+}
+
+func getPacketBuffer() *[]byte {
+	b := packetPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+func putPacketBuffer(b *[]byte) {
+	packetPool.Put(b)
+}
@@ -0,0 +1,30 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"testing"
+)
+
+type discardConn struct{}
+
+func (discardConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	return len(b), nil
+}
+
+func (discardConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {}
+}
+
+func BenchmarkPeerSend(b *testing.B) {
+	iop := &Interop{UDPConn: discardConn{}}
+	p := NewPeer(iop, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	s := &frame.Stream{StreamID: 1, Chunk: make([]byte, 512)}
+	ack := &frame.StreamAck{StreamID: 1}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := p.Send(s, ack); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
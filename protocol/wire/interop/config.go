@@ -0,0 +1,128 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/util/logging"
+	"reliable-udp/util/ratelimit"
+	"time"
+)
+
+// Config of the protocol spoken by the interop.
+type Config struct {
+	// Protocol versions supported by the interop, in the order of preference.
+	// Handshakes offered in any other version get replied with the version negotiation frame.
+	SupportedVersions []uint32
+	// Extension frame types understood by the interop, registered with frame.Register.
+	// They get offered to the peers with every handshake, and the ones both sides understand
+	// are the only critical extension frames allowed to be sent to the peer.
+	Extensions []frame.FrameType
+	// Our transport parameters, sent to the peers with every handshake and handshake ACK.
+	// The unset parameters use their defaults.
+	TransportParams frame.TransportParams
+	// Logger recording the peers coming and going, the handshakes and the dropped frames.
+	// Nothing gets logged by default.
+	Logger logging.Logger
+	// Creates the tracer of every peer, see Tracer. Nothing gets traced by default.
+	Tracer TracerFunc
+	// The most peers at once, counting the ones waiting to get accepted. The handshakes of new peers
+	// past it get refused with the refusal frame. The peers we connect to ourselves count towards
+	// the limits without ever getting refused. There's no limit by default.
+	MaxPeers int
+	// The most peers at once from the same IP address. There's no limit by default.
+	MaxPeersPerIP int
+	// Tells whether to accept the new peer at the address, refusing it otherwise.
+	// It's called for every handshake of the peers not accepted yet. Every peer gets accepted by default.
+	AcceptFilter func(*net.UDPAddr) bool
+	// Rate limits every peer and every stream starts out with, see Peer.SetRateLimit and Stream.SetRateLimit.
+	// There's no limit by default.
+	PeerRateLimit   ratelimit.Limit
+	StreamRateLimit ratelimit.Limit
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		SupportedVersions: []uint32{frame.ProtocolVersion},
+		TransportParams:   DefaultTransportParams(),
+		Logger:            logging.Nop(),
+	}
+}
+
+func DefaultTransportParams() frame.TransportParams {
+	return frame.TransportParams{
+		MaxStreams:    256,
+		MaxUniStreams: 64,
+		InitialWindow: 1 << 20,
+		IdleTimeout:   30 * time.Second,
+		MaxFrameSize:  frame.FrameMaxSize,
+		AckDelay:      25 * time.Millisecond,
+		Features:      frame.FeatureFEC,
+	}
+}
+
+// Fill in the defaults for the unset fields.
+func (c *Config) normalize() *Config {
+	d := DefaultConfig()
+	if c == nil {
+		return d
+	}
+	cfg := *c
+	if cfg.Logger == nil {
+		cfg.Logger = d.Logger
+	}
+	if len(cfg.SupportedVersions) <= 0 {
+		cfg.SupportedVersions = d.SupportedVersions
+	}
+	tp, dtp := &cfg.TransportParams, d.TransportParams
+	if tp.MaxStreams <= 0 {
+		tp.MaxStreams = dtp.MaxStreams
+	}
+	if tp.MaxUniStreams <= 0 {
+		tp.MaxUniStreams = dtp.MaxUniStreams
+	}
+	if tp.InitialWindow <= 0 {
+		tp.InitialWindow = dtp.InitialWindow
+	}
+	if tp.IdleTimeout <= 0 {
+		tp.IdleTimeout = dtp.IdleTimeout
+	}
+	if tp.MaxFrameSize <= 0 || tp.MaxFrameSize > frame.FrameMaxSize {
+		tp.MaxFrameSize = dtp.MaxFrameSize
+	}
+	if tp.AckDelay <= 0 {
+		tp.AckDelay = dtp.AckDelay
+	}
+	return &cfg
+}
+
+// Negotiate the transport parameters in effect towards the peer. The limits on what we send
+// come from the peer, while the limits both sides have to agree on take the lower of the two.
+func negotiateParams(local, remote frame.TransportParams) frame.TransportParams {
+	tp := remote
+	if tp.IdleTimeout <= 0 || local.IdleTimeout > 0 && local.IdleTimeout < tp.IdleTimeout {
+		tp.IdleTimeout = local.IdleTimeout
+	}
+	if tp.MaxFrameSize <= 0 || local.MaxFrameSize > 0 && local.MaxFrameSize < tp.MaxFrameSize {
+		tp.MaxFrameSize = local.MaxFrameSize
+	}
+	tp.Features &= local.Features
+	return tp
+}
+
+func (c *Config) understands(ft frame.FrameType) bool {
+	for _, ext := range c.Extensions {
+		if ext == ft {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) supports(version uint32) bool {
+	for _, v := range c.SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -6,3 +6,24 @@ type UDPConn interface {
 	WriteToUDP([]byte, *net.UDPAddr) (int, error)
 	ReadFromUDP([]byte) (int, *net.UDPAddr, error)
 }
+
+// BatchConn is an optional fast path for UDP connections capable of reading and writing
+// multiple datagrams with a single system call. Interop prefers it over UDPConn when the
+// connection implements it.
+type BatchConn interface {
+	UDPConn
+	// Read up to len(ms) datagrams, returning the number of messages received.
+	ReadBatch(ms []Message) (int, error)
+	// Write the datagrams, returning the number of messages sent.
+	WriteBatch(ms []Message) (int, error)
+}
+
+// Message is a single datagram read or written in a batch.
+type Message struct {
+	// The datagram to write, or the buffer to read the datagram into.
+	Buffer []byte
+	// The number of bytes read into the buffer.
+	N int
+	// The source address of the datagram read, or the destination address of the datagram to write.
+	Addr *net.UDPAddr
+}
@@ -0,0 +1,137 @@
+package interop
+
+import (
+	"context"
+	"net"
+	"reliable-udp/protocol/frame"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDemux(t *testing.T) {
+	require := require.New(t)
+	aaddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	baddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+	stuck := &frameTracer{}
+	iop := New(discardConn{}, &Config{Tracer: func(raddr *net.UDPAddr, client bool) Tracer {
+		if raddr.Port == aaddr.Port {
+			return stuck
+		}
+		return nil
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Test the handshake of an unknown peer gets handed over to the accepted peer and stream
+	hs := frame.Handshake{StreamID: 1, Version: frame.ProtocolVersion, Hash: make([]byte, 16)}
+	iop.receive(frame.Encode(hs), aaddr)
+	apeer, err := iop.AcceptPeerContext(ctx)
+	require.Nil(err)
+	defer apeer.Close()
+	require.Equal(aaddr, apeer.RemoteAddr())
+	s, err := apeer.AcceptStreamContext(ctx)
+	require.Nil(err)
+	require.Equal(frame.StreamID(1), s.StreamID())
+	f, err := s.Receive(ctx)
+	require.Nil(err)
+	require.Equal(frame.HandshakeType, f.Type())
+
+	// Test a peer stuck at handling its frames only drops its own frames
+	bpeer := iop.peer(baddr, false)
+	defer bpeer.Close()
+	unblock := make(chan struct{})
+	defer close(unblock)
+	stuck.mu.Lock()
+	stuck.block = unblock
+	stuck.mu.Unlock()
+	pkt := frame.Encode(&frame.StreamAck{StreamID: 1})
+	for i := 0; i < PeerQueueSize+2; i++ {
+		iop.receive(pkt, aaddr)
+	}
+	require.NotZero(apeer.Dropped())
+
+	bs := bpeer.Stream(1)
+	iop.receive(frame.Encode(&frame.Stream{StreamID: 1, Chunk: []byte("Hello, world!")}), baddr)
+	f, err = bs.Receive(ctx)
+	require.Nil(err)
+	require.Equal([]byte("Hello, world!"), f.Data.(*frame.Stream).Chunk)
+	require.Zero(bpeer.Dropped())
+
+	// Test a stream closed while its queue is full keeps dropping the frames
+	{
+		full := bpeer.Stream(5)
+		chunk := &frame.Frame{Data: &frame.Stream{StreamID: 5}}
+		for i := 0; i < StreamInboxSize; i++ {
+			full.push(chunk)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				full.push(chunk)
+			}
+		}()
+		require.Nil(full.Close())
+		<-done
+	}
+}
+
+// Receive stream frames of a known peer while the peer is waiting to accept streams,
+// which is how a server spends most of its time. This only measures the read loop's share.
+func BenchmarkInteropReceive(b *testing.B) {
+	iop := New(discardConn{}, nil)
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	p := iop.peer(raddr, false)
+	defer p.Close()
+	s := p.Stream(1)
+	go func() {
+		for {
+			if _, err := s.Receive(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		_, _ = p.AcceptStream()
+	}()
+	pkt := frame.Encode(&frame.Stream{StreamID: 1, Chunk: make([]byte, 1024)})
+	b.SetBytes(int64(len(pkt)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iop.receive(pkt, raddr)
+	}
+}
+
+// Receive stream frames of a known peer all the way to the stream, without dropping any.
+func BenchmarkInteropDeliver(b *testing.B) {
+	iop := New(discardConn{}, nil)
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	p := iop.peer(raddr, false)
+	defer p.Close()
+	s := p.Stream(1)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Receive(context.Background()); err != nil {
+				break
+			}
+		}
+		close(done)
+	}()
+	pkt := frame.Encode(&frame.Stream{StreamID: 1, Chunk: make([]byte, 1024)})
+	b.SetBytes(int64(len(pkt)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Hold off until the frames in flight fit in the stream, as the sender would by waiting for the ACKs
+		for len(p.inbox)+len(s.inbox) >= cap(s.inbox)-1 {
+			runtime.Gosched()
+		}
+		iop.receive(pkt, raddr)
+	}
+	<-done
+}
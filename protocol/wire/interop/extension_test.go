@@ -0,0 +1,84 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/util/simnet"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const pingType = frame.ExtensionTypeMin + 0x10
+
+// Application-defined extension frame without any payload.
+type extensionData struct {
+	ft frame.FrameType
+}
+
+func (e extensionData) Type() frame.FrameType {
+	return e.ft
+}
+
+func (extensionData) Bytes() []byte {
+	return make([]byte, 0)
+}
+
+func (extensionData) AppendBytes(dst []byte) []byte {
+	return dst
+}
+
+func TestExtensionNegotiation(t *testing.T) {
+	require := require.New(t)
+	err := frame.Register(pingType, func(b []byte) (frame.Data, error) {
+		return &extensionData{pingType}, nil
+	})
+	if err != frame.ErrFrameTypeRegistered {
+		require.Nil(err)
+	}
+
+	network := simnet.New(1, simnet.Config{Latency: time.Millisecond})
+	aconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer aconn.Close()
+	bconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer bconn.Close()
+
+	a := New(aconn, &Config{Extensions: []frame.FrameType{pingType, pingType + 1}})
+	b := New(bconn, &Config{Extensions: []frame.FrameType{pingType}})
+	apeer := a.Peer(bconn.LocalAddr().(*net.UDPAddr))
+	bpeer := b.peer(aconn.LocalAddr().(*net.UDPAddr), false)
+
+	// Critical extension frames are refused until negotiated, unlike the non-critical ones
+	require.Equal(ErrExtensionRefused, apeer.Send(extensionData{pingType}))
+	require.Nil(apeer.Send(extensionData{pingType | frame.ExtensionNonCritical}))
+
+	// The handshake offers every extension, the ACK picks the ones understood by both sides
+	s, err := apeer.OpenStream()
+	require.Nil(err)
+	require.Nil(s.Handshake(0, make([]byte, 16)))
+	require.Eventually(func() bool {
+		network.Flush()
+		return len(bpeer.Extensions()) > 0
+	}, time.Second, 10*time.Millisecond)
+	require.Equal([]frame.FrameType{pingType}, bpeer.Extensions())
+
+	require.Nil(bpeer.Stream(s.StreamID()).AckHandshake(frame.FrameMaxSize))
+	require.Eventually(func() bool {
+		network.Flush()
+		return len(apeer.Extensions()) > 0
+	}, time.Second, 10*time.Millisecond)
+	require.Equal([]frame.FrameType{pingType}, apeer.Extensions())
+
+	// Test the received extension frames get handed over
+	require.Nil(apeer.Send(extensionData{pingType}))
+	network.Flush()
+	select {
+	case d := <-bpeer.ExtensionFrames():
+		require.Equal(pingType, d.Type())
+	case <-time.After(time.Second):
+		require.Fail("extension frame not received")
+	}
+}
@@ -0,0 +1,197 @@
+package interop
+
+import (
+	"errors"
+	"reliable-udp/protocol/frame"
+	"sync"
+)
+
+const (
+	// The number of recently received stream frames kept per stream for rebuilding lost chunks.
+	fecWindowSize = 2 * frame.RepairMaxCount
+	// The number of repair frames kept per stream while waiting for the rest of their groups.
+	fecPendingSize = 8
+)
+
+var ErrInvalidFECGroupSize = errors.New("invalid FEC group size")
+
+// FEC encoder groups the outgoing stream frames of every stream by their consecutive sequences
+// and produces a repair frame for every complete group.
+type fecEncoder struct {
+	groupSize int
+	groups    map[frame.StreamID]*frame.Repair
+}
+
+func newFECEncoder(groupSize int) (*fecEncoder, error) {
+	if groupSize < 2 || groupSize > frame.RepairMaxCount {
+		return nil, ErrInvalidFECGroupSize
+	}
+	return &fecEncoder{
+		groupSize: groupSize,
+		groups:    make(map[frame.StreamID]*frame.Repair),
+	}, nil
+}
+
+// Add the stream frame into its group, returning the repair frame once the group is complete.
+// The repair frame must be sent out before the next call since it gets reset afterwards.
+func (e *fecEncoder) add(s *frame.Stream) *frame.Repair {
+	r, ok := e.groups[s.StreamID]
+	if !ok {
+		r = &frame.Repair{StreamID: s.StreamID}
+		e.groups[s.StreamID] = r
+	}
+	// Retransmissions break the consecutive sequences, so start over with a new group
+	if r.Count <= 0 || r.Count >= uint8(e.groupSize) || s.Sequence != r.Sequence+uint16(r.Count) {
+		r.Reset(s.Sequence)
+	}
+	if err := r.Add(s); err != nil {
+		// The chunk is too large to be protected, so leave it out of any group
+		r.Reset(s.Sequence + 1)
+		return nil
+	}
+	if int(r.Count) < e.groupSize {
+		return nil
+	}
+	return r
+}
+
+// Close the stream's group, returning the repair frame for the partial group if it's worth sending.
+func (e *fecEncoder) close(sid frame.StreamID) *frame.Repair {
+	r, ok := e.groups[sid]
+	if !ok {
+		return nil
+	}
+	delete(e.groups, sid)
+	if r.Count < 2 || r.Count >= uint8(e.groupSize) {
+		return nil
+	}
+	return r
+}
+
+type fecWindow struct {
+	frames  map[uint16]*frame.Stream
+	order   [fecWindowSize]uint16
+	next    int
+	pending []*frame.Repair
+}
+
+// FEC decoder keeps the recently received stream frames of every stream,
+// and rebuilds the single missing frame of a group once its repair frame arrives.
+// Since the repair frame may arrive before the rest of its group gets reordered in,
+// it's kept pending until the group has a single missing frame left.
+type fecDecoder struct {
+	mu      sync.Mutex
+	windows map[frame.StreamID]*fecWindow
+}
+
+func newFECDecoder() *fecDecoder {
+	return &fecDecoder{
+		windows: make(map[frame.StreamID]*fecWindow),
+	}
+}
+
+// Receive the frame, returning the rebuilt stream frame if the frame completes a group with a single loss.
+func (d *fecDecoder) receive(f *frame.Frame) *frame.Stream {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch v := f.Data.(type) {
+	case *frame.Stream:
+		w := d.window(v.StreamID)
+		if w.add(v) {
+			return w.rebuildPending(v.Sequence)
+		}
+	case *frame.Repair:
+		return d.window(v.StreamID).repair(v)
+	case *frame.Fin:
+		if v.StreamID == 0 {
+			d.windows = make(map[frame.StreamID]*fecWindow)
+		} else {
+			delete(d.windows, v.StreamID)
+		}
+	}
+	return nil
+}
+
+// Drop the window of the stream once the stream is gone.
+func (d *fecDecoder) drop(sid frame.StreamID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.windows, sid)
+}
+
+func (d *fecDecoder) window(sid frame.StreamID) *fecWindow {
+	w, ok := d.windows[sid]
+	if !ok {
+		w = &fecWindow{frames: make(map[uint16]*frame.Stream)}
+		d.windows[sid] = w
+	}
+	return w
+}
+
+// Add the received stream frame, reporting whether it hasn't been received before.
+func (w *fecWindow) add(s *frame.Stream) bool {
+	if _, ok := w.frames[s.Sequence]; ok {
+		return false
+	}
+	// Evict the oldest frame once the window is full
+	if len(w.frames) >= fecWindowSize {
+		delete(w.frames, w.order[w.next])
+	}
+	w.frames[s.Sequence] = s
+	w.order[w.next] = s.Sequence
+	w.next = (w.next + 1) % fecWindowSize
+	return true
+}
+
+func (w *fecWindow) repair(r *frame.Repair) *frame.Stream {
+	s, done := w.rebuild(r)
+	if !done {
+		if len(w.pending) >= fecPendingSize {
+			w.pending = w.pending[1:]
+		}
+		w.pending = append(w.pending, r)
+	}
+	return s
+}
+
+// Retry the pending repair frame covering the sequence, now that one more frame of its group has arrived.
+func (w *fecWindow) rebuildPending(seq uint16) *frame.Stream {
+	for i, r := range w.pending {
+		if !r.Covers(seq) {
+			continue
+		}
+		s, done := w.rebuild(r)
+		if done {
+			w.pending = append(w.pending[:i], w.pending[i+1:]...)
+		}
+		return s
+	}
+	return nil
+}
+
+// Rebuild the single missing frame of the group, reporting whether the repair frame is done with,
+// which is when the group has no missing frame left.
+func (w *fecWindow) rebuild(r *frame.Repair) (*frame.Stream, bool) {
+	received := make([]*frame.Stream, 0, r.Count)
+	missing, lost := uint16(0), 0
+	for i := uint16(0); i < uint16(r.Count); i++ {
+		seq := r.Sequence + i
+		if s, ok := w.frames[seq]; ok {
+			received = append(received, s)
+		} else {
+			missing = seq
+			lost++
+		}
+	}
+	// XOR parity is only able to rebuild a single loss
+	if lost != 1 {
+		return nil, lost <= 0
+	}
+	// The repair frame doesn't match the received frames, so there's nothing to rebuild out of it
+	s := r.Rebuild(missing, received)
+	if s == nil {
+		return nil, true
+	}
+	w.add(s)
+	return s, true
+}
@@ -0,0 +1,146 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire/interop/handler"
+	"reliable-udp/util/simnet"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type captureConn struct {
+	mu      sync.Mutex
+	packets [][]byte
+}
+
+func (c *captureConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packets = append(c.packets, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *captureConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {}
+}
+
+func TestFEC(t *testing.T) {
+	require := require.New(t)
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	require.Equal(ErrInvalidFECGroupSize, NewPeer(nil, raddr).EnableFEC(1))
+
+	// Every stream frame gets sent in its own datagram, followed by the repair frame of its group
+	conn := &captureConn{}
+	sender := NewPeer(&Interop{UDPConn: conn}, raddr)
+	require.Nil(sender.EnableFEC(4))
+	expected := make([]*frame.Stream, 0)
+	for seq := uint16(0); seq < 10; seq++ {
+		s := &frame.Stream{
+			StreamID: 1,
+			Sequence: seq,
+			Offset:   seq * 100,
+			Chunk:    chunkPattern(1, seq),
+		}
+		expected = append(expected, s)
+		require.Nil(sender.Send(s))
+	}
+	require.Nil(sender.Send(frame.Fin{StreamID: 1}))
+
+	// Drop a single stream frame out of every group
+	lost := map[uint16]bool{1: true, 6: true, 8: true}
+	tracer := &frameTracer{}
+	receiver := NewPeer(&Interop{config: (&Config{TransportParams: DefaultTransportParams(), Tracer: tracer.trace}).normalize()}, raddr)
+	for _, b := range conn.packets {
+		frames, err := frame.DecodePacket(b)
+		require.Nil(err)
+		for _, f := range frames {
+			if s, ok := f.Data.(*frame.Stream); ok && lost[s.Sequence] {
+				continue
+			}
+			receiver.dispatch(handler.Event{Frame: f, RemoteAddr: raddr})
+		}
+	}
+
+	received := make(map[uint16]*frame.Stream)
+	for _, s := range tracer.streams() {
+		received[s.Sequence] = s
+	}
+	require.Len(received, len(expected))
+	for _, s := range expected {
+		require.Equal(s, received[s.Sequence])
+	}
+
+	// Test the stream frames are only kept for rebuilding while the peer may send repair frames,
+	// and only as long as their stream is around
+	{
+		p := NewPeer(&Interop{UDPConn: &captureConn{}}, raddr)
+		s, err := p.OpenStream()
+		require.Nil(err)
+		chunk := &frame.Frame{Data: &frame.Stream{StreamID: s.StreamID(), Chunk: []byte("Hello")}}
+		p.dispatch(handler.Event{Frame: chunk, RemoteAddr: raddr})
+		require.Len(p.repair.windows, 1)
+		require.Nil(s.Close())
+		require.Empty(p.repair.windows)
+
+		params := DefaultTransportParams()
+		params.Features = 0
+		p.dispatch(handler.Event{Frame: &frame.Frame{Data: &frame.HandshakeAck{StreamID: 1, Params: params}}, RemoteAddr: raddr})
+		s, err = p.OpenStream()
+		require.Nil(err)
+		chunk = &frame.Frame{Data: &frame.Stream{StreamID: s.StreamID(), Chunk: []byte("Hello")}}
+		p.dispatch(handler.Event{Frame: chunk, RemoteAddr: raddr})
+		require.Empty(p.repair.windows)
+	}
+}
+
+func TestFECOverLossyNetwork(t *testing.T) {
+	require := require.New(t)
+	network := simnet.New(1, simnet.Config{
+		Loss:    0.1,
+		Latency: 20 * time.Millisecond,
+		Jitter:  5 * time.Millisecond,
+	})
+	sconn, err := network.Listen(nil)
+	require.Nil(err)
+	rconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer rconn.Close()
+
+	tracer := &frameTracer{}
+	receiver := New(rconn, &Config{TransportParams: DefaultTransportParams(), Tracer: tracer.trace})
+	receiver.Peer(sconn.LocalAddr().(*net.UDPAddr))
+
+	const packets = 400
+	sender := NewPeer(&Interop{UDPConn: sconn}, rconn.LocalAddr().(*net.UDPAddr))
+	require.Nil(sender.EnableFEC(4))
+	for seq := uint16(0); seq < packets; seq++ {
+		require.Nil(sender.Send(&frame.Stream{StreamID: 1, Sequence: seq, Chunk: chunkPattern(1, seq)}))
+		network.Advance(time.Millisecond)
+	}
+	network.Flush()
+	for last := -1; ; {
+		time.Sleep(20 * time.Millisecond)
+		n := len(tracer.streams())
+		if n == last && rconn.Buffered() <= 0 {
+			break
+		}
+		last = n
+	}
+
+	received := make(map[uint16]*frame.Stream)
+	for _, s := range tracer.streams() {
+		received[s.Sequence] = s
+	}
+	stats := network.Stats()
+	require.NotZero(stats.Lost)
+	// Most of the lost chunks get rebuilt, except for groups losing more than a single frame
+	require.Greater(len(received), packets-stats.Lost/2)
+	for seq, s := range received {
+		require.Equal(chunkPattern(1, seq), s.Chunk)
+	}
+}
@@ -1,100 +1,361 @@
 package interop
 
 import (
+	"context"
 	"errors"
 	"net"
 	"reliable-udp/protocol/frame"
 	"reliable-udp/protocol/wire/interop/handler"
-	"reliable-udp/util/observable"
+	"reliable-udp/util/logging"
 	"sync"
+	"time"
 )
 
 var (
 	ErrInteropAlreadyStarted = errors.New("interop already started")
 	ErrInteropNotStarted     = errors.New("interop not started")
 	ErrAcceptInterrupted     = errors.New("accept interrupted")
+	ErrVersionMismatch       = errors.New("no protocol version in common")
 )
 
+// The number of handshakes from unknown peers waiting to get accepted.
+// Handshakes past it get dropped, leaving it to the peers to retry them.
+const AcceptQueueSize = 64
+
+// How long the handshake of an unknown peer waits to get accepted.
+// Past it, the handshake gets dropped and stops counting towards the admission limits.
+const AcceptTimeout = 10 * time.Second
+
 // Interop is a thin wrapper around a UDP connection.
 // It is responsible for sending and receiving packets
 // of data based on the defined protocols.
+//
+// The read loop demultiplexes the received frames by the peer's address into the peer's queue,
+// see Peer.enqueue, so the read loop never waits on the peers handling their frames.
+// Unknown peers only get as far as having their handshakes accepted, their other frames get dropped.
 type Interop struct {
 	UDPConn
-	mu    sync.RWMutex
-	ob    *observable.Observable
-	peers map[string]*Peer
+	config *Config
+	mu     sync.RWMutex
+	peers  map[string]*Peer
+	// Handshakes of unknown peers waiting to get accepted, at most one per address.
+	accepts chan handler.Event
+	pending map[string]pendingHandshake
+	// The number of peers and pending handshakes by IP address, which the admission limits apply to.
+	sources map[string]int
+	// Closed once the interop stops accepting peers.
+	stopped chan struct{}
+	// Closed once the read loop has failed with the error.
+	failed chan struct{}
+	err    error
+}
+
+type pendingHandshake struct {
+	ip string
+	// When the handshake was queued.
+	at time.Time
 }
 
-func New(conn UDPConn) *Interop {
+// Create the interop on top of the connection. A nil config uses the defaults.
+func New(conn UDPConn, config *Config) *Interop {
 	iop := &Interop{
 		UDPConn: conn,
+		config:  config.normalize(),
 		peers:   make(map[string]*Peer),
-		ob:      observable.New(),
+		accepts: make(chan handler.Event, AcceptQueueSize),
+		pending: make(map[string]pendingHandshake),
+		sources: make(map[string]int),
+		stopped: make(chan struct{}),
+		failed:  make(chan struct{}),
 	}
 	iop.start()
 	return iop
 }
 
+// Get the peer at the address, connecting to it as the client if it's not known yet.
 func (i *Interop) Peer(raddr *net.UDPAddr) *Peer {
+	return i.peer(raddr, true)
+}
+
+func (i *Interop) peer(raddr *net.UDPAddr, client bool) *Peer {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 	addr := raddr.String()
 	p, ok := i.peers[addr]
 	if !ok {
-		p = NewPeer(i, raddr)
+		p = newPeer(i, raddr, client)
 		i.peers[addr] = p
+		// The pending handshake of the peer has been counted already
+		if _, ok := i.pending[addr]; !ok {
+			i.sources[raddr.IP.String()]++
+		}
+		delete(i.pending, addr)
+		logging.Info(p.log, "Peer opened", logging.Any("client", client))
 	}
 	return p
 }
 
 func (i *Interop) AcceptPeer() (*Peer, error) {
-	ob := i.ob.Observe()
-	defer ob.Dispose()
-	h := handler.AcceptPeer(i.exists)
-	ob.Handle(h)
+	return i.AcceptPeerContext(context.Background())
+}
+
+// Accept the next peer initiating the connection to us, giving up once the context is done.
+// The peer gets the handshake it has been accepted with, so it isn't lost to the peer.
+func (i *Interop) AcceptPeerContext(ctx context.Context) (*Peer, error) {
+	for {
+		// The queued handshakes never get accepted once stopped
+		select {
+		case <-i.stopped:
+			return nil, ErrAcceptInterrupted
+		default:
+		}
+		select {
+		case evt := <-i.accepts:
+			addr := evt.RemoteAddr.String()
+			i.mu.RLock()
+			_, known := i.peers[addr]
+			_, pending := i.pending[addr]
+			i.mu.RUnlock()
+			// Whoever has created the peer in the meantime has taken over its pending handshake,
+			// otherwise the handshake may have expired
+			if known || !pending {
+				continue
+			}
+			// The peer has initiated the connection, so we're the server
+			p := i.peer(evt.RemoteAddr, false)
+			p.enqueue(evt.Frame)
+			return p, nil
+		case <-i.stopped:
+			return nil, ErrAcceptInterrupted
+		case <-i.failed:
+			return nil, i.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Stop accepting peers, interrupting the accepts in progress and refusing the handshakes of new peers from now on.
+// The peers we've got keep going, while the handshakes waiting to get accepted get dropped.
+func (i *Interop) StopAccepting() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	select {
-	case raddr := <-h.RemoteAddr():
-		return i.Peer(raddr), nil
-	case err := <-h.Error():
-		return nil, err
+	case <-i.stopped:
+		return
+	default:
+		close(i.stopped)
+	}
+	for addr := range i.pending {
+		i.drop(addr)
+	}
+	for {
+		select {
+		case <-i.accepts:
+		default:
+			return
+		}
 	}
 }
 
-func (i *Interop) exists(addr string) bool {
-	i.mu.RLock()
-	defer i.mu.RUnlock()
-	_, ok := i.peers[addr]
-	return ok
+func (i *Interop) Config() *Config {
+	if i.config == nil {
+		return DefaultConfig()
+	}
+	return i.config
 }
 
 func (i *Interop) remove(addr string) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
+	p, ok := i.peers[addr]
+	if !ok {
+		return
+	}
 	delete(i.peers, addr)
+	i.release(p.RemoteAddr().IP.String())
+}
+
+// Drop the pending handshake of the address. The lock must be held.
+func (i *Interop) drop(addr string) {
+	i.release(i.pending[addr].ip)
+	delete(i.pending, addr)
+}
+
+// Drop the pending handshakes which have waited past AcceptTimeout. The lock must be held.
+func (i *Interop) expire(now time.Time) {
+	for addr, ph := range i.pending {
+		if now.Sub(ph.at) >= AcceptTimeout {
+			i.drop(addr)
+		}
+	}
+}
+
+// Stop counting a peer or pending handshake of the IP address. The lock must be held.
+func (i *Interop) release(ip string) {
+	if i.sources[ip]--; i.sources[ip] <= 0 {
+		delete(i.sources, ip)
+	}
 }
 
 func (i *Interop) start() {
-	go i.workerLoop()
+	if bc, ok := i.UDPConn.(BatchConn); ok {
+		go i.batchLoop(bc)
+	} else {
+		go i.workerLoop()
+	}
 }
 
 func (i *Interop) workerLoop() {
 	buf := frame.Buffer()
 	for {
 		n, raddr, err := i.ReadFromUDP(buf)
-		evt := handler.NewEvent(raddr, err)
 		if err != nil {
-			i.ob.Dispatch(evt)
+			i.fail(handler.NewEvent(raddr, err))
 			return
 		}
-		evt.Frame, evt.Error = frame.Decode(buf[:n])
-		if evt.Error == nil {
-			i.ob.Dispatch(evt)
-			i.mu.RLock()
-			p := i.peers[raddr.String()]
-			i.mu.RUnlock()
-			if p != nil {
-				p.ob.Dispatch(evt)
+		i.receive(buf[:n], raddr)
+	}
+}
+
+func (i *Interop) batchLoop(bc BatchConn) {
+	ms := make([]Message, BatchSize)
+	for k := range ms {
+		ms[k].Buffer = frame.Buffer()
+	}
+	for {
+		n, err := bc.ReadBatch(ms)
+		if err != nil {
+			i.fail(handler.NewEvent(nil, err))
+			return
+		}
+		for _, m := range ms[:n] {
+			i.receive(m.Buffer[:m.N], m.Addr)
+		}
+	}
+}
+
+// Decode and dispatch the frames in the datagram. The decoded frames own their bytes,
+// so the caller may reuse the buffer for the next read as soon as this returns.
+func (i *Interop) receive(b []byte, raddr *net.UDPAddr) {
+	// A single datagram may carry multiple coalesced frames
+	frames, err := frame.DecodePacket(b)
+	if err != nil {
+		if log := i.Config().Logger; log.Enabled(logging.LevelDebug) {
+			logging.Debug(log, "Dropped undecodable frames", logging.Peer(raddr), logging.Err(err))
+		}
+		if len(frames) <= 0 {
+			return
+		}
+	}
+	addr := raddr.String()
+	i.mu.RLock()
+	p := i.peers[addr]
+	i.mu.RUnlock()
+	if p != nil && p.tracer != nil {
+		p.tracer.PacketReceived(len(b), frameData(frames))
+	}
+	evt := handler.NewEvent(raddr, nil)
+	for _, f := range frames {
+		// Handshakes in unsupported versions never reach the peers,
+		// the peer gets told which versions to retry with instead
+		if hs, ok := f.Data.(*frame.Handshake); ok && !i.Config().supports(hs.Version) {
+			i.negotiate(hs, raddr)
+			continue
+		}
+		if p != nil {
+			p.enqueue(f)
+			continue
+		}
+		evt.Frame = f
+		if hs, ok := f.Data.(*frame.Handshake); ok {
+			if reason, ok := i.offer(addr, evt); !ok {
+				i.refuse(hs, raddr, reason)
+			}
+		}
+	}
+}
+
+// Queue the handshake of the unknown peer to get accepted, unless the peer already has one queued.
+// Reports why the peer gets refused instead, if it's past the admission limits or denied by the filter.
+func (i *Interop) offer(addr string, evt handler.Event) (frame.RefusalReason, bool) {
+	cfg := i.Config()
+	// The filter may take a while, so it's called without the lock
+	if cfg.AcceptFilter != nil && !cfg.AcceptFilter(evt.RemoteAddr) {
+		return frame.RefusalDenied, false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	select {
+	case <-i.stopped:
+		return frame.RefusalShutdown, false
+	default:
+	}
+	i.expire(time.Now())
+	// The peer may have been created since the handshake was received, which has its own handshakes
+	if _, ok := i.peers[addr]; ok {
+		return 0, true
+	}
+	if _, ok := i.pending[addr]; ok {
+		return 0, true
+	}
+	ip := evt.RemoteAddr.IP.String()
+	if cfg.MaxPeers > 0 && len(i.peers)+len(i.pending) >= cfg.MaxPeers {
+		return frame.RefusalPeerLimit, false
+	}
+	if cfg.MaxPeersPerIP > 0 && i.sources[ip] >= cfg.MaxPeersPerIP {
+		return frame.RefusalSourceLimit, false
+	}
+	select {
+	case i.accepts <- evt:
+		i.pending[addr] = pendingHandshake{ip: ip, at: time.Now()}
+		i.sources[ip]++
+	default:
+		logging.Warn(cfg.Logger, "Dropped handshake of unknown peer, accept queue full", logging.Peer(evt.RemoteAddr))
+	}
+	return 0, true
+}
+
+// Tell the peer its handshake has been refused. The refusal is smaller than the handshake,
+// so it's of no use for amplifying traffic towards a spoofed address.
+func (i *Interop) refuse(hs *frame.Handshake, raddr *net.UDPAddr, reason frame.RefusalReason) {
+	if log := i.Config().Logger; log.Enabled(logging.LevelDebug) {
+		logging.Debug(log, "Refused new peer", logging.Peer(raddr), logging.Any("reason", reason))
+	}
+	// Best effort, since the peer retries the handshake anyway
+	_, _ = i.WriteToUDP(frame.Encode(frame.Refusal{StreamID: hs.StreamID, Reason: reason}), raddr)
+}
+
+// Stop accepting peers once the read loop has failed, failing the accepts with the error.
+func (i *Interop) fail(evt handler.Event) {
+	logging.Error(i.Config().Logger, "Receiving failed", logging.Err(evt.Error))
+	i.err = evt.Error
+	close(i.failed)
+}
+
+func (i *Interop) negotiate(hs *frame.Handshake, raddr *net.UDPAddr) {
+	logging.Info(i.Config().Logger, "Unsupported protocol version, negotiating", logging.Peer(raddr),
+		logging.Stream(hs.StreamID.Uint16()), logging.Any("version", hs.Version))
+	sid := hs.StreamID
+	vn := frame.VersionNegotiation{
+		StreamID: sid,
+		Versions: i.Config().SupportedVersions,
+	}
+	// Best effort, since the peer retries the handshake anyway
+	_, _ = i.WriteToUDP(frame.Encode(vn), raddr)
+}
+
+// Write the datagrams, in a single batch if the connection supports it.
+func (i *Interop) write(ms []Message) error {
+	bc, ok := i.UDPConn.(BatchConn)
+	if !ok || len(ms) <= 1 {
+		for _, m := range ms {
+			if _, err := i.WriteToUDP(m.Buffer, m.Addr); err != nil {
+				return err
 			}
 		}
+		return nil
 	}
+	_, err := bc.WriteBatch(ms)
+	return err
 }
@@ -0,0 +1,127 @@
+package interop
+
+import (
+	"bytes"
+	"net"
+	"reliable-udp/protocol/frame"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInteropOwnership(t *testing.T) {
+	t.Run("UDPConn", func(t *testing.T) {
+		testInteropOwnership(t, func(conn *net.UDPConn) UDPConn {
+			return conn
+		})
+	})
+	t.Run("BatchConn", func(t *testing.T) {
+		testInteropOwnership(t, func(conn *net.UDPConn) UDPConn {
+			return NewBatchConn(conn)
+		})
+	})
+}
+
+// Concurrent peers flood the interop while the tracer holds on to every decoded frame.
+// Each chunk is filled with a pattern derived from its sender and sequence, so any chunk
+// still aliasing the read buffer would get overwritten by the next datagrams.
+func testInteropOwnership(t *testing.T, wrap func(*net.UDPConn) UDPConn) {
+	require := require.New(t)
+	const (
+		peers   = 4
+		packets = 200
+	)
+	_, receiver := loopbackPair(t)
+	tracer := &frameTracer{}
+	iop := New(wrap(receiver), &Config{Tracer: tracer.trace})
+	raddr := receiver.LocalAddr().(*net.UDPAddr)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(peers)
+	for k := 0; k < peers; k++ {
+		sender, _ := loopbackPair(t)
+		iop.Peer(sender.LocalAddr().(*net.UDPAddr))
+		go func(sid frame.StreamID, conn *net.UDPConn) {
+			defer wg.Done()
+			p := NewPeer(&Interop{UDPConn: conn}, raddr)
+			for seq := 0; seq < packets; seq++ {
+				p.Send(&frame.Stream{
+					StreamID: sid,
+					Sequence: uint16(seq),
+					Chunk:    chunkPattern(sid, uint16(seq)),
+				})
+			}
+		}(frame.StreamID(k+1), sender)
+	}
+	wg.Wait()
+
+	// Wait until the receiver goes quiet, since the kernel may drop some datagrams under load
+	for last := -1; ; {
+		time.Sleep(50 * time.Millisecond)
+		n := len(tracer.streams())
+		if n == last {
+			break
+		}
+		last = n
+	}
+
+	received := tracer.streams()
+	require.NotEmpty(received)
+	for _, s := range received {
+		require.True(bytes.Equal(chunkPattern(s.StreamID, s.Sequence), s.Chunk),
+			"corrupted chunk on stream %d sequence %d", s.StreamID, s.Sequence)
+	}
+}
+
+// Tracer recording the frames taken in by the peers' loops, which any number of peers may share.
+type frameTracer struct {
+	mu     sync.Mutex
+	frames []frame.Data
+	// Holds up the peers' loops once set, until it's closed.
+	block chan struct{}
+}
+
+func (t *frameTracer) trace(*net.UDPAddr, bool) Tracer {
+	return t
+}
+
+func (t *frameTracer) FrameParsed(data frame.Data) {
+	t.mu.Lock()
+	t.frames = append(t.frames, data)
+	block := t.block
+	t.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+}
+
+// The stream frames taken in so far.
+func (t *frameTracer) streams() []*frame.Stream {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	streams := make([]*frame.Stream, 0, len(t.frames))
+	for _, d := range t.frames {
+		if s, ok := d.(*frame.Stream); ok {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+func (t *frameTracer) PacketSent(int, []frame.Data)                           {}
+func (t *frameTracer) PacketReceived(int, []frame.Data)                       {}
+func (t *frameTracer) LossDetected(frame.Data)                                {}
+func (t *frameTracer) RTTUpdated(time.Duration, time.Duration, time.Duration) {}
+func (t *frameTracer) CongestionWindowUpdated(int)                            {}
+func (t *frameTracer) StreamStateUpdated(frame.StreamID, StreamState)         {}
+func (t *frameTracer) Close()                                                 {}
+
+func chunkPattern(sid frame.StreamID, seq uint16) []byte {
+	chunk := make([]byte, 64+int(seq)%512)
+	for i := range chunk {
+		chunk[i] = byte(int(sid)*31 + int(seq) + i)
+	}
+	return chunk
+}
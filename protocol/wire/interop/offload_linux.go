@@ -0,0 +1,66 @@
+package interop
+
+import (
+	"errors"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Socket options for the UDP segmentation offloads, available since Linux 4.18 (GSO) and 5.0 (GRO).
+const (
+	solUDP     = unix.IPPROTO_UDP
+	udpSegment = 103
+	udpGRO     = 104
+)
+
+const batchSupported = true
+
+// Enough space for a single control message carrying the segment size.
+var offloadOOBSize = unix.CmsgSpace(4)
+
+// Enable the segmentation offloads supported by the kernel for the connection.
+func enableOffload(conn *net.UDPConn) (gso bool, gro bool) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+	rc.Control(func(fd uintptr) {
+		_, err := unix.GetsockoptInt(int(fd), solUDP, udpSegment)
+		gso = err == nil
+		gro = unix.SetsockoptInt(int(fd), solUDP, udpGRO, 1) == nil
+	})
+	return gso, gro
+}
+
+// Append the control message telling the kernel to split the buffer into segments of the size.
+func appendSegmentSize(oob []byte, size int) []byte {
+	start := len(oob)
+	oob = append(oob, make([]byte, unix.CmsgSpace(2))...)
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[start]))
+	h.Level = solUDP
+	h.Type = udpSegment
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&oob[start+unix.CmsgLen(0)])) = uint16(size)
+	return oob
+}
+
+// Parse the segment size of a buffer coalesced by GRO, or zero if it wasn't coalesced.
+func parseSegmentSize(oob []byte) int {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, m := range msgs {
+		if m.Header.Level == solUDP && m.Header.Type == udpGRO && len(m.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+		}
+	}
+	return 0
+}
+
+// Sending with GSO fails with EIO when the device doesn't support checksum offloading.
+func isOffloadError(err error) bool {
+	return errors.Is(err, unix.EIO)
+}
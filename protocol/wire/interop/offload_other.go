@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package interop
+
+import "net"
+
+// Batch reads and writes along with the segmentation offloads are only supported on Linux.
+const (
+	batchSupported = false
+	offloadOOBSize = 0
+)
+
+func enableOffload(conn *net.UDPConn) (gso bool, gro bool) {
+	return false, false
+}
+
+func appendSegmentSize(oob []byte, size int) []byte {
+	return oob
+}
+
+func parseSegmentSize(oob []byte) int {
+	return 0
+}
+
+func isOffloadError(err error) bool {
+	return false
+}
@@ -0,0 +1,156 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire/interop/handler"
+	"reliable-udp/util/simnet"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportParams(t *testing.T) {
+	require := require.New(t)
+
+	// Test negotiation takes the peer's limits, and the lower of the limits both sides have to agree on
+	{
+		local := DefaultTransportParams()
+		remote := frame.TransportParams{
+			MaxStreams:   4,
+			IdleTimeout:  time.Hour,
+			MaxFrameSize: 600,
+		}
+		tp := negotiateParams(local, remote)
+		require.Equal(uint16(4), tp.MaxStreams)
+		require.Zero(tp.InitialWindow)
+		require.Equal(local.IdleTimeout, tp.IdleTimeout)
+		require.Equal(uint16(600), tp.MaxFrameSize)
+		require.False(tp.Features.Has(frame.FeatureFEC))
+	}
+
+	network := simnet.New(1, simnet.Config{Latency: time.Millisecond})
+	aconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer aconn.Close()
+	bconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer bconn.Close()
+
+	tracer := &frameTracer{}
+	a := New(aconn, nil)
+	b := New(bconn, &Config{TransportParams: frame.TransportParams{
+		MaxStreams:    1,
+		InitialWindow: 100,
+		IdleTimeout:   200 * time.Millisecond,
+		MaxFrameSize:  600,
+	}, Tracer: tracer.trace})
+	apeer := a.Peer(bconn.LocalAddr().(*net.UDPAddr))
+	bpeer := b.peer(aconn.LocalAddr().(*net.UDPAddr), false)
+	require.Equal(DefaultTransportParams(), apeer.TransportParams())
+
+	// The parameters are exchanged with the handshake and the handshake ACK
+	s, err := apeer.OpenStream()
+	require.Nil(err)
+	require.Nil(s.Handshake(100, make([]byte, 16)))
+	require.Eventually(func() bool {
+		network.Flush()
+		return bpeer.TransportParams().MaxStreams == DefaultTransportParams().MaxStreams
+	}, time.Second, 10*time.Millisecond)
+	require.Nil(bpeer.Stream(s.StreamID()).AckHandshake(frame.FrameMaxSize))
+	require.Eventually(func() bool {
+		network.Flush()
+		return apeer.TransportParams().MaxStreams == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Test the peer's limits get enforced on what we send
+	{
+		tp := apeer.TransportParams()
+		require.Equal(uint32(100), tp.InitialWindow)
+		require.Equal(200*time.Millisecond, tp.IdleTimeout)
+		require.Eventually(func() bool {
+			apeer.pmu.Lock()
+			defer apeer.pmu.Unlock()
+			return apeer.packet.MaxSize() == 600
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(ErrWindowExceeded, s.Stream(0, 50, make([]byte, 51)))
+		require.Nil(s.Stream(0, 50, make([]byte, 50)))
+	}
+
+	// Test our own limits get enforced on what we receive
+	{
+		bpeer.dispatch(handler.Event{Frame: &frame.Frame{Data: &frame.Stream{StreamID: 1, Chunk: make([]byte, 101)}}})
+		bpeer.dispatch(handler.Event{Frame: &frame.Frame{Data: &frame.Stream{StreamID: 1, Chunk: make([]byte, 100)}}})
+		require.Eventually(func() bool {
+			network.Flush()
+			return len(tracer.streams()) >= 2
+		}, time.Second, 10*time.Millisecond)
+		for _, s := range tracer.streams() {
+			require.LessOrEqual(int(s.Offset)+len(s.Chunk), 100)
+		}
+
+		// Only the handshakes, which get padded in full, may be larger than our maximum frame size
+		require.False(bpeer.admit(&frame.Frame{Data: &frame.Repair{StreamID: s.StreamID(), Count: 2, Parity: make([]byte, 600)}}))
+		require.True(bpeer.admit(&frame.Frame{Data: &frame.Repair{StreamID: s.StreamID(), Count: 2, Parity: make([]byte, 500)}}))
+		hs := &frame.Frame{Data: &frame.Handshake{StreamID: s.StreamID(), Length: 100, Hash: make([]byte, 16)}}
+		require.Greater(hs.Size(), 600)
+		require.True(bpeer.admit(hs))
+	}
+
+	// Test the open streams keep the connection alive however quiet they are, since the peers ping each other
+	stop := network.Realtime(10 * time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	stop()
+	require.False(apeer.Closed())
+	require.False(bpeer.Closed())
+
+	// Test both sides close the connection once it goes idle without any stream
+	require.Nil(s.Close())
+	require.Nil(bpeer.Stream(s.StreamID()).Close())
+	require.Eventually(func() bool {
+		return apeer.Closed() && bpeer.Closed()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Test the connection FIN ends the peer only once the handshake has completed, since the one before
+	// is a late one of the previous connection. Nor does the peer ending the connection get a FIN frame back,
+	// which would end its next connection instead.
+	{
+		conn := &captureConn{}
+		p := NewPeer(&Interop{UDPConn: conn}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+		fin := handler.Event{Frame: &frame.Frame{Data: &frame.Fin{}}}
+		p.dispatch(fin)
+		require.False(p.Closed())
+		p.dispatch(handler.Event{Frame: &frame.Frame{Data: &frame.HandshakeAck{StreamID: 1}}})
+		p.dispatch(fin)
+		require.True(p.Closed())
+		require.Empty(conn.packets)
+		require.Equal(ErrPeerAlreadyClosed, p.Close())
+	}
+
+	// Test a peer which never answers our pings times out even with open streams
+	{
+		conn := &captureConn{}
+		p := NewPeer(&Interop{UDPConn: conn}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+		params := DefaultTransportParams()
+		params.IdleTimeout = 150 * time.Millisecond
+		p.dispatch(handler.Event{Frame: &frame.Frame{Data: &frame.HandshakeAck{StreamID: 1, Params: params}}})
+		_, err := p.OpenStream()
+		require.Nil(err)
+		require.Eventually(p.Closed, time.Second, 10*time.Millisecond)
+
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		pings := 0
+		for _, b := range conn.packets {
+			frames, err := frame.DecodePacket(b)
+			require.Nil(err)
+			for _, f := range frames {
+				if ping, ok := f.Data.(*frame.Ping); ok && !ping.Ack {
+					pings++
+				}
+			}
+		}
+		require.GreaterOrEqual(pings, 2)
+	}
+}
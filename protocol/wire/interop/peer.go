@@ -1,17 +1,23 @@
 package interop
 
 import (
+	"context"
 	"errors"
-	"math"
 	"net"
 	"reliable-udp/protocol/frame"
-	"reliable-udp/util/observable"
+	"reliable-udp/protocol/wire/interop/handler"
+	"reliable-udp/util/logging"
+	"reliable-udp/util/ratelimit"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	ErrPeerAlreadyClosed = errors.New("peer already closed")
 	ErrStreamsExhausted  = errors.New("streams exhausted")
+	ErrExtensionRefused  = errors.New("extension not negotiated with the peer")
+	ErrWindowExceeded    = errors.New("stream window exceeded")
 )
 
 type Handshake struct {
@@ -19,82 +25,696 @@ type Handshake struct {
 	frame.Handshake
 }
 
+// The number of received frames a peer holds on to until its loop gets to them.
+// Frames past it get dropped rather than holding up the read loop, leaving it to the sender
+// to retransmit them, so a peer slow at handling its frames never holds up the other peers.
+const PeerQueueSize = 1024
+
+// The number of received extension frames a peer holds on to until they're taken out of it, see Peer.ExtensionFrames.
+const ExtensionQueueSize = 64
+
+const (
+	// The number of stream IDs in either direction for either side, since the lower two bits of the ID
+	// tell the initiator and the direction.
+	streamIDCount = 1 << 14
+	// How long the ID of a closed stream is kept out of use, so the peer's late frames
+	// of the closed stream never get mistaken for frames of a new stream with the same ID.
+	StreamIDQuarantine = 10 * time.Second
+	// How often a quiet peer with open streams gets pinged, as a fraction of the idle timeout,
+	// so losing a ping or two doesn't get a live peer timed out.
+	keepAliveDivisor = 3
+)
+
 type Peer struct {
+	// Accessed atomically, so kept first for the 64-bit alignment.
+	dropped uint64
+	seen    int64
+
 	interop *Interop
 	raddr   *net.UDPAddr
-	ob      *observable.Observable
+	log     logging.Logger
+	tracer  Tracer
+	inbox   chan *frame.Frame
+	done    chan struct{}
 
 	streams map[frame.StreamID]*Stream
-	nextId  frame.StreamID
-	mu      sync.RWMutex
+	// Client streams have odd IDs, server streams even ones.
+	client       bool
+	nextId       [2]int
+	retired      map[frame.StreamID]time.Time
+	quarantine   time.Duration
+	acceptLimits [2]uint16
+	accepts      [2]chan *frame.Handshake
+	offered      map[frame.StreamID]bool
+	cond         *sync.Cond
+	blocked      chan frame.StreamsBlocked
+	received     chan frame.Data
+	mu           sync.RWMutex
+
+	packet *frame.Packet
+	outbox []*[]byte
+	msgs   []Message
+	fec    *fecEncoder
+	limit  *ratelimit.Bucket
+	pmu    sync.Mutex
+
+	repair *fecDecoder
+
+	version     uint32
+	extensions  []frame.FrameType
+	params      frame.TransportParams
+	paramsKnown bool
+	idle        *time.Timer
+	keepalive   *time.Timer
 
 	closed bool
+	err    error
 }
 
+// Create the peer we're the client of, meaning we've initiated the connection to the peer.
 func NewPeer(interop *Interop, raddr *net.UDPAddr) *Peer {
-	return &Peer{
-		interop: interop,
-		raddr:   raddr,
-		ob:      observable.New(),
+	return newPeer(interop, raddr, true)
+}
+
+func newPeer(interop *Interop, raddr *net.UDPAddr, client bool) *Peer {
+	version := frame.ProtocolVersion
+	params := DefaultTransportParams()
+	log := logging.Nop()
+	var tracer Tracer
+	var limit ratelimit.Limit
+	if interop != nil {
+		limit = interop.Config().PeerRateLimit
+		version = interop.Config().SupportedVersions[0]
+		params = interop.Config().TransportParams
+		log = logging.With(interop.Config().Logger, logging.Peer(raddr))
+		if newTracer := interop.Config().Tracer; newTracer != nil {
+			tracer = newTracer(raddr, client)
+		}
+	}
+	p := &Peer{
+		interop:      interop,
+		raddr:        raddr,
+		log:          log,
+		tracer:       tracer,
+		inbox:        make(chan *frame.Frame, PeerQueueSize),
+		done:         make(chan struct{}),
+		streams:      make(map[frame.StreamID]*Stream),
+		client:       client,
+		acceptLimits: [2]uint16{params.MaxStreams, params.MaxUniStreams},
+		accepts:      [2]chan *frame.Handshake{make(chan *frame.Handshake, AcceptQueueSize), make(chan *frame.Handshake, AcceptQueueSize)},
+		offered:      make(map[frame.StreamID]bool),
+		retired:      make(map[frame.StreamID]time.Time),
+		quarantine:   StreamIDQuarantine,
+		blocked:      make(chan frame.StreamsBlocked, 1),
+		received:     make(chan frame.Data, ExtensionQueueSize),
+		packet:       frame.NewPacket(frame.PacketMaxSize),
+		limit:        ratelimit.New(limit),
+		repair:       newFECDecoder(),
+		version:      version,
 	}
+	p.nextId[frame.Bidirectional] = p.firstId(frame.Bidirectional)
+	p.cond = sync.NewCond(&p.mu)
+	go p.loop(raddr)
+	return p
 }
 
 func (p *Peer) RemoteAddr() *net.UDPAddr {
 	return p.raddr
 }
 
+// The tracer of the peer, which is nil unless the interop's config has a tracer.
+func (p *Peer) Tracer() Tracer {
+	return p.tracer
+}
+
+// The protocol version spoken with the peer. It starts out as our most preferred version,
+// then follows the version of the peer's handshakes or the outcome of the version negotiation.
+func (p *Peer) Version() uint32 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.version
+}
+
+// Extension frame types negotiated with the peer, which are the ones both sides understand.
+func (p *Peer) Extensions() []frame.FrameType {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]frame.FrameType(nil), p.extensions...)
+}
+
+// Transport parameters negotiated with the peer, see negotiateParams.
+// Until the peer has sent its parameters, these are our own parameters.
+func (p *Peer) TransportParams() frame.TransportParams {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.paramsKnown {
+		return p.localParams()
+	}
+	return p.params
+}
+
+// Our own transport parameters, the limits the peer has to keep to.
+func (p *Peer) LocalTransportParams() frame.TransportParams {
+	return p.localParams()
+}
+
+func (p *Peer) localParams() frame.TransportParams {
+	if p.interop == nil {
+		return DefaultTransportParams()
+	}
+	return p.interop.Config().TransportParams
+}
+
+func (p *Peer) negotiated(ft frame.FrameType) bool {
+	for _, ext := range p.extensions {
+		if ext == ft {
+			return true
+		}
+	}
+	return false
+}
+
+// Open a new bidirectional stream towards the peer, see openStream.
 func (p *Peer) OpenStream() (*Stream, error) {
+	return p.openStream(context.Background(), frame.Bidirectional)
+}
+
+// Open a new bidirectional stream towards the peer, giving up on waiting for the peer
+// to allow more streams once the context is done.
+func (p *Peer) OpenStreamContext(ctx context.Context) (*Stream, error) {
+	return p.openStream(ctx, frame.Bidirectional)
+}
+
+// Open a new unidirectional stream towards the peer, over which only we send data.
+func (p *Peer) OpenUniStream() (*Stream, error) {
+	return p.openStream(context.Background(), frame.Unidirectional)
+}
+
+func (p *Peer) OpenUniStreamContext(ctx context.Context) (*Stream, error) {
+	return p.openStream(ctx, frame.Unidirectional)
+}
+
+// Open a new stream in the direction. Once we've got as many streams open as the peer allows,
+// the peer gets told we're blocked and opening waits until the peer raises the limit,
+// until any of our streams gets closed or until the context is done.
+func (p *Peer) openStream(ctx context.Context, dir frame.Direction) (*Stream, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	sid := p.nextId
+	stop := broadcastOnDone(ctx, p.cond)
+	defer stop()
+	blocked := false
 	for {
-		if sid >= math.MaxUint16-1 {
-			return nil, ErrStreamsExhausted
+		if p.closed {
+			if p.err != nil {
+				return nil, p.err
+			}
+			return nil, ErrPeerAlreadyClosed
 		}
-		sid++
-		if _, ok := p.streams[sid]; !ok {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		limit := *maxStreams(&p.params, dir)
+		if limit <= 0 || p.countStreams(true, dir) < int(limit) {
 			break
 		}
+		if blocked {
+			p.cond.Wait()
+			continue
+		}
+		// Sending takes the lock, so let go of it in the meantime
+		blocked = true
+		p.mu.Unlock()
+		err := p.Send(frame.StreamsBlocked{Direction: dir, Limit: limit})
+		p.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
 	}
-	p.nextId = sid
-	s := NewStream(p, sid)
-	p.streams[sid] = s
-	return s, nil
+	// Once the IDs run out, the ones of the streams closed long enough ago get reused
+	now := time.Now()
+	for i := 0; i < streamIDCount; i++ {
+		n := p.nextId[dir]
+		if p.nextId[dir]++; p.nextId[dir] >= streamIDCount {
+			p.nextId[dir] = p.firstId(dir)
+		}
+		sid := frame.NewStreamID(n, p.client, dir)
+		if _, ok := p.streams[sid]; ok {
+			continue
+		}
+		if closed, ok := p.retired[sid]; ok {
+			if now.Sub(closed) < p.quarantine {
+				continue
+			}
+			delete(p.retired, sid)
+		}
+		return p.addStream(sid), nil
+	}
+	return nil, ErrStreamsExhausted
+}
+
+// Sequence number of the first stream we open in the direction. Stream ID zero stands for the whole connection.
+func (p *Peer) firstId(dir frame.Direction) int {
+	if !p.client && dir == frame.Bidirectional {
+		return 1
+	}
+	return 0
+}
+
+// Raise the number of concurrent bidirectional streams the peer may open towards us.
+// The limit only ever goes up, so a lower limit than the current one is ignored.
+func (p *Peer) SetMaxStreams(limit uint16) error {
+	return p.setMaxStreams(frame.Bidirectional, limit)
+}
+
+// Raise the number of concurrent unidirectional streams the peer may open towards us.
+func (p *Peer) SetMaxUniStreams(limit uint16) error {
+	return p.setMaxStreams(frame.Unidirectional, limit)
+}
+
+func (p *Peer) setMaxStreams(dir frame.Direction, limit uint16) error {
+	p.mu.Lock()
+	if limit <= p.acceptLimits[dir] {
+		p.mu.Unlock()
+		return nil
+	}
+	p.acceptLimits[dir] = limit
+	p.mu.Unlock()
+	return p.Send(frame.MaxStreams{Direction: dir, Count: limit})
 }
 
+// StreamsBlocked signals the direction and the limit the peer is blocked at whenever
+// it'd like to open more streams than we allow, so we may decide to raise the limit.
+func (p *Peer) StreamsBlocked() <-chan frame.StreamsBlocked {
+	return p.blocked
+}
+
+// ExtensionFrames hands over the extension frames received from the peer, which the frame types
+// registered with frame.Register decode into. Frames past ExtensionQueueSize get dropped until taken out.
+func (p *Peer) ExtensionFrames() <-chan frame.Data {
+	return p.received
+}
+
+func (p *Peer) receiveExtension(data frame.Data) {
+	select {
+	case p.received <- data:
+	default:
+		if p.log.Enabled(logging.LevelDebug) {
+			logging.Debug(p.log, "Dropped extension frame, queue full", logging.Frame(data.Type()))
+		}
+	}
+}
+
+// Whether the stream has been initiated by us rather than by the peer.
+func (p *Peer) initiated(sid frame.StreamID) bool {
+	return sid.ClientInitiated() == p.client
+}
+
+// Count the open streams in the direction initiated either by us or by the peer.
+// The streams opened by the peer include the ones waiting to get accepted.
+func (p *Peer) countStreams(local bool, dir frame.Direction) int {
+	n := 0
+	for sid := range p.streams {
+		if p.initiated(sid) == local && sid.Direction() == dir {
+			n++
+		}
+	}
+	if !local {
+		for sid := range p.offered {
+			if sid.Direction() == dir {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// The transport parameter limiting the concurrent streams in the direction.
+func maxStreams(tp *frame.TransportParams, dir frame.Direction) *uint16 {
+	if dir == frame.Unidirectional {
+		return &tp.MaxUniStreams
+	}
+	return &tp.MaxStreams
+}
+
+// Accept the next bidirectional stream the peer has opened.
 func (p *Peer) AcceptStream() (*Stream, error) {
-	ob := p.ob.Observe()
-	defer ob.Dispose()
-	ch := make(chan interface{})
-	ob.HandleFunc(func(o *ob))
-	switch v := (<-ch).(type) {
-	case error:
-		return nil, v
-	case frame.StreamID:
-		return p.Stream(v), nil
+	return p.acceptStream(context.Background(), frame.Bidirectional)
+}
+
+// Accept the next bidirectional stream the peer has opened, giving up once the context is done.
+func (p *Peer) AcceptStreamContext(ctx context.Context) (*Stream, error) {
+	return p.acceptStream(ctx, frame.Bidirectional)
+}
+
+// Accept the next unidirectional stream the peer has opened, over which only the peer sends data.
+func (p *Peer) AcceptUniStream() (*Stream, error) {
+	return p.acceptStream(context.Background(), frame.Unidirectional)
+}
+
+func (p *Peer) AcceptUniStreamContext(ctx context.Context) (*Stream, error) {
+	return p.acceptStream(ctx, frame.Unidirectional)
+}
+
+// Accept the next stream in the direction. The stream receives the handshake it has been
+// accepted with, so the handshake isn't lost to the stream even though it came before the stream.
+func (p *Peer) acceptStream(ctx context.Context, dir frame.Direction) (*Stream, error) {
+	select {
+	case hs := <-p.accepts[dir]:
+		p.mu.Lock()
+		delete(p.offered, hs.StreamID)
+		p.mu.Unlock()
+		s, err := p.stream(hs.StreamID)
+		if err != nil {
+			return nil, err
+		}
+		s.push(&frame.Frame{Data: hs})
+		return s, nil
+	case <-p.done:
+		return nil, ErrAcceptInterrupted
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	return nil, ErrAcceptInterrupted
 }
 
+// Get the stream with the ID, creating it if it's not known yet.
+// It's nil once the peer is closed.
 func (p *Peer) Stream(sid frame.StreamID) *Stream {
+	s, _ := p.stream(sid)
+	return s
+}
+
+func (p *Peer) stream(sid frame.StreamID) (*Stream, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPeerAlreadyClosed
+	}
 	s, ok := p.streams[sid]
 	if !ok {
-		s = NewStream(p, sid)
-		p.streams[sid] = s
+		s = p.addStream(sid)
+	}
+	return s, nil
+}
+
+// Create the stream with the ID. The caller holds the lock.
+func (p *Peer) addStream(sid frame.StreamID) *Stream {
+	s := NewStream(p, sid)
+	p.streams[sid] = s
+	if p.tracer != nil {
+		p.tracer.StreamStateUpdated(sid, StreamOpen)
 	}
 	return s
 }
 
-func (p *Peer) Send(data frame.Data) error {
-	b := frame.New(data).Bytes()
-	_, err := p.interop.WriteToUDP(b, p.raddr)
+// Hand the frame over to the stream it belongs to. The handshake of a stream we don't know about
+// yet gets queued to be accepted instead, unless it's already queued.
+func (p *Peer) route(f *frame.Frame) {
+	sid, ok := streamOf(f.Data)
+	if !ok || sid == 0 {
+		return
+	}
+	p.mu.Lock()
+	s := p.streams[sid]
+	if hs, ok := f.Data.(*frame.Handshake); ok && s == nil && !p.closed && !p.initiated(sid) && !p.offered[sid] {
+		select {
+		case p.accepts[sid.Direction()] <- hs:
+			p.offered[sid] = true
+		default:
+			logging.Warn(p.log, "Dropped handshake of new stream, accept queue full", logging.Stream(sid.Uint16()))
+		}
+	}
+	p.mu.Unlock()
+	if s != nil {
+		s.push(f)
+	}
+}
+
+// The stream the frame belongs to, for the frames which belong to any.
+func streamOf(data frame.Data) (frame.StreamID, bool) {
+	switch v := data.(type) {
+	case *frame.Handshake:
+		return v.StreamID, true
+	case *frame.HandshakeAck:
+		return v.StreamID, true
+	case *frame.Stream:
+		return v.StreamID, true
+	case *frame.StreamAck:
+		return v.StreamID, true
+	case *frame.Fin:
+		return v.StreamID, true
+	case *frame.Shutdown:
+		return v.StreamID, true
+	case *frame.Refusal:
+		return v.StreamID, true
+	}
+	return 0, false
+}
+
+// Wake up the waiters on the condition once the context is done, so they get to check the context.
+// The returned function stops watching the context, which must be called once done waiting.
+func broadcastOnDone(ctx context.Context, cond *sync.Cond) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cond.L.Lock()
+			cond.Broadcast()
+			cond.L.Unlock()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
+
+// Send the frames right away, coalescing them together with any queued frames
+// into as few datagrams as possible.
+func (p *Peer) Send(data ...frame.Data) error {
+	if p.Closed() {
+		return ErrPeerAlreadyClosed
+	}
+	return p.send(data...)
+}
+
+func (p *Peer) send(data ...frame.Data) error {
+	p.pmu.Lock()
+	defer p.pmu.Unlock()
+	for _, d := range data {
+		if err := p.queue(d); err != nil {
+			return err
+		}
+	}
+	return p.flush()
+}
+
+// Queue the frame to be sent out together with the next frames.
+// The queued frames are sent out once the packet is full or when the peer gets flushed.
+func (p *Peer) Queue(data frame.Data) error {
+	if p.Closed() {
+		return ErrPeerAlreadyClosed
+	}
+	p.pmu.Lock()
+	defer p.pmu.Unlock()
+	if err := p.queue(data); err != nil {
+		return err
+	}
+	return p.writeOutbox()
+}
+
+// Flush sends out the queued frames.
+func (p *Peer) Flush() error {
+	if p.Closed() {
+		return ErrPeerAlreadyClosed
+	}
+	p.pmu.Lock()
+	defer p.pmu.Unlock()
+	return p.flush()
+}
+
+// Set the maximum size of a single datagram to send to the peer, usually based on
+// the size the peer has told us with the handshake ACK frame.
+func (p *Peer) SetMaxPacketSize(size int) error {
+	p.pmu.Lock()
+	defer p.pmu.Unlock()
+	if err := p.flush(); err != nil {
+		return err
+	}
+	p.packet = frame.NewPacket(size)
+	return nil
+}
+
+// Enable forward error correction, sending a repair frame after every group of stream frames
+// so the peer is able to rebuild a single lost chunk in the group without a retransmission.
+// Smaller groups add more redundancy at the cost of more repair frames on the wire.
+func (p *Peer) EnableFEC(groupSize int) error {
+	enc, err := newFECEncoder(groupSize)
+	if err != nil {
+		return err
+	}
+	p.pmu.Lock()
+	defer p.pmu.Unlock()
+	p.fec = enc
+	return nil
+}
+
+// Limit the rate of the datagrams sent to the peer, counting every byte of them. Sending waits for the limit,
+// on top of the streams' own limits and the retransmission timeouts. A zero rate lifts the limit.
+func (p *Peer) SetRateLimit(limit ratelimit.Limit) {
+	p.limit.SetLimit(limit)
+}
+
+func (p *Peer) RateLimit() ratelimit.Limit {
+	return p.limit.Limit()
+}
+
+func (p *Peer) DisableFEC() {
+	p.pmu.Lock()
+	defer p.pmu.Unlock()
+	p.fec = nil
+}
+
+func (p *Peer) queue(data frame.Data) error {
+	fec, err := p.check(data)
+	if err != nil {
+		return err
+	}
+	if !fec {
+		return p.queueFrame(data)
+	}
+	switch v := data.(type) {
+	case frame.Stream:
+		return p.queueStream(&v)
+	case *frame.Stream:
+		return p.queueStream(v)
+	case frame.Fin:
+		return p.queueFin(v.StreamID, data)
+	case *frame.Fin:
+		return p.queueFin(v.StreamID, data)
+	}
+	return p.queueFrame(data)
+}
+
+// Check the frame against what has been negotiated with the peer,
+// reporting whether the frame goes through forward error correction.
+func (p *Peer) check(data frame.Data) (bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	// The peer would reject the whole packet over a critical frame it doesn't understand
+	if ft := data.Type(); ft.Extension() && ft.Critical() && !p.negotiated(ft) {
+		return false, ErrExtensionRefused
+	}
+	if !p.paramsKnown {
+		return p.fec != nil, nil
+	}
+	if window := int(p.params.InitialWindow); window > 0 {
+		end := 0
+		switch v := data.(type) {
+		case frame.Stream:
+			end = int(v.Offset) + len(v.Chunk)
+		case *frame.Stream:
+			end = int(v.Offset) + len(v.Chunk)
+		}
+		if end > window {
+			return false, ErrWindowExceeded
+		}
+	}
+	return p.fec != nil && p.params.Features.Has(frame.FeatureFEC), nil
+}
+
+func (p *Peer) queueStream(s *frame.Stream) error {
+	if err := p.queueFrame(s); err != nil {
+		return err
+	}
+	if r := p.fec.add(s); r != nil {
+		return p.queueFrame(r)
+	}
+	return nil
+}
+
+// Send out the repair frame of the partial group before closing the stream.
+func (p *Peer) queueFin(sid frame.StreamID, data frame.Data) error {
+	if sid == 0 {
+		for sid := range p.fec.groups {
+			if r := p.fec.close(sid); r != nil {
+				if err := p.queueFrame(r); err != nil {
+					return err
+				}
+			}
+		}
+	} else if r := p.fec.close(sid); r != nil {
+		if err := p.queueFrame(r); err != nil {
+			return err
+		}
+	}
+	return p.queueFrame(data)
+}
+
+// Add the frame to the current packet, moving the packet into the outbox once it's full.
+func (p *Peer) queueFrame(data frame.Data) error {
+	err := p.packet.Add(data)
+	if err != frame.ErrPacketFull {
+		return err
+	}
+	p.enqueuePacket()
+	return p.packet.Add(data)
+}
+
+func (p *Peer) flush() error {
+	if !p.packet.Empty() {
+		p.enqueuePacket()
+	}
+	return p.writeOutbox()
+}
+
+func (p *Peer) enqueuePacket() {
+	b := getPacketBuffer()
+	*b = append(*b, p.packet.Bytes()...)
+	p.outbox = append(p.outbox, b)
+	p.packet.Reset()
+}
+
+// Write out the full packets, in a single batch if possible.
+func (p *Peer) writeOutbox() error {
+	if len(p.outbox) <= 0 {
+		return nil
+	}
+	p.msgs = p.msgs[:0]
+	size := 0
+	for _, b := range p.outbox {
+		p.msgs = append(p.msgs, Message{Buffer: *b, Addr: p.raddr})
+		size += len(*b)
+	}
+	// Once the peer is closing, whatever is left goes out right away
+	if p.limit.Limited() {
+		_ = p.limit.Wait(size, p.done)
+	}
+	// Traced ahead of writing, since the peer's reply may otherwise get traced before the packet itself
+	if p.tracer != nil {
+		for _, m := range p.msgs {
+			frames, _ := frame.DecodePacket(m.Buffer)
+			p.tracer.PacketSent(len(m.Buffer), frameData(frames))
+		}
+	}
+	err := p.interop.write(p.msgs)
+	for i, b := range p.outbox {
+		putPacketBuffer(b)
+		p.outbox[i] = nil
+	}
+	p.outbox = p.outbox[:0]
 	return err
 }
 
 func (p *Peer) Close() error {
-	return p.close(true)
+	return p.close(true, true)
+}
+
+// Done is closed once the peer gets closed, whether by us, by the peer or by the idle timeout.
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
 }
 
 func (p *Peer) Closed() bool {
@@ -114,50 +734,344 @@ func (p *Peer) remove(sid frame.StreamID) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	delete(p.streams, sid)
+	if p.initiated(sid) {
+		p.retired[sid] = time.Now()
+	}
+	p.repair.drop(sid)
+	p.cond.Broadcast()
 }
 
-func (p *Peer) startWorker() {
-	p.once.Do(p.observeConnection)
+// Queue the received frame for the peer's loop, dropping it if the queue is full.
+func (p *Peer) enqueue(f *frame.Frame) {
+	select {
+	case p.inbox <- f:
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+		if p.log.Enabled(logging.LevelDebug) {
+			logging.Debug(p.log, "Dropped received frame, peer queue full", logging.Frame(f.Type()))
+		}
+	}
 }
 
-func (p *Peer) observeConnection() {
-	p.interop.ob.Observe().HandleFunc(func(o *observable.Observer, v interface{}) {
-		e, ok := v.(event)
-		if !ok {
+// The number of received frames dropped so far, since the peer's loop couldn't keep up with them.
+func (p *Peer) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Handle the received frames one at a time until the peer is closed.
+func (p *Peer) loop(raddr *net.UDPAddr) {
+	for {
+		select {
+		case f := <-p.inbox:
+			p.dispatch(handler.Event{Frame: f, RemoteAddr: raddr})
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Peer) dispatch(evt handler.Event) {
+	if p.Closed() {
+		return
+	}
+	if !p.admit(evt.Frame) {
+		return
+	}
+	if p.tracer != nil {
+		p.tracer.FrameParsed(evt.Frame.Data)
+	}
+	p.touch()
+	switch v := evt.Frame.Data.(type) {
+	case *frame.Handshake:
+		p.setVersion(v.Version)
+		p.setExtensions(v.Extensions)
+		p.setParams(v.Params)
+	case *frame.HandshakeAck:
+		p.setExtensions(v.Extensions)
+		p.setParams(v.Params)
+	case *frame.VersionNegotiation:
+		if !p.negotiate(v) {
+			logging.Warn(p.log, "No protocol version in common", logging.Any("versions", v.Versions))
+			p.fail(ErrVersionMismatch)
 			return
 		}
-		if e.raddr.String() != p.raddr.String() {
+		logging.Info(p.log, "Protocol version negotiated", logging.Any("version", p.Version()))
+	case *frame.MaxStreams:
+		p.raiseStreams(v.Direction, v.Count)
+	case *frame.StreamsBlocked:
+		select {
+		case p.blocked <- *v:
+		default:
+		}
+	case *frame.Ping:
+		if !v.Ack {
+			_ = p.Send(frame.Ping{Ack: true})
+		}
+	case *frame.Refusal:
+		logging.Warn(p.log, "Refused by the peer", logging.Stream(v.StreamID.Uint16()), logging.Any("reason", v.Reason))
+	case *frame.Fin:
+		// The FIN frame of stream ID zero closes the whole connection
+		if v.StreamID == 0 {
+			logging.Info(p.log, "Peer closed the connection")
+			// No FIN back, it would end the peer's next connection to us
+			_ = p.close(true, false)
 			return
 		}
-		p.ob.Dispatch(e)
-	}, func(*observable.Observer) {
-		p.ob.Dispose()
-	})
+	}
+	if ft := evt.Frame.Type(); ft.Extension() {
+		p.receiveExtension(evt.Frame.Data)
+	}
+	p.route(evt.Frame)
+	// Without repair frames coming, there's no point in holding on to the stream frames
+	if !p.TransportParams().Features.Has(frame.FeatureFEC) {
+		return
+	}
+	if s := p.repair.receive(evt.Frame); s != nil {
+		if p.tracer != nil {
+			p.tracer.FrameParsed(s)
+		}
+		p.route(&frame.Frame{Data: s})
+	}
+}
+
+// Enforce our own transport parameters on the received frame, reporting whether the frame is let through.
+func (p *Peer) admit(f *frame.Frame) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	local := p.localParams()
+	// Handshakes get padded to tell how large a packet makes it through, so only they may be larger
+	if _, ok := f.Data.(*frame.Handshake); !ok && local.MaxFrameSize > 0 && frameSize(f) > int(local.MaxFrameSize) {
+		logging.Debug(p.log, "Dropped frame past the maximum frame size", logging.Frame(f.Type()))
+		return false
+	}
+	switch v := f.Data.(type) {
+	case *frame.Handshake:
+		if _, ok := p.streams[v.StreamID]; ok {
+			return true
+		}
+		// New streams must be initiated by the peer and within our limit,
+		// handshakes of the other streams get ignored so the peer eventually gives up on them
+		dir := v.StreamID.Direction()
+		if v.StreamID == 0 || p.initiated(v.StreamID) || p.countStreams(false, dir) >= int(p.acceptLimits[dir]) {
+			logging.Debug(p.log, "Refused new stream", logging.Stream(v.StreamID.Uint16()))
+			return false
+		}
+	case *frame.Refusal:
+		// Only a peer we haven't completed a handshake with may refuse us, so the refusal can't be spoofed afterwards
+		if p.paramsKnown {
+			logging.Debug(p.log, "Dropped refusal after the handshake", logging.Stream(v.StreamID.Uint16()))
+			return false
+		}
+	case *frame.Fin:
+		// A connection FIN before the handshake is a late one of the previous connection
+		if v.StreamID == 0 && !p.paramsKnown {
+			logging.Debug(p.log, "Dropped FIN of an earlier connection")
+			return false
+		}
+	case *frame.Stream:
+		if int(v.Offset)+len(v.Chunk) > int(local.InitialWindow) {
+			logging.Debug(p.log, "Dropped frame past the window", logging.Stream(v.StreamID.Uint16()), logging.Frame(v.Type()))
+			return false
+		}
+	}
+	return true
+}
+
+// The size of the frame on the wire, without encoding the stream frames all over again.
+func frameSize(f *frame.Frame) int {
+	if s, ok := f.Data.(*frame.Stream); ok {
+		return frame.FrameBaseSize + frame.StreamBaseSize + s.Length()
+	}
+	return f.Size()
+}
+
+// Reset the idle timer on activity from the peer. The timer only starts once the idle timeout has been negotiated.
+func (p *Peer) touch() {
+	atomic.StoreInt64(&p.seen, time.Now().UnixNano())
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.idle != nil {
+		p.idle.Reset(p.params.IdleTimeout)
+		p.keepalive.Reset(p.params.IdleTimeout / keepAliveDivisor)
+	}
+}
+
+// When the peer was last heard from, the zero time if never.
+func (p *Peer) LastSeen() time.Time {
+	if seen := atomic.LoadInt64(&p.seen); seen != 0 {
+		return time.Unix(0, seen)
+	}
+	return time.Time{}
+}
+
+func (p *Peer) setParams(remote frame.TransportParams) {
+	p.mu.Lock()
+	params := negotiateParams(p.localParams(), remote)
+	// The peer may have already raised its limit past its initial transport parameter
+	for _, dir := range []frame.Direction{frame.Bidirectional, frame.Unidirectional} {
+		if limit, raised := maxStreams(&params, dir), *maxStreams(&p.params, dir); *limit > 0 && raised > *limit {
+			*limit = raised
+		}
+	}
+	if p.tracer != nil && (!p.paramsKnown || params.InitialWindow != p.params.InitialWindow) {
+		p.tracer.CongestionWindowUpdated(int(params.InitialWindow))
+	}
+	p.params = params
+	p.cond.Broadcast()
+	if !p.paramsKnown {
+		logging.Info(p.log, "Handshake completed", logging.Any("version", p.version), logging.Any("extensions", p.extensions),
+			logging.Any("idle_timeout", params.IdleTimeout), logging.Any("max_frame_size", params.MaxFrameSize))
+	}
+	p.paramsKnown = true
+	if p.idle == nil && params.IdleTimeout > 0 {
+		p.idle = time.AfterFunc(params.IdleTimeout, p.expire)
+		p.keepalive = time.AfterFunc(params.IdleTimeout/keepAliveDivisor, p.ping)
+	}
+	p.mu.Unlock()
+
+	p.pmu.Lock()
+	size := p.packet.MaxSize()
+	p.pmu.Unlock()
+	if max := int(params.MaxFrameSize); max > 0 && max != size {
+		_ = p.SetMaxPacketSize(max)
+	}
+}
+
+func (p *Peer) raiseStreams(dir frame.Direction, limit uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if max := maxStreams(&p.params, dir); limit > *max {
+		*max = limit
+		p.cond.Broadcast()
+	}
+}
+
+// Close the peer once it has gone idle for longer than the negotiated idle timeout.
+// A live peer with open streams never goes idle, since it answers our keepalive pings.
+func (p *Peer) expire() {
+	p.mu.RLock()
+	timeout := p.params.IdleTimeout
+	p.mu.RUnlock()
+	logging.Info(p.log, "Peer timed out", logging.Any("idle_timeout", timeout))
+	_ = p.Close()
+}
+
+// Ping the quiet peer while it has open streams, such as during a paused transfer,
+// so the peer's answer keeps both sides from timing out.
+func (p *Peer) ping() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	open := len(p.streams) > 0
+	p.keepalive.Reset(p.params.IdleTimeout / keepAliveDivisor)
+	p.mu.Unlock()
+	if open {
+		_ = p.Send(frame.Ping{})
+	}
+}
+
+func (p *Peer) setVersion(version uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.version = version
+}
+
+// Keep the extensions offered or picked by the peer, as long as we understand them too.
+func (p *Peer) setExtensions(extensions []frame.FrameType) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.extensions = p.extensions[:0]
+	if p.interop == nil {
+		return
+	}
+	cfg := p.interop.Config()
+	for _, ext := range extensions {
+		if cfg.understands(ext) {
+			p.extensions = append(p.extensions, ext)
+		}
+	}
 }
 
-func (p *Peer) close(remove bool) error {
+// Switch to our most preferred version among the versions offered by the peer,
+// reporting whether there's any version in common.
+func (p *Peer) negotiate(vn *frame.VersionNegotiation) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.interop == nil {
+		return false
+	}
+	for _, v := range p.interop.Config().SupportedVersions {
+		if vn.Supports(v) {
+			p.version = v
+			return true
+		}
+	}
+	return false
+}
+
+// Close the peer over the error, which the streams waiting on the peer get told about, see Err.
+func (p *Peer) fail(err error) {
+	p.mu.Lock()
+	if !p.closed {
+		p.err = err
+	}
+	p.mu.Unlock()
+	_ = p.Close()
+}
+
+// Err tells why the peer got closed when it's down to the peer, such as ErrVersionMismatch.
+// It's nil while the peer is open or once either side has closed it.
+func (p *Peer) Err() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.err
+}
+
+// Close the peer, telling the peer with the FIN frame of stream ID zero unless it's the one ending the connection.
+func (p *Peer) close(remove, fin bool) error {
+	p.mu.Lock()
 	if p.closed {
+		p.mu.Unlock()
 		return ErrPeerAlreadyClosed
 	}
-	p.ob.Dispose()
+	p.closed = true
+	logging.Info(p.log, "Peer closed")
+	close(p.done)
+	p.cond.Broadcast()
+	if p.idle != nil {
+		p.idle.Stop()
+		p.keepalive.Stop()
+	}
+	streams := make([]*Stream, 0, len(p.streams))
 	for _, s := range p.streams {
-		if err := s.close(false); err != nil {
-			return err
-		}
+		streams = append(streams, s)
 	}
-	if err := p.Send(frame.Fin{}); err != nil {
-		return err
+	p.mu.Unlock()
+
+	// Closing the streams takes their locks, so it's done after letting go of ours
+	for _, s := range streams {
+		_ = s.close(false)
 	}
+
+	// Sending checks the frame against the negotiated limits, which takes the lock.
+	// The FIN goes out even though the peer is closed already, which fails any other send.
+	var err error
+	if fin {
+		err = p.send(frame.Fin{})
+	}
+	if p.tracer != nil {
+		p.tracer.Close()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if remove {
 		p.interop.remove(p.raddr.String())
 	}
-	// Remove references to avoid memory leaks
-	p.interop = nil
-	p.raddr = nil
+	// Remove references to avoid memory leaks. The interop stays, since a send racing with closing may still
+	// be writing through it, and so does the remote address, since it never changes.
 	p.streams = nil
-	p.ob = nil
-	p.closed = true
-	return nil
+	return err
 }
@@ -1,24 +1,52 @@
 package interop
 
 import (
+	"context"
 	"errors"
 	"reliable-udp/protocol/frame"
+	"reliable-udp/util/logging"
+	"reliable-udp/util/ratelimit"
 	"sync"
 )
 
-var ErrStreamAlreadyClosed = errors.New("stream already closed")
+var (
+	ErrStreamAlreadyClosed = errors.New("stream already closed")
+	ErrStreamReceiveOnly   = errors.New("stream is receive-only")
+	ErrStreamSendOnly      = errors.New("stream is send-only")
+)
+
+// The number of received frames a stream holds on to until they're taken out of it.
+// Frames past it get dropped, leaving it to the peer to retransmit them.
+const StreamInboxSize = 256
 
 type Stream struct {
-	peer   *Peer
-	sid    frame.StreamID
+	peer *Peer
+	sid  frame.StreamID
+	// Whether the stream has been initiated by us rather than by the peer.
+	local bool
+	inbox chan *frame.Frame
+	done  chan struct{}
+	// Shapes the chunks sent over the stream.
+	limit *ratelimit.Bucket
+	// The peer's logger, kept since the peer gets cleared once the stream is closed.
+	log    logging.Logger
 	mu     sync.RWMutex
 	closed bool
 }
 
 func NewStream(peer *Peer, sid frame.StreamID) *Stream {
+	var limit ratelimit.Limit
+	if peer.interop != nil {
+		limit = peer.interop.Config().StreamRateLimit
+	}
 	return &Stream{
-		peer: peer,
-		sid:  sid,
+		peer:  peer,
+		sid:   sid,
+		local: peer.initiated(sid),
+		inbox: make(chan *frame.Frame, StreamInboxSize),
+		done:  make(chan struct{}),
+		limit: ratelimit.New(limit),
+		log:   peer.log,
 	}
 }
 
@@ -26,26 +54,101 @@ func (s *Stream) StreamID() frame.StreamID {
 	return s.sid
 }
 
+func (s *Stream) Direction() frame.Direction {
+	return s.sid.Direction()
+}
+
+// Whether only the peer sends data over the stream,
+// which is the case for the unidirectional streams initiated by the peer.
+func (s *Stream) ReceiveOnly() bool {
+	return s.Direction() == frame.Unidirectional && !s.local
+}
+
+// Whether only we send data over the stream,
+// which is the case for the unidirectional streams initiated by us.
+func (s *Stream) SendOnly() bool {
+	return s.Direction() == frame.Unidirectional && s.local
+}
+
+// Transport parameters negotiated with the peer, which are zero once the stream is closed.
+func (s *Stream) TransportParams() frame.TransportParams {
+	p, err := s.getPeer()
+	if err != nil {
+		return frame.TransportParams{}
+	}
+	return p.TransportParams()
+}
+
+// Receive the next frame the peer has sent over the stream, waiting until one arrives,
+// the stream gets closed or the context is done.
+func (s *Stream) Receive(ctx context.Context) (*frame.Frame, error) {
+	select {
+	case f := <-s.inbox:
+		return f, nil
+	case <-s.done:
+		return nil, ErrStreamAlreadyClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Done is closed once the stream gets closed.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
 func (s *Stream) Send(data frame.Data) error {
-	return s.peer.Send(data)
+	p, err := s.getPeer()
+	if err != nil {
+		return err
+	}
+	return p.Send(data)
 }
 
 func (s *Stream) Handshake(length uint16, hash []byte) error {
-	return s.Send(frame.Handshake{
-		StreamID: s.sid,
-		Length:   length,
-		Hash:     hash,
+	if s.ReceiveOnly() {
+		return ErrStreamReceiveOnly
+	}
+	p, err := s.getPeer()
+	if err != nil {
+		return err
+	}
+	return p.Send(frame.Handshake{
+		StreamID:   s.sid,
+		Version:    p.Version(),
+		Length:     length,
+		Hash:       hash,
+		Extensions: p.interop.Config().Extensions,
+		Params:     p.interop.Config().TransportParams,
 	})
 }
 
 func (s *Stream) AckHandshake(size uint16) error {
-	return s.Send(frame.HandshakeAck{
-		StreamID: s.sid,
-		Size:     size,
+	if s.SendOnly() {
+		return ErrStreamSendOnly
+	}
+	p, err := s.getPeer()
+	if err != nil {
+		return err
+	}
+	return p.Send(frame.HandshakeAck{
+		StreamID:   s.sid,
+		Size:       size,
+		Extensions: p.Extensions(),
+		Params:     p.interop.Config().TransportParams,
 	})
 }
 
+// Send the chunk, once the stream's rate limit lets it through.
 func (s *Stream) Stream(seq uint16, off uint16, chunk []byte) error {
+	if s.ReceiveOnly() {
+		return ErrStreamReceiveOnly
+	}
+	if s.limit.Limited() {
+		if err := s.limit.Wait(len(chunk), s.done); err != nil {
+			return ErrStreamAlreadyClosed
+		}
+	}
 	return s.Send(frame.Stream{
 		StreamID: s.sid,
 		Sequence: seq,
@@ -55,12 +158,25 @@ func (s *Stream) Stream(seq uint16, off uint16, chunk []byte) error {
 }
 
 func (s *Stream) AckStream(seq uint16) error {
+	if s.SendOnly() {
+		return ErrStreamSendOnly
+	}
 	return s.Send(frame.StreamAck{
 		StreamID: s.sid,
 		Sequence: seq,
 	})
 }
 
+// Limit the rate of the data sent over the stream, counting the chunk bytes including the retransmitted ones.
+// A zero rate lifts the limit.
+func (s *Stream) SetRateLimit(limit ratelimit.Limit) {
+	s.limit.SetLimit(limit)
+}
+
+func (s *Stream) RateLimit() ratelimit.Limit {
+	return s.limit.Limit()
+}
+
 func (s *Stream) Close() error {
 	if err := s.Send(frame.Fin{StreamID: s.sid}); err != nil {
 		return err
@@ -74,16 +190,44 @@ func (s *Stream) Closed() bool {
 	return s.closed
 }
 
+func (s *Stream) getPeer() (*Peer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrStreamAlreadyClosed
+	}
+	return s.peer, nil
+}
+
+// Hand the received frame over to the stream, dropping it if the inbox is full.
+func (s *Stream) push(f *frame.Frame) {
+	select {
+	case s.inbox <- f:
+	default:
+		if s.log.Enabled(logging.LevelDebug) {
+			logging.Debug(s.log, "Dropped received frame, stream queue full", logging.Stream(s.sid.Uint16()), logging.Frame(f.Type()))
+		}
+	}
+}
+
 func (s *Stream) close(remove bool) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	if s.closed {
+		s.mu.Unlock()
 		return ErrStreamAlreadyClosed
 	}
-	if remove {
-		s.peer.remove(s.sid)
-	}
+	p := s.peer
+	close(s.done)
 	s.peer = nil
 	s.closed = true
+	s.mu.Unlock()
+
+	// Removing takes the peer's lock, so it's done after letting go of ours
+	if remove {
+		p.remove(s.sid)
+	}
+	if p.tracer != nil {
+		p.tracer.StreamStateUpdated(s.sid, StreamClosed)
+	}
 	return nil
 }
@@ -0,0 +1,311 @@
+package interop
+
+import (
+	"context"
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire/interop/handler"
+	"reliable-udp/util/simnet"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamParity(t *testing.T) {
+	require := require.New(t)
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	iop := &Interop{UDPConn: discardConn{}}
+
+	// Test clients open odd streams while servers open even streams, each direction on its own
+	{
+		client := newPeer(iop, raddr, true)
+		server := newPeer(iop, raddr, false)
+		for _, expected := range []frame.StreamID{1, 5, 9} {
+			s, err := client.OpenStream()
+			require.Nil(err)
+			require.Equal(expected, s.StreamID())
+		}
+		for _, expected := range []frame.StreamID{3, 7} {
+			s, err := client.OpenUniStream()
+			require.Nil(err)
+			require.Equal(expected, s.StreamID())
+		}
+		for _, expected := range []frame.StreamID{4, 8, 12} {
+			s, err := server.OpenStream()
+			require.Nil(err)
+			require.Equal(expected, s.StreamID())
+		}
+		for _, expected := range []frame.StreamID{2, 6} {
+			s, err := server.OpenUniStream()
+			require.Nil(err)
+			require.Equal(expected, s.StreamID())
+		}
+	}
+
+	// Test the IDs of closed streams get reused once out of quarantine, so opening never runs out of them
+	{
+		client := newPeer(iop, raddr, true)
+		for i := 0; i < streamIDCount; i++ {
+			s, err := client.OpenUniStream()
+			require.Nil(err)
+			require.Nil(s.Close())
+		}
+		s, err := client.OpenUniStream()
+		require.Equal(ErrStreamsExhausted, err)
+		require.Nil(s)
+
+		client.quarantine = 0
+		for i := 0; i < streamIDCount+10; i++ {
+			s, err := client.OpenUniStream()
+			require.Nil(err)
+			require.Nil(s.Close())
+		}
+		for i := 0; i < streamIDCount+10; i++ {
+			s, err := client.OpenStream()
+			require.Nil(err)
+			require.Nil(s.Close())
+		}
+		s, err = client.OpenStream()
+		require.Nil(err)
+		require.NotEqual(frame.StreamID(0), s.StreamID())
+	}
+
+	// Test the open streams keep their IDs out of use
+	{
+		server := newPeer(iop, raddr, false)
+		server.quarantine = 0
+		first, err := server.OpenStream()
+		require.Nil(err)
+		require.Equal(frame.StreamID(4), first.StreamID())
+		for i := 0; i < streamIDCount; i++ {
+			s, err := server.OpenStream()
+			if err != nil {
+				require.Equal(ErrStreamsExhausted, err)
+				break
+			}
+			require.NotEqual(first.StreamID(), s.StreamID())
+			require.NotEqual(frame.StreamID(0), s.StreamID())
+			require.Nil(s.Close())
+		}
+	}
+
+	// Test handshakes of new streams are only admitted with the peer's parity
+	{
+		server := newPeer(iop, raddr, false)
+		handshake := func(sid frame.StreamID) *frame.Frame {
+			return &frame.Frame{Data: &frame.Handshake{StreamID: sid}}
+		}
+		require.True(server.admit(handshake(1)))
+		require.True(server.admit(handshake(3)))
+		require.False(server.admit(handshake(0)))
+		require.False(server.admit(handshake(2)))
+		require.False(server.admit(handshake(4)))
+	}
+}
+
+func TestStreamsBlocked(t *testing.T) {
+	require := require.New(t)
+	network := simnet.New(1, simnet.Config{Latency: time.Millisecond})
+	aconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer aconn.Close()
+	bconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer bconn.Close()
+
+	a := New(aconn, nil)
+	b := New(bconn, &Config{TransportParams: frame.TransportParams{MaxStreams: 1}})
+	apeer := a.Peer(bconn.LocalAddr().(*net.UDPAddr))
+	bpeer := b.peer(aconn.LocalAddr().(*net.UDPAddr), false)
+
+	// Learn the peer's limit out of the handshake ACK
+	s1, err := apeer.OpenStream()
+	require.Nil(err)
+	bpeer.dispatch(handler.Event{Frame: &frame.Frame{Data: &frame.Handshake{StreamID: s1.StreamID()}}})
+	require.Nil(bpeer.Stream(s1.StreamID()).AckHandshake(frame.FrameMaxSize))
+	require.Eventually(func() bool {
+		network.Flush()
+		return apeer.TransportParams().MaxStreams == 1
+	}, time.Second, 10*time.Millisecond)
+
+	open := func() <-chan *Stream {
+		ch := make(chan *Stream, 1)
+		go func() {
+			s, err := apeer.OpenStream()
+			if err == nil {
+				ch <- s
+			}
+			close(ch)
+		}()
+		return ch
+	}
+	wait := func(ch <-chan *Stream) *Stream {
+		var s *Stream
+		require.Eventually(func() bool {
+			network.Flush()
+			select {
+			case s = <-ch:
+				return true
+			default:
+				return false
+			}
+		}, time.Second, 10*time.Millisecond)
+		return s
+	}
+
+	// Test opening past the limit blocks and tells the peer, until the peer raises the limit
+	{
+		ch := open()
+		var blocked frame.StreamsBlocked
+		require.Eventually(func() bool {
+			network.Flush()
+			select {
+			case blocked = <-bpeer.StreamsBlocked():
+				return true
+			default:
+				return false
+			}
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(frame.Bidirectional, blocked.Direction)
+		require.Equal(uint16(1), blocked.Limit)
+		require.Empty(ch)
+
+		require.Nil(bpeer.SetMaxStreams(2))
+		s := wait(ch)
+		require.NotNil(s)
+		require.Equal(frame.StreamID(5), s.StreamID())
+	}
+
+	// Test unidirectional streams count against their own limit
+	{
+		s, err := apeer.OpenUniStream()
+		require.Nil(err)
+		require.Equal(frame.StreamID(3), s.StreamID())
+	}
+
+	// Test closing a stream lets the blocked opener through
+	{
+		ch := open()
+		time.Sleep(20 * time.Millisecond)
+		require.Empty(ch)
+		require.Nil(s1.Close())
+		s := wait(ch)
+		require.NotNil(s)
+		require.Equal(frame.StreamID(9), s.StreamID())
+	}
+
+	// Test the blocked opener gives up once the context is done
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := apeer.OpenStreamContext(ctx)
+		require.Equal(context.DeadlineExceeded, err)
+	}
+
+	// Test closing the peer interrupts the blocked opener
+	{
+		ch := open()
+		time.Sleep(20 * time.Millisecond)
+		require.Nil(apeer.Close())
+		require.Nil(wait(ch))
+	}
+
+	// Test sending over the closed peer fails rather than writing through it
+	{
+		require.Equal(ErrPeerAlreadyClosed, apeer.SetMaxStreams(1000))
+		require.Equal(ErrPeerAlreadyClosed, apeer.Send(frame.Fin{}))
+		require.Equal(ErrPeerAlreadyClosed, apeer.Queue(frame.Fin{}))
+		require.Equal(ErrPeerAlreadyClosed, apeer.Flush())
+	}
+}
+
+func TestUniStream(t *testing.T) {
+	require := require.New(t)
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	iop := &Interop{UDPConn: discardConn{}}
+	client := newPeer(iop, raddr, true)
+	server := newPeer(iop, raddr, false)
+
+	// Test only the initiating side sends data over unidirectional streams
+	s, err := client.OpenUniStream()
+	require.Nil(err)
+	require.True(s.SendOnly())
+	require.Nil(s.Handshake(0, make([]byte, 16)))
+	require.Nil(s.Stream(0, 0, []byte("Hello")))
+	require.Equal(ErrStreamSendOnly, s.AckHandshake(frame.FrameMaxSize))
+	require.Equal(ErrStreamSendOnly, s.AckStream(0))
+
+	r := server.Stream(s.StreamID())
+	require.True(r.ReceiveOnly())
+	require.Nil(r.AckHandshake(frame.FrameMaxSize))
+	require.Nil(r.AckStream(0))
+	require.Equal(ErrStreamReceiveOnly, r.Handshake(0, make([]byte, 16)))
+	require.Equal(ErrStreamReceiveOnly, r.Stream(0, 0, []byte("Hello")))
+
+	// Test accepting streams by their direction
+	{
+		server := newPeer(iop, raddr, false)
+		ch := make(chan *Stream, 1)
+		go func() {
+			s, err := server.AcceptUniStream()
+			if err == nil {
+				ch <- s
+			}
+		}()
+		var accepted *Stream
+		require.Eventually(func() bool {
+			for _, sid := range []frame.StreamID{5, 7} {
+				server.dispatch(handler.Event{Frame: &frame.Frame{Data: &frame.Handshake{StreamID: sid}}})
+			}
+			select {
+			case accepted = <-ch:
+				return true
+			default:
+				return false
+			}
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(frame.StreamID(7), accepted.StreamID())
+		require.True(accepted.ReceiveOnly())
+	}
+
+	// Test bidirectional streams are symmetric
+	b, err := client.OpenStream()
+	require.Nil(err)
+	require.False(b.SendOnly())
+	require.False(server.Stream(b.StreamID()).ReceiveOnly())
+}
+
+func TestCloseConcurrently(t *testing.T) {
+	require := require.New(t)
+	// Closing has to run in parallel for the locks taken in the opposite orders to get stuck
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	// Test closing the peer while its streams close by themselves, as the peer's FIN or the idle timeout do
+	for i := 0; i < 50; i++ {
+		p := newPeer(&Interop{UDPConn: discardConn{}}, raddr, true)
+		start := make(chan struct{})
+		for k := 0; k < 200; k++ {
+			s, err := p.OpenStream()
+			require.Nil(err)
+			go func() {
+				<-start
+				s.close(true)
+			}()
+		}
+		closed := make(chan struct{})
+		go func() {
+			<-start
+			p.Close()
+			close(closed)
+		}()
+		close(start)
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			require.Fail("closing the peer got stuck")
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/util/pcapng"
+	"time"
+)
+
+// Tap records every datagram sent and received over the connection into the capture,
+// which must be of pcapng.LinkTypeRaw, keeping the batch fast path if the connection has it.
+// Recording is best effort, failing to write the capture never fails sending or receiving.
+func Tap(conn UDPConn, w *pcapng.Writer) UDPConn {
+	t := &tap{UDPConn: conn, w: w, laddr: &net.UDPAddr{IP: net.IPv4zero}}
+	if c, ok := conn.(interface{ LocalAddr() net.Addr }); ok {
+		if laddr, ok := c.LocalAddr().(*net.UDPAddr); ok {
+			t.laddr = laddr
+		}
+	}
+	if bc, ok := conn.(BatchConn); ok {
+		return &batchTap{tap: t, bc: bc}
+	}
+	return t
+}
+
+type tap struct {
+	UDPConn
+	w     *pcapng.Writer
+	laddr *net.UDPAddr
+}
+
+func (t *tap) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	n, err := t.UDPConn.WriteToUDP(b, addr)
+	if err == nil {
+		t.record(t.laddr, addr, b[:n], pcapng.Outbound)
+	}
+	return n, err
+}
+
+func (t *tap) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	n, addr, err := t.UDPConn.ReadFromUDP(b)
+	if err == nil {
+		t.record(addr, t.laddr, b[:n], pcapng.Inbound)
+	}
+	return n, addr, err
+}
+
+func (t *tap) record(src, dst *net.UDPAddr, b []byte, dir pcapng.Direction) {
+	_ = t.w.WritePacket(time.Now(), pcapng.EncodeUDP(src, dst, b), dir)
+}
+
+type batchTap struct {
+	*tap
+	bc BatchConn
+}
+
+var _ BatchConn = (*batchTap)(nil)
+
+func (t *batchTap) ReadBatch(ms []Message) (int, error) {
+	n, err := t.bc.ReadBatch(ms)
+	for _, m := range ms[:n] {
+		t.record(m.Addr, t.laddr, m.Buffer[:m.N], pcapng.Inbound)
+	}
+	return n, err
+}
+
+func (t *batchTap) WriteBatch(ms []Message) (int, error) {
+	n, err := t.bc.WriteBatch(ms)
+	for _, m := range ms[:n] {
+		t.record(t.laddr, m.Addr, m.Buffer, pcapng.Outbound)
+	}
+	return n, err
+}
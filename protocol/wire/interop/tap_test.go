@@ -0,0 +1,61 @@
+package interop
+
+import (
+	"bytes"
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/util/pcapng"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTap(t *testing.T) {
+	require := require.New(t)
+	sender, receiver := loopbackPair(t)
+	var sbuf, rbuf bytes.Buffer
+	sw, err := pcapng.NewWriter(&sbuf, pcapng.LinkTypeRaw)
+	require.Nil(err)
+	rw, err := pcapng.NewWriter(&rbuf, pcapng.LinkTypeRaw)
+	require.Nil(err)
+	saddr, raddr := sender.LocalAddr().(*net.UDPAddr), receiver.LocalAddr().(*net.UDPAddr)
+
+	// Test the batch fast path is kept, while both the batches and the single datagrams get captured
+	sc := Tap(NewBatchConn(sender), sw).(BatchConn)
+	rc := Tap(receiver, rw)
+	_, ok := rc.(BatchConn)
+	require.False(ok)
+	_, err = sc.WriteBatch([]Message{{Buffer: []byte("Hello"), Addr: raddr}, {Buffer: []byte("world"), Addr: raddr}})
+	require.Nil(err)
+	_, err = sc.WriteToUDP([]byte("!"), raddr)
+	require.Nil(err)
+	receiver.SetReadDeadline(time.Now().Add(time.Second))
+	buf := frame.Buffer()
+	for i := 0; i < 3; i++ {
+		_, _, err := rc.ReadFromUDP(buf)
+		require.Nil(err)
+	}
+
+	for _, c := range []struct {
+		buf      *bytes.Buffer
+		dir      pcapng.Direction
+		src, dst *net.UDPAddr
+	}{
+		{&sbuf, pcapng.Outbound, saddr, raddr},
+		{&rbuf, pcapng.Inbound, saddr, raddr},
+	} {
+		r, err := pcapng.NewReader(c.buf)
+		require.Nil(err)
+		for _, expected := range []string{"Hello", "world", "!"} {
+			p, err := r.Next()
+			require.Nil(err)
+			require.Equal(c.dir, p.Direction)
+			src, dst, payload, err := pcapng.DecodeUDP(p.LinkType, p.Data)
+			require.Nil(err)
+			require.Equal(c.src.String(), src.String())
+			require.Equal(c.dst.String(), dst.String())
+			require.Equal(expected, string(payload))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"time"
+)
+
+// Tracer gets told about the events of a single peer's connection, such as the qlog tracer of the qlog package.
+// The events come from any goroutine, including after the tracer has been closed.
+type Tracer interface {
+	// A datagram carrying the frames has been sent to the peer, or received from the peer once it's known.
+	PacketSent(size int, frames []frame.Data)
+	PacketReceived(size int, frames []frame.Data)
+	// The peer's loop has taken the frame in, including the stream frames rebuilt by forward error correction.
+	FrameParsed(data frame.Data)
+	// The frame has gone unacknowledged for longer than the retransmission timeout, so it's being retransmitted.
+	LossDetected(data frame.Data)
+	RTTUpdated(latest, smoothed, variance time.Duration)
+	// The protocol has no congestion controller, the data in flight over a stream is bound by
+	// the initial window of the peer instead, which gets reported once the peer has told us about it.
+	CongestionWindowUpdated(window int)
+	StreamStateUpdated(sid frame.StreamID, state StreamState)
+	// The peer has been closed.
+	Close()
+}
+
+// TracerFunc creates the tracer of the peer at the address, telling whether we're the client of the peer.
+type TracerFunc func(raddr *net.UDPAddr, client bool) Tracer
+
+type StreamState uint8
+
+const (
+	StreamOpen StreamState = iota
+	// We're done writing while we keep reading, see frame.Shutdown.
+	StreamHalfClosedLocal
+	// The peer is done writing while we keep writing.
+	StreamHalfClosedRemote
+	StreamClosed
+)
+
+// The names of the qlog stream states.
+var streamStateNames = map[StreamState]string{
+	StreamOpen:             "open",
+	StreamHalfClosedLocal:  "half_closed_local",
+	StreamHalfClosedRemote: "half_closed_remote",
+	StreamClosed:           "closed",
+}
+
+func (s StreamState) String() string {
+	if name, ok := streamStateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// The data of the frames for the tracer.
+func frameData(frames []*frame.Frame) []frame.Data {
+	data := make([]frame.Data, len(frames))
+	for i, f := range frames {
+		data[i] = f.Data
+	}
+	return data
+}
@@ -0,0 +1,82 @@
+package interop
+
+import (
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/util/simnet"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionNegotiation(t *testing.T) {
+	require := require.New(t)
+	network := simnet.New(1, simnet.Config{Latency: time.Millisecond})
+	aconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer aconn.Close()
+	bconn, err := network.Listen(nil)
+	require.Nil(err)
+	defer bconn.Close()
+
+	// The upgraded side prefers the next version, the other side only speaks the current one
+	a := New(aconn, &Config{SupportedVersions: []uint32{frame.ProtocolVersion + 1, frame.ProtocolVersion}})
+	b := New(bconn, nil)
+	apeer := a.Peer(bconn.LocalAddr().(*net.UDPAddr))
+	require.Equal(frame.ProtocolVersion+1, apeer.Version())
+
+	accepted := make(chan *Peer, 1)
+	go func() {
+		p, err := b.AcceptPeer()
+		if err == nil {
+			accepted <- p
+		}
+	}()
+
+	// The handshake in the unsupported version gets replied with the supported versions
+	s, err := apeer.OpenStream()
+	require.Nil(err)
+	require.Nil(s.Handshake(5, make([]byte, 16)))
+	require.Eventually(func() bool {
+		// The reply gets sent once the other side has read the handshake
+		network.Flush()
+		return apeer.Version() == frame.ProtocolVersion
+	}, time.Second, 10*time.Millisecond)
+	require.Empty(accepted)
+
+	// The retried handshake is in the version both sides speak
+	require.Nil(s.Handshake(5, make([]byte, 16)))
+	network.Flush()
+	select {
+	case p := <-accepted:
+		require.Equal(frame.ProtocolVersion, p.Version())
+	case <-time.After(time.Second):
+		require.Fail("handshake not accepted")
+	}
+
+	// Without any version in common the peer gets told so
+	c := &Interop{config: &Config{SupportedVersions: []uint32{frame.ProtocolVersion + 1}}}
+	cpeer := NewPeer(c, bconn.LocalAddr().(*net.UDPAddr))
+	require.False(cpeer.negotiate(&frame.VersionNegotiation{Versions: []uint32{frame.ProtocolVersion}}))
+	require.Equal(frame.ProtocolVersion+1, cpeer.Version())
+
+	// Test the peer gets closed over the versions not in common, failing the streams waiting on it
+	{
+		cconn, err := network.Listen(nil)
+		require.Nil(err)
+		defer cconn.Close()
+		c := New(cconn, &Config{SupportedVersions: []uint32{frame.ProtocolVersion + 1}})
+		cpeer := c.Peer(bconn.LocalAddr().(*net.UDPAddr))
+		s, err := cpeer.OpenStream()
+		require.Nil(err)
+		require.Nil(s.Handshake(5, make([]byte, 16)))
+		require.Eventually(func() bool {
+			network.Flush()
+			return cpeer.Closed()
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(ErrVersionMismatch, cpeer.Err())
+		_, err = cpeer.OpenStream()
+		require.Equal(ErrVersionMismatch, err)
+	}
+}
@@ -1,32 +1,50 @@
 package wire
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net"
 	"reliable-udp/protocol/wire/interop"
 	"sync"
+	"time"
 )
 
 var (
-	ErrListenerNotOpen     = errors.New("listener not open")
-	ErrListenerAlreadyOpen = errors.New("listener already open")
+	ErrListenerNotOpen      = errors.New("listener not open")
+	ErrListenerAlreadyOpen  = errors.New("listener already open")
+	ErrListenerShuttingDown = errors.New("listener shutting down")
 )
 
+// How often shutting down checks on the peers being done with their streams.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Conn is the connection the listener sends and receives the datagrams over,
+// which is a *net.UDPConn unless the listener serves over another one, like a simulated network's.
+type Conn interface {
+	interop.UDPConn
+	LocalAddr() net.Addr
+	Close() error
+}
+
 type Listener struct {
-	conn    *net.UDPConn
+	config  *Config
+	conn    Conn
 	interop *interop.Interop
 	mu      sync.Mutex
 	peers   map[string]*Peer
 	open    bool
+	// Whether the listener is shutting down, see Shutdown.
+	closing bool
 }
 
-func NewListener() *Listener {
-	return &Listener{}
+// Create the listener with the config. A nil config uses the defaults.
+func NewListener(config *Config) *Listener {
+	return &Listener{config: config}
 }
 
-func Listen(addr string) (*Listener, error) {
-	l := NewListener()
+func Listen(addr string, config *Config) (*Listener, error) {
+	l := NewListener(config)
 	laddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
@@ -47,39 +65,148 @@ func (l *Listener) Open(laddr *net.UDPAddr) error {
 	if err != nil {
 		return err
 	}
+	l.serve(conn, interop.NewBatchConn(conn))
+	return nil
+}
+
+// Open the listener over the connection, which the listener closes once it's closed.
+func (l *Listener) Serve(conn Conn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open {
+		return ErrListenerAlreadyOpen
+	}
+	l.serve(conn, conn)
+	return nil
+}
+
+func (l *Listener) serve(conn Conn, uc interop.UDPConn) {
+	if w := l.config.capture(); w != nil {
+		uc = interop.Tap(uc, w)
+	}
 	l.conn = conn
-	l.interop = interop.New(conn)
+	l.interop = interop.New(uc, l.config.interop())
 	l.peers = make(map[string]*Peer)
 	l.open = true
-	return nil
+	l.closing = false
 }
 
+// Close every peer right away, failing their streams, then the connection.
+// Every peer gets closed whatever happens to the others, so the errors get joined together.
 func (l *Listener) Close() error {
 	l.mu.Lock()
-	defer l.mu.Unlock()
 	if !l.open {
+		l.mu.Unlock()
 		return ErrListenerNotOpen
 	}
-	for _, peer := range l.peers {
-		if err := peer.close(false); err != nil {
-			return err
-		}
-	}
-	if err := l.conn.Close(); err != nil {
-		return err
-	}
+	peers, conn := l.peers, l.conn
 	l.conn = nil
 	l.interop = nil
 	l.peers = nil
 	l.open = false
-	return nil
+	l.mu.Unlock()
+
+	// Closing the peers takes their locks, so it's done after letting go of ours
+	var errs []error
+	for _, peer := range peers {
+		if err := peer.close(false); err != nil && err != ErrPeerAlreadyClosed {
+			errs = append(errs, err)
+		}
+	}
+	if err := conn.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown stops accepting new peers, refusing their handshakes, then closes every peer as soon as it's done
+// with its streams, which tells the peer with the FIN frame of the whole connection. Once the context is done,
+// the peers still busy get closed right away, failing their streams. Then the listener gets closed.
+// All the errors get joined together, including the context's error if any peer had to be closed early.
+func (l *Listener) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.open {
+		l.mu.Unlock()
+		return ErrListenerNotOpen
+	}
+	if l.closing {
+		l.mu.Unlock()
+		return ErrListenerShuttingDown
+	}
+	l.closing = true
+	l.interop.StopAccepting()
+	l.mu.Unlock()
+
+	errs := l.drain(ctx)
+	if err := l.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Close the peers as they get done with their streams, until there are none left or the context is done.
+func (l *Listener) drain(ctx context.Context) []error {
+	var errs []error
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for l.closeIdle(&errs) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return append(errs, ctx.Err())
+		}
+	}
+	return errs
+}
+
+// Close the peers done with their streams, collecting the errors. Returns the number of peers still busy.
+func (l *Listener) closeIdle(errs *[]error) int {
+	l.mu.Lock()
+	peers := make([]*Peer, 0, len(l.peers))
+	for _, p := range l.peers {
+		peers = append(peers, p)
+	}
+	l.mu.Unlock()
+	busy := 0
+	for _, p := range peers {
+		if p.busy() {
+			busy++
+			continue
+		}
+		// Closing removes the peer from the listener, which takes the lock
+		if err := p.close(true); err != nil && err != ErrPeerAlreadyClosed {
+			*errs = append(*errs, err)
+		}
+	}
+	return busy
 }
 
 func (l *Listener) Accept() (*Peer, error) {
-	a := l.interop.Accept()
-	if a == nil {
+	return l.AcceptContext(context.Background())
+}
+
+// Accept the next peer connecting to the listener, giving up with the context's error once it's done.
+// Accepting returns io.EOF once the listener is closed.
+func (l *Listener) AcceptContext(ctx context.Context) (*Peer, error) {
+	l.mu.Lock()
+	iop, closing := l.interop, l.closing
+	l.mu.Unlock()
+	if iop == nil {
+		return nil, ErrListenerNotOpen
+	}
+	if closing {
 		return nil, io.EOF
 	}
+	a, err := iop.AcceptPeerContext(ctx)
+	if err != nil {
+		// Accept gets interrupted once the listener is closed
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !l.open || l.closing {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
 	addr := a.RemoteAddr().String()
 	return l.Peer(addr)
 }
@@ -91,7 +218,14 @@ func (l *Listener) Peer(addr string) (*Peer, error) {
 		return nil, ErrListenerNotOpen
 	}
 	p, ok := l.peers[addr]
+	// The peer may have closed the connection or gone idle, so a new one takes its place
+	if ok && p.interop.Closed() {
+		ok = false
+	}
 	if !ok {
+		if l.closing {
+			return nil, ErrListenerShuttingDown
+		}
 		raddr, err := net.ResolveUDPAddr("udp", addr)
 		if err != nil {
 			return nil, err
@@ -112,8 +246,11 @@ func (l *Listener) LocalAddr() *net.UDPAddr {
 	return nil
 }
 
-func (l *Listener) remove(addr string) {
+// Remove the peer, unless another peer has taken its place already.
+func (l *Listener) remove(addr string, p *Peer) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	delete(l.peers, addr)
+	if l.peers[addr] == p {
+		delete(l.peers, addr)
+	}
 }
@@ -1,10 +1,15 @@
 package wire
 
 import (
+	"context"
 	"errors"
+	"net"
 	"reliable-udp/protocol/frame"
 	"reliable-udp/protocol/wire/interop"
+	"reliable-udp/util/logging"
+	"reliable-udp/util/ratelimit"
 	"sync"
+	"time"
 )
 
 var (
@@ -12,19 +17,98 @@ var (
 )
 
 type Peer struct {
+	// Kept first for the 64-bit alignment of the counters.
+	stats counters
+
 	listener *Listener
 	interop  *interop.Peer
 	mu       sync.Mutex
 	streams  map[frame.StreamID]*Stream
-	nextId   frame.StreamID
+	rtt      rtt
+	log      logging.Logger
+	attempts int
 	closed   bool
 }
 
 func NewPeer(l *Listener, p *interop.Peer) *Peer {
-	return &Peer{
+	var config *Config
+	if l != nil {
+		config = l.config
+	}
+	peer := &Peer{
 		listener: l,
 		interop:  p,
 		streams:  make(map[frame.StreamID]*Stream),
+		log:      logging.With(config.logger(), logging.Peer(p.RemoteAddr())),
+		attempts: HandshakeAttempts,
+	}
+	go peer.watch()
+	return peer
+}
+
+// Close the peer once the interop peer gets closed, which the peer's FIN or the idle timeout do
+// without us knowing, so the peer doesn't linger in the listener with its streams and stats.
+func (p *Peer) watch() {
+	<-p.interop.Done()
+	_ = p.close(true)
+}
+
+func (p *Peer) RemoteAddr() *net.UDPAddr {
+	return p.interop.RemoteAddr()
+}
+
+// The protocol version spoken with the peer.
+func (p *Peer) Version() uint32 {
+	return p.interop.Version()
+}
+
+// Extension frame types negotiated with the peer.
+func (p *Peer) Extensions() []frame.FrameType {
+	return p.interop.Extensions()
+}
+
+// Transport parameters negotiated with the peer, which the interop enforces on both directions.
+func (p *Peer) TransportParams() frame.TransportParams {
+	return p.interop.TransportParams()
+}
+
+// The smoothed round-trip time to the peer, which is zero until it has been measured.
+func (p *Peer) RTT() time.Duration {
+	return p.rtt.smoothed()
+}
+
+// The most recent round-trip time samples to the peer, up to RTTSamplesSize of them in no particular order.
+func (p *Peer) RTTSamples() []time.Duration {
+	return p.rtt.recent()
+}
+
+// The time to wait for an ACK before retransmitting, allowing for the peer to delay its ACK frames.
+func (p *Peer) rto() time.Duration {
+	return clampRTO(p.rtt.rto() + p.interop.TransportParams().AckDelay)
+}
+
+// Stats of the data exchanged with the peer so far.
+func (p *Peer) Stats() Stats {
+	s := p.stats.load()
+	s.RTT = p.RTT()
+	return s
+}
+
+// Limit the rate of the datagrams sent to the peer in bytes per second, which may be changed at any time.
+// It holds back the sending on top of the streams' own limits, while the retransmissions keep backing off as usual.
+func (p *Peer) SetRateLimit(limit ratelimit.Limit) {
+	p.interop.SetRateLimit(limit)
+}
+
+func (p *Peer) RateLimit() ratelimit.Limit {
+	return p.interop.RateLimit()
+}
+
+// Take the round-trip time sample, telling the tracer about it.
+func (p *Peer) sampleRTT(sample time.Duration) {
+	srtt, rttvar := p.rtt.update(sample)
+	if t := p.interop.Tracer(); t != nil {
+		t.RTTUpdated(sample, srtt, rttvar)
 	}
 }
 
@@ -32,48 +116,128 @@ func (p *Peer) Close() error {
 	return p.close(true)
 }
 
-func (p *Peer) Stream() *Stream {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.nextId++
-	sid := p.nextId
-	s, ok := p.streams[sid]
-	if !ok {
-		s = NewStream(p, p.interop.Stream(sid))
-		p.streams[sid] = s
+// Open a new stream towards the peer, waiting for the peer to allow more streams
+// if we've got as many streams open as it allows.
+func (p *Peer) OpenStream() (*Stream, error) {
+	return p.OpenStreamContext(context.Background())
+}
+
+// Open a new stream towards the peer, giving up on waiting for the peer to allow more streams
+// once the context is done.
+func (p *Peer) OpenStreamContext(ctx context.Context) (*Stream, error) {
+	return p.add(p.interop.OpenStreamContext(ctx))
+}
+
+// Open a new unidirectional stream towards the peer, over which only we send data.
+func (p *Peer) OpenUniStream() (*SendStream, error) {
+	return p.OpenUniStreamContext(context.Background())
+}
+
+func (p *Peer) OpenUniStreamContext(ctx context.Context) (*SendStream, error) {
+	s, err := p.add(p.interop.OpenUniStreamContext(ctx))
+	if err != nil {
+		return nil, err
 	}
-	return s
+	return &SendStream{s}, nil
+}
+
+// Accept the next bidirectional stream the peer has opened.
+func (p *Peer) AcceptStream() (*Stream, error) {
+	return p.AcceptStreamContext(context.Background())
+}
+
+// Accept the next bidirectional stream the peer has opened, giving up once the context is done.
+func (p *Peer) AcceptStreamContext(ctx context.Context) (*Stream, error) {
+	return p.add(p.interop.AcceptStreamContext(ctx))
+}
+
+// Accept the next unidirectional stream the peer has opened, over which only the peer sends data.
+func (p *Peer) AcceptUniStream() (*ReceiveStream, error) {
+	return p.AcceptUniStreamContext(context.Background())
+}
+
+func (p *Peer) AcceptUniStreamContext(ctx context.Context) (*ReceiveStream, error) {
+	s, err := p.add(p.interop.AcceptUniStreamContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &ReceiveStream{s}, nil
+}
+
+// Raise the number of concurrent bidirectional streams the peer may open towards us.
+func (p *Peer) SetMaxStreams(limit uint16) error {
+	return p.interop.SetMaxStreams(limit)
+}
+
+// Raise the number of concurrent unidirectional streams the peer may open towards us.
+func (p *Peer) SetMaxUniStreams(limit uint16) error {
+	return p.interop.SetMaxUniStreams(limit)
+}
+
+// StreamsBlocked signals whenever the peer would like to open more streams than we allow.
+func (p *Peer) StreamsBlocked() <-chan frame.StreamsBlocked {
+	return p.interop.StreamsBlocked()
 }
 
 func (p *Peer) close(remove bool) error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	if p.closed {
+		p.mu.Unlock()
 		return ErrPeerAlreadyClosed
 	}
-	for _, st := range p.streams {
-		if err := st.Close(); err != nil {
-			return err
+	streams, l := p.streams, p.listener
+	// The interop peer is kept around, since it fails every call by itself once closed
+	p.listener = nil
+	p.streams = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	// Closing the streams and removing the peer take their own locks, so it's done after letting go of ours.
+	// Every stream gets closed whatever happens to the others.
+	var errs []error
+	for _, st := range streams {
+		if err := st.close(false); err != nil {
+			errs = append(errs, err)
 		}
 	}
 	if !p.interop.Closed() {
 		if err := p.interop.Close(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
-	if remove {
-		addr := p.interop.RemoteAddr().String()
-		p.listener.remove(addr)
+	if remove && l != nil {
+		l.remove(p.interop.RemoteAddr().String(), p)
 	}
-	p.listener = nil
-	p.streams = nil
-	p.interop = nil
-	p.closed = true
-	return nil
+	return errors.Join(errs...)
+}
+
+// Whether the peer has any stream open, so closing it would cut the stream short.
+// The streams of a peer which has closed the connection are over already.
+func (p *Peer) busy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.closed && !p.interop.Closed() && len(p.streams) > 0
 }
 
-func (p *Peer) remove(sid frame.StreamID) {
+func (p *Peer) add(is *interop.Stream, err error) (*Stream, error) {
+	if err != nil {
+		return nil, err
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	delete(p.streams, sid)
+	if p.closed {
+		return nil, ErrPeerAlreadyClosed
+	}
+	s := NewStream(p, is)
+	p.streams[s.StreamID()] = s
+	return s, nil
+}
+
+// Remove the stream, unless a new stream has taken its ID already.
+func (p *Peer) remove(s *Stream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.streams[s.StreamID()] == s {
+		delete(p.streams, s.StreamID())
+	}
 }
@@ -0,0 +1,88 @@
+package wire
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Retransmission timeout until there's any round-trip time sample.
+	InitialRTO = 200 * time.Millisecond
+	MinRTO     = 20 * time.Millisecond
+	MaxRTO     = 2 * time.Second
+	// The number of the most recent round-trip time samples kept around.
+	RTTSamplesSize = 4096
+)
+
+// Round-trip time estimation out of the samples taken from the ACK frames, see RFC 6298.
+type rtt struct {
+	mu     sync.Mutex
+	srtt   time.Duration
+	rttvar time.Duration
+	// Ring of the most recent samples, next being where the next sample goes.
+	samples []time.Duration
+	next    int
+}
+
+// Take the sample into the estimation, returning the smoothed round-trip time and its variation.
+func (r *rtt) update(sample time.Duration) (time.Duration, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.samples) < RTTSamplesSize {
+		r.samples = append(r.samples, sample)
+	} else {
+		r.samples[r.next] = sample
+	}
+	r.next = (r.next + 1) % RTTSamplesSize
+	if r.srtt == 0 {
+		r.srtt = sample
+		r.rttvar = sample / 2
+		return r.srtt, r.rttvar
+	}
+	delta := r.srtt - sample
+	if delta < 0 {
+		delta = -delta
+	}
+	r.rttvar = (3*r.rttvar + delta) / 4
+	r.srtt = (7*r.srtt + sample) / 8
+	return r.srtt, r.rttvar
+}
+
+// The smoothed round-trip time, which is zero until there's any sample.
+func (r *rtt) smoothed() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.srtt
+}
+
+// A copy of the most recent samples, in no particular order.
+func (r *rtt) recent() []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]time.Duration(nil), r.samples...)
+}
+
+// The time to wait for an ACK before retransmitting.
+func (r *rtt) rto() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.srtt == 0 {
+		return InitialRTO
+	}
+	return clampRTO(r.srtt + 4*r.rttvar)
+}
+
+// Double the timeout after it has run out without an ACK.
+func backoff(rto time.Duration) time.Duration {
+	return clampRTO(2 * rto)
+}
+
+func clampRTO(rto time.Duration) time.Duration {
+	if rto < MinRTO {
+		return MinRTO
+	}
+	if rto > MaxRTO {
+		return MaxRTO
+	}
+	return rto
+}
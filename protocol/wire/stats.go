@@ -0,0 +1,48 @@
+package wire
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats of the data exchanged with the peer over all the streams.
+type Stats struct {
+	// Bytes of the messages the peer has acknowledged.
+	BytesSent uint64
+	// Bytes of the messages handed over to our readers.
+	BytesReceived uint64
+	// Chunks sent out, including the retransmitted ones.
+	ChunksSent uint64
+	// Chunks and handshakes sent out again, since the peer hasn't acknowledged them in time.
+	Retransmits uint64
+	// The smoothed round-trip time, which is zero until it has been measured.
+	RTT time.Duration
+}
+
+// The share of the chunks sent out which have been retransmissions.
+func (s Stats) RetransmitRate() float64 {
+	if s.ChunksSent <= 0 {
+		return 0
+	}
+	return float64(s.Retransmits) / float64(s.ChunksSent)
+}
+
+type counters struct {
+	bytesSent     uint64
+	bytesReceived uint64
+	chunksSent    uint64
+	retransmits   uint64
+}
+
+func count(n *uint64, delta int) {
+	atomic.AddUint64(n, uint64(delta))
+}
+
+func (c *counters) load() Stats {
+	return Stats{
+		BytesSent:     atomic.LoadUint64(&c.bytesSent),
+		BytesReceived: atomic.LoadUint64(&c.bytesReceived),
+		ChunksSent:    atomic.LoadUint64(&c.chunksSent),
+		Retransmits:   atomic.LoadUint64(&c.retransmits),
+	}
+}
@@ -1,29 +1,96 @@
 package wire
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
 	"errors"
 	"io"
+	"math"
 	"reliable-udp/protocol/frame"
 	"reliable-udp/protocol/wire/interop"
+	"reliable-udp/util/logging"
+	"reliable-udp/util/ratelimit"
 	"sync"
+	"time"
 )
 
-var ErrStreamAlreadyClosed = errors.New("stream already closed")
+var (
+	ErrStreamAlreadyClosed = errors.New("stream already closed")
+	ErrStreamClosedByPeer  = errors.New("stream closed by peer")
+	ErrChecksumMismatch    = errors.New("message checksum mismatch")
+	ErrStreamWriteClosed   = errors.New("stream closed for writing")
+	ErrPeerRefused         = errors.New("refused by the peer")
+	ErrHandshakeTimeout    = errors.New("handshake timed out")
+)
+
+// Tells the message doesn't fit in the window the peer has told us about with its handshake ACK.
+var errWindowShrunk = errors.New("window shrunk")
+
+const (
+	// How many ACK frames queue up for the writer, past which they get dropped.
+	ackQueueSize = 64
+	// How many times the FIN frame gets sent out when closing the stream
+	// until the peer echoes it back.
+	finAttempts = 3
+	// How many handshakes in a row go out without hearing anything from the peer until giving up on it.
+	// A peer still heard from is only holding back its ACK until its reader catches up.
+	HandshakeAttempts = 12
+)
 
+// Stream sends written data out in messages of up to the peer's initial window.
+// Each message starts with a handshake telling the peer its length and hash, followed by
+// the chunks which get retransmitted until the peer acknowledges them. The peer only hands
+// the message over to its reader once all the chunks are in and the hash is verified.
 type Stream struct {
 	peer    *Peer
 	interop *interop.Stream
 	mu      sync.Mutex
 	closed  bool
+
+	// Sequence number of the next chunk to send, serialized by the writers' lock.
+	seq  uint16
+	acks chan frame.Data
+	// Whether we're done writing, having shut down our side of the stream.
+	wshut bool
+	// Closed once the peer has acknowledged our shutdown frame.
+	shut     chan struct{}
+	shutOnce sync.Once
+	wmu      sync.Mutex
+
+	// The message being received, if any.
+	msg *message
+	// The handshake held back from being acknowledged while the reader is lagging behind.
+	pending *frame.Handshake
+	// Sequence number of the last chunk of the last delivered message.
+	last      uint16
+	delivered bool
+	rbuf      bytes.Buffer
+	rerr      error
+	readable  chan struct{}
+	// Whether we've started closing the stream, so the peer's FIN frame is an echo of ours.
+	closing bool
+	// Closed once the peer's FIN frame has arrived.
+	fin    chan struct{}
+	finned bool
+	// Whether the peer is done writing, having shut down its side of the stream.
+	rshut bool
+	rmu   sync.Mutex
 }
 
 var _ io.ReadWriteCloser = (*Stream)(nil)
 
 func NewStream(peer *Peer, interop *interop.Stream) *Stream {
-	return &Stream{
-		peer:    peer,
-		interop: interop,
+	s := &Stream{
+		peer:     peer,
+		interop:  interop,
+		acks:     make(chan frame.Data, ackQueueSize),
+		readable: make(chan struct{}, 1),
+		fin:      make(chan struct{}),
+		shut:     make(chan struct{}),
 	}
+	go s.receiveLoop()
+	return s
 }
 
 func (s *Stream) StreamID() frame.StreamID {
@@ -31,30 +98,707 @@ func (s *Stream) StreamID() frame.StreamID {
 }
 
 func (s *Stream) Read(b []byte) (int, error) {
-	return 0, nil
+	return s.ReadContext(context.Background(), b)
+}
+
+// Read the data of the messages delivered so far, waiting for the next message if there's none
+// until the context is done. Reading returns io.EOF once the peer has closed the stream or shut down writing.
+func (s *Stream) ReadContext(ctx context.Context, b []byte) (int, error) {
+	if len(b) <= 0 {
+		return 0, nil
+	}
+	for {
+		s.rmu.Lock()
+		if s.rbuf.Len() > 0 {
+			n, _ := s.rbuf.Read(b)
+			if s.pending != nil && s.fits(s.pending) {
+				s.start(s.pending)
+			}
+			s.rmu.Unlock()
+			return n, nil
+		}
+		err := s.rerr
+		s.rmu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-s.readable:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
 }
 
 func (s *Stream) Write(b []byte) (int, error) {
-	return 0, nil
+	return s.WriteContext(context.Background(), b)
+}
+
+// Write the data, returning once the peer has acknowledged all of it or once the context is done.
+// The returned count only covers the messages the peer has acknowledged as a whole.
+func (s *Stream) WriteContext(ctx context.Context, b []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.wshut {
+		return 0, ErrStreamWriteClosed
+	}
+	n := 0
+	for n < len(b) {
+		end := n + s.window()
+		if end > len(b) {
+			end = len(b)
+		}
+		err := s.writeMessage(ctx, b[n:end])
+		if err == errWindowShrunk {
+			continue
+		}
+		if err != nil {
+			return n, s.closedByPeer(err)
+		}
+		n = end
+	}
+	return n, nil
+}
+
+// Sending fails once the stream has been let go of after the peer's FIN frame, which is down to the peer.
+func (s *Stream) closedByPeer(err error) error {
+	select {
+	case <-s.fin:
+		return ErrStreamClosedByPeer
+	default:
+		return err
+	}
 }
 
+// Limit the rate of the data written to the stream in bytes per second, which may be changed at any time.
+// The peer's own limit applies on top of it.
+func (s *Stream) SetRateLimit(limit ratelimit.Limit) {
+	s.interop.SetRateLimit(limit)
+}
+
+func (s *Stream) RateLimit() ratelimit.Limit {
+	return s.interop.RateLimit()
+}
+
+// Tell the peer we're done with the stream, then close it.
 func (s *Stream) Close() error {
+	s.finish()
 	return s.close(true)
 }
 
+// Tell the peer we're done writing while we keep reading, like shutting down the write side of a TCP connection.
+// The peer reads io.EOF once it has read all the data written so far. Shutting down waits for the writes
+// in progress, then sends out the shutdown frame until the peer acknowledges it or the stream gets closed.
+func (s *Stream) CloseWrite() error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.wshut {
+		return ErrStreamWriteClosed
+	}
+	s.wshut = true
+	rto := s.peer.rto()
+	timer := time.NewTimer(rto)
+	defer timer.Stop()
+	for {
+		if err := s.interop.Send(frame.Shutdown{StreamID: s.StreamID()}); err != nil {
+			return s.closedByPeer(err)
+		}
+		select {
+		case <-s.shut:
+			s.traceState(interop.StreamHalfClosedLocal)
+			return nil
+		case <-s.fin:
+			return ErrStreamClosedByPeer
+		case <-s.interop.Done():
+			return ErrStreamAlreadyClosed
+		case <-timer.C:
+			rto = backoff(rto)
+			timer.Reset(rto)
+		}
+	}
+}
+
+// Send out the FIN frame until the peer echoes it back, giving up after a few attempts.
+// The peer echoing the FIN frame means it has seen the end of the stream.
+func (s *Stream) finish() {
+	s.rmu.Lock()
+	s.closing = true
+	s.rmu.Unlock()
+	rto := s.peer.rto()
+	for i := 0; i < finAttempts; i++ {
+		select {
+		case <-s.fin:
+			return
+		default:
+		}
+		if err := s.interop.Send(frame.Fin{StreamID: s.StreamID()}); err != nil {
+			return
+		}
+		timer := time.NewTimer(rto)
+		select {
+		case <-s.fin:
+		case <-s.interop.Done():
+		case <-timer.C:
+			rto = backoff(rto)
+			continue
+		}
+		timer.Stop()
+		return
+	}
+	logging.Debug(s.peer.log, "Gave up waiting for the FIN echo", logging.Stream(s.StreamID().Uint16()))
+}
+
 func (s *Stream) close(remove bool) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	if s.closed {
+		s.mu.Unlock()
 		return ErrStreamAlreadyClosed
 	}
 	if !s.interop.Closed() {
 		if err := s.interop.Close(); err != nil {
+			s.mu.Unlock()
 			return err
 		}
 	}
+	s.closed = true
+	s.mu.Unlock()
+
+	// Removing takes the peer's lock, so it's done after letting go of ours
 	if remove {
-		s.peer.remove(s.StreamID())
+		s.peer.remove(s)
+	}
+	return nil
+}
+
+// The most data a single message may carry, bound by the initial window of the peer.
+func (s *Stream) window() int {
+	w := int(s.interop.TransportParams().InitialWindow)
+	if w <= 0 || w > math.MaxUint16 {
+		w = math.MaxUint16
+	}
+	return w
+}
+
+// State of a chunk on its way to the peer.
+type outgoing struct {
+	at            time.Time
+	retransmitted bool
+	acked         bool
+}
+
+func (s *Stream) writeMessage(ctx context.Context, data []byte) error {
+	hash := md5.Sum(data)
+	ack, err := s.handshake(ctx, uint16(len(data)), hash[:])
+	if err != nil {
+		return err
+	}
+	// The peer's transport parameters are known by now, so its window may be smaller than we've assumed
+	if len(data) > s.window() {
+		return errWindowShrunk
+	}
+	size := chunkSize(ack.Size)
+	out := make([]outgoing, (len(data)+size-1)/size)
+	first := s.seq
+	// Chunks of an abandoned message must never be mistaken for the ones of the next message
+	s.seq += uint16(len(out))
+	bounds := func(i int) (int, int) {
+		off := i * size
+		end := off + size
+		if end > len(data) {
+			end = len(data)
+		}
+		return off, end
+	}
+	send := func(i int) error {
+		off, end := bounds(i)
+		count(&s.peer.stats.chunksSent, 1)
+		err := s.interop.Stream(first+uint16(i), uint16(off), data[off:end])
+		// Taken once the rate limits have let the chunk through, so waiting on them isn't mistaken for the round trip
+		out[i].at = time.Now()
+		return err
+	}
+	for i := range out {
+		if err := send(i); err != nil {
+			return err
+		}
 	}
+
+	rto := s.peer.rto()
+	timer := time.NewTimer(rto)
+	defer timer.Stop()
+	for remaining := len(out); remaining > 0; {
+		d, err := s.awaitAck(ctx, timer.C)
+		if err != nil {
+			return err
+		}
+		switch v := d.(type) {
+		case *frame.StreamAck:
+			i := int(v.Sequence - first)
+			if i >= len(out) || out[i].acked {
+				continue
+			}
+			out[i].acked = true
+			remaining--
+			// Samples of retransmitted chunks are ambiguous, since the ACK may be of any transmission
+			if !out[i].retransmitted {
+				s.peer.sampleRTT(time.Since(out[i].at))
+			}
+		case nil:
+			// The handshake ACK may have been a late one of an earlier message,
+			// so the peer may not know about this message yet
+			if remaining == len(out) {
+				count(&s.peer.stats.retransmits, 1)
+				s.reportLoss("Retransmitted handshake", &frame.Handshake{StreamID: s.StreamID(), Length: uint16(len(data))}, rto)
+				if err := s.interop.Handshake(uint16(len(data)), hash[:]); err != nil {
+					return err
+				}
+			}
+			resent := false
+			for i := range out {
+				if out[i].acked || time.Since(out[i].at) < rto {
+					continue
+				}
+				out[i].retransmitted = true
+				resent = true
+				count(&s.peer.stats.retransmits, 1)
+				off, end := bounds(i)
+				lost := &frame.Stream{StreamID: s.StreamID(), Sequence: first + uint16(i), Offset: uint16(off), Chunk: data[off:end]}
+				s.reportLoss("Retransmitted chunk", lost, rto, logging.Any("sequence", first+uint16(i)))
+				if err := send(i); err != nil {
+					return err
+				}
+			}
+			if resent {
+				rto = backoff(rto)
+			}
+			timer.Reset(rto)
+		}
+	}
+	count(&s.peer.stats.bytesSent, len(data))
 	return nil
 }
+
+// Offer the message to the peer, retrying until the peer acknowledges it.
+func (s *Stream) handshake(ctx context.Context, length uint16, hash []byte) (*frame.HandshakeAck, error) {
+	rto := s.peer.rto()
+	silent := 0
+	for retries := 0; ; retries++ {
+		if err := s.interop.Handshake(length, hash); err != nil {
+			return nil, s.peerErr(err)
+		}
+		at := time.Now()
+		timer := time.NewTimer(rto)
+		for {
+			d, err := s.awaitAck(ctx, timer.C)
+			if err != nil {
+				timer.Stop()
+				return nil, err
+			}
+			if d == nil {
+				break
+			}
+			if ha, ok := d.(*frame.HandshakeAck); ok {
+				timer.Stop()
+				if retries <= 0 {
+					s.peer.sampleRTT(time.Since(at))
+				}
+				return ha, nil
+			}
+		}
+		if s.peer.interop.LastSeen().Before(at) {
+			silent++
+		} else {
+			silent = 0
+		}
+		if silent >= s.peer.attempts {
+			return nil, ErrHandshakeTimeout
+		}
+		s.reportLoss("Retransmitted handshake", &frame.Handshake{StreamID: s.StreamID(), Length: length}, rto)
+		rto = backoff(rto)
+		count(&s.peer.stats.retransmits, 1)
+	}
+}
+
+// Log and trace the frame about to get retransmitted, since it has gone unacknowledged for the timeout.
+func (s *Stream) reportLoss(msg string, lost frame.Data, rto time.Duration, fields ...logging.Field) {
+	if t := s.peer.interop.Tracer(); t != nil {
+		t.LossDetected(lost)
+	}
+	if !s.peer.log.Enabled(logging.LevelDebug) {
+		return
+	}
+	fields = append(fields, logging.Stream(s.StreamID().Uint16()), logging.Frame(lost.Type()), logging.Any("rto", rto))
+	logging.Debug(s.peer.log, msg, fields...)
+}
+
+// Wait for the next ACK frame from the peer. The frame is nil if the timer has run out first.
+func (s *Stream) awaitAck(ctx context.Context, timer <-chan time.Time) (frame.Data, error) {
+	select {
+	case d := <-s.acks:
+		// The peer's listener has refused us, so it won't ever acknowledge anything
+		if _, ok := d.(*frame.Refusal); ok {
+			return nil, ErrPeerRefused
+		}
+		return d, nil
+	case <-timer:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.fin:
+		return nil, ErrStreamClosedByPeer
+	case <-s.interop.Done():
+		return nil, s.peerErr(ErrStreamAlreadyClosed)
+	}
+}
+
+// The error of the stream closed under us, unless the peer got closed over an error of its own, see interop.Peer.Err.
+func (s *Stream) peerErr(err error) error {
+	if perr := s.peer.interop.Err(); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func (s *Stream) receiveLoop() {
+	for {
+		// The loop ends once the stream is closed
+		f, err := s.interop.Receive(context.Background())
+		if err != nil {
+			s.rmu.Lock()
+			s.fail(ErrStreamAlreadyClosed)
+			s.rmu.Unlock()
+			// The stream is over, whether closed or failed along with the peer
+			s.peer.remove(s)
+			return
+		}
+		switch v := f.Data.(type) {
+		case *frame.HandshakeAck, *frame.StreamAck, *frame.Refusal:
+			select {
+			case s.acks <- v:
+			default:
+			}
+		case *frame.Handshake:
+			s.receiveHandshake(v)
+		case *frame.Stream:
+			s.receiveChunk(v)
+		case *frame.Fin:
+			s.receiveFin()
+		case *frame.Shutdown:
+			s.receiveShutdown(v)
+		}
+		if s.ended() {
+			s.retire()
+		}
+	}
+}
+
+// Whether nothing more goes either way over the stream, since the peer has closed it
+// or both sides have shut down writing.
+func (s *Stream) ended() bool {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	if s.finned {
+		return true
+	}
+	select {
+	case <-s.shut:
+		return s.rshut
+	default:
+		return false
+	}
+}
+
+// Let go of the stream once it has ended, even if it's never closed, so it no longer keeps the peer busy
+// nor counts against the peer's stream limit. Whatever is left to read stays readable.
+func (s *Stream) retire() {
+	s.rmu.Lock()
+	closing := s.closing
+	s.rmu.Unlock()
+	s.peer.remove(s)
+	// Closing the stream ourselves takes care of the interop stream
+	if !closing {
+		_ = s.interop.Close()
+	}
+}
+
+func (s *Stream) receiveHandshake(hs *frame.Handshake) {
+	if hs.Length <= 0 || len(hs.Hash) != md5.Size {
+		return
+	}
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	if s.finned || s.rshut {
+		return
+	}
+	// Our handshake ACK may have been lost, so the peer has retried the handshake
+	if m := s.msg; m != nil && m.matches(hs) {
+		_ = s.interop.AckHandshake(m.size)
+		return
+	}
+	if !s.fits(hs) {
+		s.pending = hs
+		return
+	}
+	s.start(hs)
+}
+
+// Whether the message fits in our receive window along with the data the reader hasn't taken yet.
+// Until it does, the stream holds back from acknowledging the peer's handshake.
+func (s *Stream) fits(hs *frame.Handshake) bool {
+	return s.rbuf.Len() <= 0 || s.rbuf.Len()+int(hs.Length) <= int(s.peer.interop.LocalTransportParams().InitialWindow)
+}
+
+// Start receiving the message, telling the peer the size of the frames we take.
+func (s *Stream) start(hs *frame.Handshake) {
+	size := frameSize(s.interop.TransportParams())
+	s.msg = newMessage(hs, size)
+	s.pending = nil
+	_ = s.interop.AckHandshake(size)
+}
+
+func (s *Stream) receiveChunk(st *frame.Stream) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	// A retransmitted chunk of a message already delivered, our ACK must have been lost
+	if s.delivered && int16(st.Sequence-s.last) <= 0 {
+		_ = s.interop.AckStream(st.Sequence)
+		return
+	}
+	m := s.msg
+	if m == nil {
+		return
+	}
+	i, ok := m.index(st)
+	if !ok {
+		return
+	}
+	first := st.Sequence - uint16(i)
+	if !m.started {
+		m.first = first
+		m.started = true
+	} else if first != m.first {
+		// Chunks of an abandoned attempt at the message may arrive late, the latest attempt wins
+		if int16(first-m.first) < 0 {
+			return
+		}
+		m.reset(first)
+	}
+	if !m.got[i] {
+		copy(m.buf[int(st.Offset):], st.Chunk)
+		m.got[i] = true
+		m.missing--
+	}
+	_ = s.interop.AckStream(st.Sequence)
+	if m.missing <= 0 {
+		s.deliver(m)
+	}
+}
+
+// Hand the complete message over to the reader, as long as its hash checks out.
+func (s *Stream) deliver(m *message) {
+	s.msg = nil
+	s.last = m.first + uint16(len(m.got)-1)
+	s.delivered = true
+	sum := md5.Sum(m.buf)
+	if !bytes.Equal(sum[:], m.hash) {
+		logging.Warn(s.peer.log, "Message checksum mismatch", logging.Stream(s.StreamID().Uint16()))
+		s.fail(ErrChecksumMismatch)
+		return
+	}
+	s.rbuf.Write(m.buf)
+	count(&s.peer.stats.bytesReceived, len(m.buf))
+	s.signal()
+}
+
+func (s *Stream) receiveFin() {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	if s.finned {
+		return
+	}
+	s.finned = true
+	close(s.fin)
+	s.msg = nil
+	s.pending = nil
+	s.fail(io.EOF)
+	// Echo the FIN frame back, so the peer knows we've seen the end of the stream
+	if !s.closing {
+		_ = s.interop.Send(frame.Fin{StreamID: s.StreamID()})
+	}
+}
+
+func (s *Stream) receiveShutdown(sd *frame.Shutdown) {
+	if sd.Ack {
+		s.shutOnce.Do(func() {
+			close(s.shut)
+		})
+		return
+	}
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	// Acknowledge every shutdown frame, since our ACK may have been lost.
+	// The peer only shuts down once its writes are acknowledged, so there's nothing left to receive.
+	_ = s.interop.Send(frame.Shutdown{StreamID: s.StreamID(), Ack: true})
+	if s.rshut {
+		return
+	}
+	s.rshut = true
+	s.traceState(interop.StreamHalfClosedRemote)
+	s.msg = nil
+	s.pending = nil
+	s.fail(io.EOF)
+}
+
+func (s *Stream) traceState(state interop.StreamState) {
+	if t := s.peer.interop.Tracer(); t != nil {
+		t.StreamStateUpdated(s.StreamID(), state)
+	}
+}
+
+// Stop reading with the error once the buffered data is drained. The first error sticks.
+func (s *Stream) fail(err error) {
+	if s.rerr == nil {
+		s.rerr = err
+	}
+	s.signal()
+}
+
+// Wake up the reader.
+func (s *Stream) signal() {
+	select {
+	case s.readable <- struct{}{}:
+	default:
+	}
+}
+
+// The size of the stream frames we take, bound by the negotiated maximum frame size.
+func frameSize(tp frame.TransportParams) uint16 {
+	size := frame.FrameDataMaxSize
+	if max := int(tp.MaxFrameSize) - frame.FrameBaseSize; max > frame.StreamBaseSize && max < size {
+		size = max
+	}
+	return uint16(size)
+}
+
+// The size of the chunks which fit in the stream frames of the size the peer takes.
+func chunkSize(size uint16) int {
+	n := int(size) - frame.StreamBaseSize
+	if n > frame.StreamChunkMaxSize {
+		return frame.StreamChunkMaxSize
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Message being received over the stream.
+type message struct {
+	hash []byte
+	// The frame size we've told the peer with the handshake ACK.
+	size  uint16
+	chunk int
+	buf   []byte
+	got   []bool
+	// Sequence number of the first chunk, known once any chunk has arrived.
+	first   uint16
+	started bool
+	missing int
+}
+
+func newMessage(hs *frame.Handshake, size uint16) *message {
+	chunk := chunkSize(size)
+	n := (int(hs.Length) + chunk - 1) / chunk
+	return &message{
+		hash:    append([]byte(nil), hs.Hash...),
+		size:    size,
+		chunk:   chunk,
+		buf:     make([]byte, hs.Length),
+		got:     make([]bool, n),
+		missing: n,
+	}
+}
+
+// Whether the handshake is a retry of the one the message has started with.
+func (m *message) matches(hs *frame.Handshake) bool {
+	return len(m.buf) == int(hs.Length) && bytes.Equal(m.hash, hs.Hash)
+}
+
+// The index of the chunk within the message, as long as the chunk fits the message.
+func (m *message) index(st *frame.Stream) (int, bool) {
+	off := int(st.Offset)
+	if off%m.chunk != 0 || off >= len(m.buf) {
+		return 0, false
+	}
+	size := len(m.buf) - off
+	if size > m.chunk {
+		size = m.chunk
+	}
+	if len(st.Chunk) != size {
+		return 0, false
+	}
+	return off / m.chunk, true
+}
+
+// Start over collecting the chunks, since the ones so far belong to an older attempt at the message.
+func (m *message) reset(first uint16) {
+	for i := range m.got {
+		m.got[i] = false
+	}
+	m.missing = len(m.got)
+	m.first = first
+}
+
+// SendStream is our side of a unidirectional stream we've opened, over which only we send data.
+type SendStream struct {
+	s *Stream
+}
+
+var _ io.WriteCloser = (*SendStream)(nil)
+
+func (s *SendStream) StreamID() frame.StreamID {
+	return s.s.StreamID()
+}
+
+func (s *SendStream) Write(b []byte) (int, error) {
+	return s.s.Write(b)
+}
+
+func (s *SendStream) WriteContext(ctx context.Context, b []byte) (int, error) {
+	return s.s.WriteContext(ctx, b)
+}
+
+func (s *SendStream) Close() error {
+	return s.s.Close()
+}
+
+func (s *SendStream) SetRateLimit(limit ratelimit.Limit) {
+	s.s.SetRateLimit(limit)
+}
+
+func (s *SendStream) RateLimit() ratelimit.Limit {
+	return s.s.RateLimit()
+}
+
+// ReceiveStream is our side of a unidirectional stream the peer has opened, over which only the peer sends data.
+type ReceiveStream struct {
+	s *Stream
+}
+
+var _ io.ReadCloser = (*ReceiveStream)(nil)
+
+func (s *ReceiveStream) StreamID() frame.StreamID {
+	return s.s.StreamID()
+}
+
+func (s *ReceiveStream) Read(b []byte) (int, error) {
+	return s.s.Read(b)
+}
+
+func (s *ReceiveStream) ReadContext(ctx context.Context, b []byte) (int, error) {
+	return s.s.ReadContext(ctx, b)
+}
+
+func (s *ReceiveStream) Close() error {
+	return s.s.Close()
+}
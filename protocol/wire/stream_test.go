@@ -0,0 +1,626 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reliable-udp/protocol/frame"
+	"reliable-udp/protocol/wire/interop"
+	"reliable-udp/util/logging"
+	"reliable-udp/util/ratelimit"
+	"reliable-udp/util/simnet"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func listenPair(t *testing.T) (*Listener, *Listener) {
+	a, err := Listen("127.0.0.1:0", nil)
+	require.Nil(t, err)
+	b, err := Listen("127.0.0.1:0", nil)
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestStream(t *testing.T) {
+	require := require.New(t)
+	a, b := listenPair(t)
+
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+
+	// Data larger than a single message, so it gets split into multiple messages
+	data := make([]byte, 200000)
+	_, err = rand.Read(data)
+	require.Nil(err)
+
+	cherr := make(chan error, 1)
+	go func() {
+		s, err := apeer.OpenStream()
+		if err != nil {
+			cherr <- err
+			return
+		}
+		if _, err := s.Write(data); err != nil {
+			cherr <- err
+			return
+		}
+		cherr <- s.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bpeer, err := b.AcceptContext(ctx)
+	require.Nil(err)
+	s, err := bpeer.AcceptStreamContext(ctx)
+	require.Nil(err)
+
+	// Test the data arrives in full and in order, followed by the end of the stream
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, s)
+	require.Nil(err)
+	require.Equal(data, buf.Bytes())
+	require.Nil(<-cherr)
+	require.NotZero(apeer.RTT())
+
+	require.Nil(s.Close())
+	require.Equal(ErrStreamAlreadyClosed, s.Close())
+}
+
+func TestContext(t *testing.T) {
+	require := require.New(t)
+	a, b := listenPair(t)
+
+	// Test accepting gives up once the context is done
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := a.AcceptContext(ctx)
+		require.Equal(context.DeadlineExceeded, err)
+	}
+
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	s, err := apeer.OpenStream()
+	require.Nil(err)
+	cherr := make(chan error, 1)
+	go func() {
+		_, err := s.Write([]byte("Hello, world!"))
+		cherr <- err
+	}()
+	bpeer, err := b.Accept()
+	require.Nil(err)
+
+	// Test accepting streams gives up once the context is cancelled
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		_, err := bpeer.AcceptUniStreamContext(ctx)
+		require.Equal(context.Canceled, err)
+	}
+
+	r, err := bpeer.AcceptStream()
+	require.Nil(err)
+	require.Nil(<-cherr)
+
+	// Test reading gives up once the context is done, without losing the data buffered so far
+	{
+		b := make([]byte, 5)
+		n, err := r.Read(b)
+		require.Nil(err)
+		require.Equal("Hello", string(b[:n]))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		b = make([]byte, 64)
+		n, err = r.ReadContext(ctx, b)
+		require.Nil(err)
+		require.Equal(", world!", string(b[:n]))
+		_, err = r.ReadContext(ctx, b)
+		require.Equal(context.DeadlineExceeded, err)
+	}
+
+	// Test writing gives up once the context is done while the peer doesn't accept the stream
+	{
+		s, err := apeer.OpenStream()
+		require.Nil(err)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		n, err := s.WriteContext(ctx, []byte("Hello?"))
+		require.Zero(n)
+		require.Equal(context.DeadlineExceeded, err)
+	}
+
+	// Test writing stops once the peer has closed the stream
+	{
+		require.Nil(r.Close())
+		_, err := s.Write([]byte("Hello?"))
+		require.Equal(ErrStreamClosedByPeer, err)
+	}
+}
+
+func TestCloseWrite(t *testing.T) {
+	require := require.New(t)
+	a, b := listenPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	as, err := apeer.OpenStreamContext(ctx)
+	require.Nil(err)
+	cherr := make(chan error, 1)
+	go func() {
+		if _, err := as.Write([]byte("ping")); err != nil {
+			cherr <- err
+			return
+		}
+		cherr <- as.CloseWrite()
+	}()
+	bpeer, err := b.AcceptContext(ctx)
+	require.Nil(err)
+	bs, err := bpeer.AcceptStreamContext(ctx)
+	require.Nil(err)
+
+	// Test the peer reads to the end of the stream once we're done writing
+	received, err := io.ReadAll(bs)
+	require.Nil(err)
+	require.Equal("ping", string(received))
+	require.Nil(<-cherr)
+	_, err = as.Write([]byte("ping"))
+	require.Equal(ErrStreamWriteClosed, err)
+	require.Equal(ErrStreamWriteClosed, as.CloseWrite())
+
+	// Test the peer still writes to us after we're done writing
+	_, err = bs.WriteContext(ctx, []byte("pong"))
+	require.Nil(err)
+	require.Nil(bs.CloseWrite())
+	received, err = io.ReadAll(as)
+	require.Nil(err)
+	require.Equal("pong", string(received))
+
+	require.Nil(as.Close())
+	require.Nil(bs.Close())
+}
+
+func TestRefusal(t *testing.T) {
+	require := require.New(t)
+	a, err := Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer a.Close()
+	b, err := Listen("127.0.0.1:0", &Config{MaxPeers: 1})
+	require.Nil(err)
+	defer b.Close()
+	c, err := Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		bpeer, err := b.AcceptContext(ctx)
+		if err != nil {
+			return
+		}
+		bs, err := bpeer.AcceptStreamContext(ctx)
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, bs)
+	}()
+
+	// Test the peers past the listener's limit get told they're refused rather than retrying
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	as, err := apeer.OpenStreamContext(ctx)
+	require.Nil(err)
+	_, err = as.WriteContext(ctx, []byte("Hello"))
+	require.Nil(err)
+	cpeer, err := c.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	cs, err := cpeer.OpenStreamContext(ctx)
+	require.Nil(err)
+	_, err = cs.WriteContext(ctx, []byte("Hello"))
+	require.Equal(ErrPeerRefused, err)
+}
+
+func TestWindow(t *testing.T) {
+	require := require.New(t)
+	a, err := Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer a.Close()
+	b, err := Listen("127.0.0.1:0", &Config{TransportParams: frame.TransportParams{InitialWindow: 1000}})
+	require.Nil(err)
+	defer b.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data := make([]byte, 10000)
+	_, err = rand.Read(data)
+	require.Nil(err)
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	as, err := apeer.OpenStream()
+	require.Nil(err)
+	cherr := make(chan error, 1)
+	go func() {
+		_, err := as.WriteContext(ctx, data)
+		cherr <- err
+	}()
+	bpeer, err := b.AcceptContext(ctx)
+	require.Nil(err)
+	bs, err := bpeer.AcceptStreamContext(ctx)
+	require.Nil(err)
+
+	// Test the peer sends no more than our window until our reader takes the data
+	require.Eventually(func() bool {
+		return apeer.Stats().BytesSent >= 1000
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(uint64(1000), apeer.Stats().BytesSent)
+
+	actual := make([]byte, len(data))
+	_, err = io.ReadFull(bs, actual)
+	require.Nil(err)
+	require.Equal(data, actual)
+	require.Nil(<-cherr)
+}
+
+func TestVersionMismatch(t *testing.T) {
+	require := require.New(t)
+	a, err := Listen("127.0.0.1:0", &Config{SupportedVersions: []uint32{frame.ProtocolVersion + 1}})
+	require.Nil(err)
+	defer a.Close()
+	b, err := Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer b.Close()
+
+	// Test writing fails once the peer turns out to speak none of our versions
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	s, err := apeer.OpenStream()
+	require.Nil(err)
+	_, err = s.Write([]byte("Hello?"))
+	require.Equal(interop.ErrVersionMismatch, err)
+	_, err = apeer.OpenStream()
+	require.Equal(interop.ErrVersionMismatch, err)
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	require := require.New(t)
+	a, err := Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer a.Close()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.Nil(err)
+	defer conn.Close()
+
+	// Test writing gives up on a peer which is never heard from
+	apeer, err := a.Peer(conn.LocalAddr().String())
+	require.Nil(err)
+	apeer.attempts = 3
+	s, err := apeer.OpenStream()
+	require.Nil(err)
+	n, err := s.Write([]byte("Hello?"))
+	require.Zero(n)
+	require.Equal(ErrHandshakeTimeout, err)
+}
+
+func TestRateLimit(t *testing.T) {
+	require := require.New(t)
+	a, err := Listen("127.0.0.1:0", &Config{StreamRateLimit: ratelimit.Limit{Rate: 200000, Burst: 20000}})
+	require.Nil(err)
+	defer a.Close()
+	b, err := Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer b.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() {
+		bpeer, err := b.AcceptContext(ctx)
+		if err != nil {
+			return
+		}
+		for {
+			bs, err := bpeer.AcceptStreamContext(ctx)
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, bs)
+		}
+	}()
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	data := make([]byte, 100000)
+
+	// Test the stream's limit holds back the writes, once the burst is spent
+	{
+		s, err := apeer.OpenStreamContext(ctx)
+		require.Nil(err)
+		require.Equal(ratelimit.Limit{Rate: 200000, Burst: 20000}, s.RateLimit())
+		start := time.Now()
+		_, err = s.WriteContext(ctx, data)
+		require.Nil(err)
+		require.True(time.Since(start) >= 350*time.Millisecond, time.Since(start))
+	}
+
+	// Test the peer's limit applies on top, while lifting it at runtime lets the writes through right away
+	{
+		s, err := apeer.OpenStreamContext(ctx)
+		require.Nil(err)
+		s.SetRateLimit(ratelimit.Limit{})
+		apeer.SetRateLimit(ratelimit.Limit{Rate: 1000})
+		done := make(chan time.Time, 1)
+		go func() {
+			s.WriteContext(ctx, data)
+			done <- time.Now()
+		}()
+		time.Sleep(100 * time.Millisecond)
+		require.Len(done, 0)
+		lifted := time.Now()
+		apeer.SetRateLimit(ratelimit.Limit{})
+		require.True((<-done).Sub(lifted) < time.Second)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	require := require.New(t)
+	a, b := listenPair(t)
+	c, err := Listen("127.0.0.1:0", nil)
+	require.Nil(err)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	release := make(chan struct{})
+	go func() {
+		bpeer, err := b.AcceptContext(ctx)
+		if err != nil {
+			return
+		}
+		s, err := bpeer.AcceptStreamContext(ctx)
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(s, buf); err != nil {
+			return
+		}
+		<-release
+		s.WriteContext(ctx, []byte("world"))
+		s.Close()
+	}()
+	baddr := b.LocalAddr().String()
+	apeer, err := a.Peer(baddr)
+	require.Nil(err)
+	s, err := apeer.OpenStreamContext(ctx)
+	require.Nil(err)
+	_, err = s.WriteContext(ctx, []byte("Hello"))
+	require.Nil(err)
+
+	// Test the streams in flight get to finish, while new peers get refused
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Shutdown(ctx)
+	}()
+	require.Eventually(func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.closing
+	}, 5*time.Second, time.Millisecond)
+	cpeer, err := c.Peer(baddr)
+	require.Nil(err)
+	cs, err := cpeer.OpenStreamContext(ctx)
+	require.Nil(err)
+	_, err = cs.WriteContext(ctx, []byte("Hello"))
+	require.Equal(ErrPeerRefused, err)
+	_, err = b.AcceptContext(ctx)
+	require.Equal(io.EOF, err)
+	_, err = b.Peer(c.LocalAddr().String())
+	require.Equal(ErrListenerShuttingDown, err)
+	require.Equal(ErrListenerShuttingDown, b.Shutdown(ctx))
+	require.Len(done, 0)
+	close(release)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(s, buf)
+	require.Nil(err)
+	require.Equal("world", string(buf))
+	require.Nil(<-done)
+
+	// Test the peer gets told about the shutdown, which closes and removes it, so a new peer takes its place
+	require.Eventually(func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		_, ok := a.peers[baddr]
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = apeer.OpenStreamContext(ctx)
+	require.Equal(interop.ErrPeerAlreadyClosed, err)
+	require.Eventually(func() bool {
+		p, err := a.Peer(baddr)
+		return err == nil && p != apeer
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Test the streams the peer has closed no longer keep the peer busy, even if never closed on our side
+	{
+		e, err := Listen("127.0.0.1:0", nil)
+		require.Nil(err)
+		read := make(chan struct{})
+		go func() {
+			epeer, err := e.AcceptContext(ctx)
+			if err != nil {
+				return
+			}
+			es, err := epeer.AcceptStreamContext(ctx)
+			if err != nil {
+				return
+			}
+			io.ReadAll(es)
+			close(read)
+		}()
+		cpeer, err := c.Peer(e.LocalAddr().String())
+		require.Nil(err)
+		cs, err := cpeer.OpenStreamContext(ctx)
+		require.Nil(err)
+		_, err = cs.WriteContext(ctx, []byte("Hello"))
+		require.Nil(err)
+		require.Nil(cs.Close())
+		<-read
+		start := time.Now()
+		require.Nil(e.Shutdown(ctx))
+		require.Less(time.Since(start).Seconds(), 1.0)
+	}
+
+	// Test the peers still busy once the context is done get closed anyway
+	{
+		d, err := Listen("127.0.0.1:0", nil)
+		require.Nil(err)
+		go func() {
+			dpeer, err := d.AcceptContext(ctx)
+			if err != nil {
+				return
+			}
+			dpeer.AcceptStreamContext(ctx)
+		}()
+		cpeer, err := c.Peer(d.LocalAddr().String())
+		require.Nil(err)
+		cs, err := cpeer.OpenStreamContext(ctx)
+		require.Nil(err)
+		_, err = cs.WriteContext(ctx, []byte("Hello"))
+		require.Nil(err)
+		sctx, scancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer scancel()
+		err = d.Shutdown(sctx)
+		require.True(errors.Is(err, context.DeadlineExceeded), err)
+		require.Equal(ErrListenerNotOpen, d.Close())
+	}
+
+	// Test closing the listener while its peers close by themselves, as the peer's FIN or the idle timeout do
+	for i := 0; i < 20; i++ {
+		f, err := Listen("127.0.0.1:0", nil)
+		require.Nil(err)
+		start := make(chan struct{})
+		for port := 1; port <= 200; port++ {
+			p, err := f.Peer(fmt.Sprintf("127.0.0.1:%d", port))
+			require.Nil(err)
+			go func() {
+				<-start
+				p.close(true)
+			}()
+		}
+		closed := make(chan struct{})
+		go func() {
+			<-start
+			f.Close()
+			close(closed)
+		}()
+		close(start)
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			require.Fail("closing the listener got stuck")
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	require := require.New(t)
+	n := simnet.New(1, simnet.Config{Loss: 0.05, Latency: 5 * time.Millisecond})
+	stop := n.Realtime(time.Millisecond)
+	defer stop()
+	rec := &recorder{}
+	serve := func() *Listener {
+		conn, err := n.Listen(nil)
+		require.Nil(err)
+		l := NewListener(&Config{Logger: rec})
+		require.Nil(l.Serve(conn))
+		require.Equal(ErrListenerAlreadyOpen, l.Serve(conn))
+		t.Cleanup(func() {
+			l.Close()
+		})
+		return l
+	}
+	a, b := serve(), serve()
+
+	// Test the data makes it over the lossy simulated network, by retransmitting
+	apeer, err := a.Peer(b.LocalAddr().String())
+	require.Nil(err)
+	data := make([]byte, 100000)
+	_, err = rand.Read(data)
+	require.Nil(err)
+	cherr := make(chan error, 1)
+	go func() {
+		s, err := apeer.OpenStream()
+		if err != nil {
+			cherr <- err
+			return
+		}
+		if _, err := s.Write(data); err != nil {
+			cherr <- err
+			return
+		}
+		cherr <- s.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	bpeer, err := b.AcceptContext(ctx)
+	require.Nil(err)
+	s, err := bpeer.AcceptStreamContext(ctx)
+	require.Nil(err)
+	received := make([]byte, len(data))
+	_, err = io.ReadFull(s, received)
+	require.Nil(err)
+	require.Equal(data, received)
+	require.Nil(<-cherr)
+
+	stats := apeer.Stats()
+	require.Equal(uint64(len(data)), stats.BytesSent)
+	require.NotZero(stats.Retransmits)
+	require.NotEmpty(apeer.RTTSamples())
+
+	// Test the retransmissions get logged with the peer and the stream
+	fields := rec.find("Retransmitted chunk")
+	require.NotNil(fields)
+	require.Contains(fields, logging.Peer(b.LocalAddr()))
+	require.Contains(fields, logging.Stream(s.StreamID().Uint16()))
+	require.NotNil(rec.find("Handshake completed"))
+}
+
+// Records every message logged.
+type recorder struct {
+	mu      sync.Mutex
+	entries map[string][]logging.Field
+}
+
+func (r *recorder) Enabled(logging.Level) bool {
+	return true
+}
+
+func (r *recorder) Log(level logging.Level, msg string, fields ...logging.Field) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries == nil {
+		r.entries = make(map[string][]logging.Field)
+	}
+	r.entries[msg] = fields
+}
+
+// The fields of the message last logged, nil if it hasn't been logged.
+func (r *recorder) find(msg string) []logging.Field {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries[msg]
+}
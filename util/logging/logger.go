@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level %d", int(l))
+}
+
+// Logger records what's going on with structured fields, see the adapters Slog and Logrus.
+type Logger interface {
+	// Whether the messages of the level get recorded at all, letting the callers skip building them.
+	Enabled(level Level) bool
+	Log(level Level, msg string, fields ...Field)
+}
+
+// Field is a key-value pair describing the logged message.
+type Field struct {
+	Key   string
+	Value any
+}
+
+func Any(key string, value any) Field {
+	return Field{key, value}
+}
+
+// The address of the peer.
+func Peer(addr net.Addr) Field {
+	if addr == nil {
+		return Field{"peer", nil}
+	}
+	return Field{"peer", addr.String()}
+}
+
+// The stream ID.
+func Stream(sid uint16) Field {
+	return Field{"stream", sid}
+}
+
+// The frame type.
+func Frame(ft fmt.Stringer) Field {
+	return Field{"frame", ft.String()}
+}
+
+func Err(err error) Field {
+	return Field{"error", err}
+}
+
+func Debug(l Logger, msg string, fields ...Field) {
+	l.Log(LevelDebug, msg, fields...)
+}
+
+func Info(l Logger, msg string, fields ...Field) {
+	l.Log(LevelInfo, msg, fields...)
+}
+
+func Warn(l Logger, msg string, fields ...Field) {
+	l.Log(LevelWarn, msg, fields...)
+}
+
+func Error(l Logger, msg string, fields ...Field) {
+	l.Log(LevelError, msg, fields...)
+}
+
+// Nop discards everything, which is the default logger of the protocol.
+func Nop() Logger {
+	return nop{}
+}
+
+type nop struct{}
+
+func (nop) Enabled(Level) bool {
+	return false
+}
+
+func (nop) Log(Level, string, ...Field) {}
+
+// With adds the fields to every message logged with the returned logger.
+func With(l Logger, fields ...Field) Logger {
+	if _, ok := l.(nop); ok {
+		return l
+	}
+	if w, ok := l.(*with); ok {
+		return &with{w.l, append(append([]Field(nil), w.fields...), fields...)}
+	}
+	return &with{l, fields}
+}
+
+type with struct {
+	l      Logger
+	fields []Field
+}
+
+func (w *with) Enabled(level Level) bool {
+	return w.l.Enabled(level)
+}
+
+func (w *with) Log(level Level, msg string, fields ...Field) {
+	w.l.Log(level, msg, append(append(make([]Field, 0, len(w.fields)+len(fields)), w.fields...), fields...)...)
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	require := require.New(t)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+	// Test the slog adapter records the fields as attributes, skipping the disabled levels
+	{
+		var buf bytes.Buffer
+		l := With(Slog(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))), Peer(addr))
+		require.False(l.Enabled(LevelDebug))
+		require.True(l.Enabled(LevelWarn))
+		Debug(l, "Hidden")
+		Warn(l, "Dropped", Stream(1), Err(errors.New("oops")))
+		var record map[string]any
+		require.Nil(json.Unmarshal(buf.Bytes(), &record))
+		require.Equal("WARN", record["level"])
+		require.Equal("Dropped", record["msg"])
+		require.Equal("127.0.0.1:9000", record["peer"])
+		require.Equal(float64(1), record["stream"])
+		require.Equal("oops", record["error"])
+	}
+
+	// Test the logrus adapter records the fields as its fields, skipping the disabled levels
+	{
+		var buf bytes.Buffer
+		lr := logrus.New()
+		lr.SetOutput(&buf)
+		lr.SetFormatter(&logrus.JSONFormatter{})
+		lr.SetLevel(logrus.InfoLevel)
+		l := With(With(Logrus(lr), Peer(addr)), Stream(2))
+		require.False(l.Enabled(LevelDebug))
+		Debug(l, "Hidden")
+		Info(l, "Opened", Any("client", true))
+		var record map[string]any
+		require.Nil(json.Unmarshal(buf.Bytes(), &record))
+		require.Equal("info", record["level"])
+		require.Equal("Opened", record["msg"])
+		require.Equal("127.0.0.1:9000", record["peer"])
+		require.Equal(float64(2), record["stream"])
+		require.Equal(true, record["client"])
+	}
+
+	// Test the default logger discards everything
+	{
+		l := With(Nop(), Peer(addr))
+		require.False(l.Enabled(LevelError))
+		Error(l, "Discarded")
+	}
+}
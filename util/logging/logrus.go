@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+var logrusLevels = map[Level]logrus.Level{
+	LevelDebug: logrus.DebugLevel,
+	LevelInfo:  logrus.InfoLevel,
+	LevelWarn:  logrus.WarnLevel,
+	LevelError: logrus.ErrorLevel,
+}
+
+// Logrus records the messages with the logrus logger, the fields becoming its fields.
+func Logrus(l *logrus.Logger) Logger {
+	return &logrusLogger{l}
+}
+
+type logrusLogger struct {
+	l *logrus.Logger
+}
+
+func (l *logrusLogger) Enabled(level Level) bool {
+	return l.l.IsLevelEnabled(logrusLevels[level])
+}
+
+func (l *logrusLogger) Log(level Level, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	lf := make(logrus.Fields, len(fields))
+	for _, f := range fields {
+		lf[f.Key] = f.Value
+	}
+	l.l.WithFields(lf).Log(logrusLevels[level], msg)
+}
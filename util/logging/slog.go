@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+var slogLevels = map[Level]slog.Level{
+	LevelDebug: slog.LevelDebug,
+	LevelInfo:  slog.LevelInfo,
+	LevelWarn:  slog.LevelWarn,
+	LevelError: slog.LevelError,
+}
+
+// Slog records the messages with the slog logger, the fields becoming its attributes.
+func Slog(l *slog.Logger) Logger {
+	return &slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s *slogLogger) Enabled(level Level) bool {
+	return s.l.Enabled(context.Background(), slogLevels[level])
+}
+
+func (s *slogLogger) Log(level Level, msg string, fields ...Field) {
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, slogLevels[level]) {
+		return
+	}
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	s.l.LogAttrs(ctx, slogLevels[level], msg, attrs...)
+}
@@ -1,26 +1,26 @@
 package observable
 
-type OnEach func(o *Observer, v interface{})
+type OnEach[T any] func(o *Observer[T], v T)
 
-type OnDispose func(o *Observer)
+type OnDispose[T any] func(o *Observer[T])
 
-type Handler interface {
-	OnEach(o *Observer, v interface{})
-	OnDispose(o *Observer)
+type Handler[T any] interface {
+	OnEach(o *Observer[T], v T)
+	OnDispose(o *Observer[T])
 }
 
-type handlerImpl struct {
-	each    OnEach
-	dispose OnDispose
+type handlerImpl[T any] struct {
+	each    OnEach[T]
+	dispose OnDispose[T]
 }
 
-func (h *handlerImpl) OnEach(o *Observer, v interface{}) {
+func (h *handlerImpl[T]) OnEach(o *Observer[T], v T) {
 	if h.each != nil {
 		h.each(o, v)
 	}
 }
 
-func (h *handlerImpl) OnDispose(o *Observer) {
+func (h *handlerImpl[T]) OnDispose(o *Observer[T]) {
 	if h.dispose != nil {
 		h.dispose(o)
 	}
@@ -6,53 +6,98 @@ import (
 
 type DisposeFunc func()
 
-type Observable struct {
-	mu        sync.RWMutex
-	observers map[int]*Observer
-	nextId    int
-	disposed  bool
+// Every observer and subscription of the observable receives the dispatched values.
+type subscriber[T any] interface {
+	send(v T)
+	close()
 }
 
-func New() *Observable {
-	return &Observable{
-		observers: make(map[int]*Observer),
+// Observable dispatches the values of type T to its observers and subscriptions.
+type Observable[T any] struct {
+	mu          sync.RWMutex
+	subscribers map[int]subscriber[T]
+	nextId      int
+	disposed    bool
+	// Closed as soon as disposing starts, to let go of the dispatching blocked on full subscriptions.
+	closing chan struct{}
+	once    sync.Once
+}
+
+func New[T any]() *Observable[T] {
+	return &Observable[T]{
+		subscribers: make(map[int]subscriber[T]),
+		closing:     make(chan struct{}),
 	}
 }
 
-func (o *Observable) Observe() *Observer {
+// Observe the dispatched values with handlers. Observing an already disposed observable
+// is safe, the observer is disposed from the start.
+func (o *Observable[T]) Observe() *Observer[T] {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 	if o.disposed {
-		return nil
+		ob := NewObserver(o, 0)
+		ob.dispose(false)
+		return ob
 	}
 	o.nextId++
 	ob := NewObserver(o, o.nextId)
-	o.observers[ob.id] = ob
+	o.subscribers[ob.id] = ob
 	return ob
 }
 
-func (o *Observable) Dispatch(i interface{}) {
+// Subscribe to the dispatched values over a channel buffering up to the size,
+// applying the overflow policy once the buffer is full.
+// Subscribing to an already disposed observable is safe, the channel is closed from the start.
+func (o *Observable[T]) Subscribe(size int, overflow Overflow) *Subscription[T] {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.disposed {
+		s := newSubscription(o, 0, size, overflow)
+		s.close()
+		return s
+	}
+	o.nextId++
+	s := newSubscription(o, o.nextId, size, overflow)
+	o.subscribers[s.id] = s
+	return s
+}
+
+func (o *Observable[T]) Dispatch(v T) {
 	o.mu.RLock()
 	defer o.mu.RUnlock()
 	if o.disposed {
 		return
 	}
-	for _, ob := range o.observers {
-		ob.dispatch(i)
+	for _, s := range o.subscribers {
+		s.send(v)
 	}
 }
 
-func (o *Observable) Dispose() {
+func (o *Observable[T]) Disposed() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.disposed
+}
+
+func (o *Observable[T]) Dispose() {
+	o.once.Do(func() {
+		close(o.closing)
+	})
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	for _, ob := range o.observers {
-		ob.dispose(false)
+	if o.disposed {
+		return
+	}
+	for _, s := range o.subscribers {
+		s.close()
 	}
-	o.observers = nil
+	o.subscribers = nil
+	o.disposed = true
 }
 
-func (o *Observable) remove(id int) {
+func (o *Observable[T]) remove(id int) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	delete(o.observers, id)
+	delete(o.subscribers, id)
 }
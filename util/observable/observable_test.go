@@ -1,6 +1,7 @@
 package observable
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -10,14 +11,14 @@ import (
 func TestObservable(t *testing.T) {
 	require := require.New(t)
 	expected := "Hello, world!"
-	observable := New()
+	observable := New[string]()
 
 	ob := observable.Observe()
-	ch := make(chan interface{}, 1)
+	ch := make(chan string, 1)
 	done := make(chan struct{}, 1)
-	ob.HandleFunc(func(ob *Observer, v interface{}) {
+	ob.HandleFunc(func(ob *Observer[string], v string) {
 		ch <- v
-	}, func(*Observer) {
+	}, func(*Observer[string]) {
 		close(done)
 	})
 
@@ -27,9 +28,109 @@ func TestObservable(t *testing.T) {
 	ob.Dispose()
 	observable.Dispatch(expected)
 	assertDone(require, ch, done)
+
+	// Test observing after disposing is safe, the handlers get disposed right away
+	observable.Dispose()
+	require.True(observable.Disposed())
+	disposed := false
+	observable.Observe().HandleFunc(nil, func(*Observer[string]) {
+		disposed = true
+	})
+	require.True(disposed)
+	_, ok := <-observable.Subscribe(1, Block).C()
+	require.False(ok)
+}
+
+func TestSubscription(t *testing.T) {
+	require := require.New(t)
+	observable := New[int]()
+
+	// Test the overflow policies once the buffer is full
+	block := observable.Subscribe(2, Block)
+	oldest := observable.Subscribe(2, DropOldest)
+	newest := observable.Subscribe(2, DropNewest)
+	observable.Dispatch(1)
+	observable.Dispatch(2)
+	dispatched := make(chan struct{})
+	go func() {
+		observable.Dispatch(3)
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+		require.Fail("Expected dispatching to block on the full subscription")
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.Equal(1, <-block.C())
+	<-dispatched
+	require.Equal([]int{2, 3}, drain(block))
+	require.Equal([]int{2, 3}, drain(oldest))
+	require.Equal(uint64(1), oldest.Dropped())
+	require.Equal([]int{1, 2}, drain(newest))
+	require.Equal(uint64(1), newest.Dropped())
+
+	// Test disposing lets go of the dispatching blocked on the full subscription
+	observable.Dispatch(4)
+	observable.Dispatch(5)
+	dispatched = make(chan struct{})
+	go func() {
+		observable.Dispatch(6)
+		close(dispatched)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	block.Dispose()
+	<-dispatched
+	require.Equal([]int{4, 5}, drain(block))
+	_, ok := <-block.C()
+	require.False(ok)
+
+	// Test disposing the observable closes the subscriptions
+	observable.Dispose()
+	_, ok = <-oldest.C()
+	require.True(ok)
+	_, ok = <-oldest.C()
+	require.True(ok)
+	_, ok = <-oldest.C()
+	require.False(ok)
+}
+
+func TestOperators(t *testing.T) {
+	require := require.New(t)
+	observable := New[int]()
+	even := Filter(observable, func(v int) bool {
+		return v%2 == 0
+	})
+	names := Map(even, strconv.Itoa).Subscribe(4, Block)
+	for i := 1; i <= 4; i++ {
+		observable.Dispatch(i)
+	}
+	require.Equal("2", <-names.C())
+	require.Equal("4", <-names.C())
+
+	// Test disposing the source disposes the derived observables too
+	observable.Dispose()
+	require.True(even.Disposed())
+	_, ok := <-names.C()
+	require.False(ok)
+}
+
+// Take the buffered values out of the subscription.
+func drain[T any](s *Subscription[T]) []T {
+	vs := make([]T, 0)
+	for {
+		select {
+		case v, ok := <-s.C():
+			if !ok {
+				return vs
+			}
+			vs = append(vs, v)
+		default:
+			return vs
+		}
+	}
 }
 
-func assertNext(require *require.Assertions, ch <-chan interface{}, expected string) {
+func assertNext(require *require.Assertions, ch <-chan string, expected string) {
 	timeout := time.After(100 * time.Millisecond)
 	select {
 	case v := <-ch:
@@ -39,7 +140,7 @@ func assertNext(require *require.Assertions, ch <-chan interface{}, expected str
 	}
 }
 
-func assertDone(require *require.Assertions, ch <-chan interface{}, done <-chan struct{}) {
+func assertDone(require *require.Assertions, ch <-chan string, done <-chan struct{}) {
 	timeout := time.After(100 * time.Millisecond)
 	select {
 	case <-done:
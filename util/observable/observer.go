@@ -4,74 +4,75 @@ import (
 	"sync"
 )
 
-type Observer struct {
-	ob       *Observable
+// Observer calls its handlers with every dispatched value.
+type Observer[T any] struct {
+	ob       *Observable[T]
 	id       int
-	handlers []Handler
+	handlers []Handler[T]
 	mu       sync.RWMutex
 	disposed bool
 }
 
-func NewObserver(ob *Observable, id int) *Observer {
-	return &Observer{
+func NewObserver[T any](ob *Observable[T], id int) *Observer[T] {
+	return &Observer[T]{
 		ob:       ob,
 		id:       id,
-		handlers: make([]Handler, 0),
+		handlers: make([]Handler[T], 0),
 	}
 }
 
-func (o *Observer) HandleFunc(each OnEach, dispose OnDispose) {
-	o.Handle(&handlerImpl{each, dispose})
+func (o *Observer[T]) HandleFunc(each OnEach[T], dispose OnDispose[T]) {
+	o.Handle(&handlerImpl[T]{each, dispose})
 }
 
-func (o *Observer) Handle(handler Handler) {
+// Add the handler to the observer. The handler of an already disposed observer
+// gets disposed right away instead.
+func (o *Observer[T]) Handle(handler Handler[T]) {
 	o.mu.Lock()
-	defer o.mu.Unlock()
+	if o.disposed {
+		o.mu.Unlock()
+		handler.OnDispose(o)
+		return
+	}
 	o.handlers = append(o.handlers, handler)
+	o.mu.Unlock()
 }
 
-func (o *Observer) Dispose() {
+func (o *Observer[T]) Dispose() {
 	o.dispose(true)
 }
 
-func (o *Observer) dispatch(v interface{}) {
+// Call the handlers one after another, returning once all of them have handled the value.
+func (o *Observer[T]) send(v T) {
 	o.mu.RLock()
 	defer o.mu.RUnlock()
-	wg := &sync.WaitGroup{}
-	wg.Add(len(o.handlers))
 	for _, handler := range o.handlers {
-		go o.eachHandler(wg, handler, v)
+		handler.OnEach(o, v)
 	}
-	wg.Wait()
 }
 
-func (o *Observer) dispose(remove bool) {
+func (o *Observer[T]) close() {
+	o.dispose(false)
+}
+
+func (o *Observer[T]) dispose(remove bool) {
 	o.mu.Lock()
-	defer o.mu.Unlock()
 	if o.disposed {
+		o.mu.Unlock()
 		return
 	}
-	wg := &sync.WaitGroup{}
-	wg.Add(len(o.handlers))
 	for _, handler := range o.handlers {
-		go o.disposeHandler(wg, handler)
-	}
-	wg.Wait()
-	if remove {
-		o.ob.remove(o.id)
+		handler.OnDispose(o)
 	}
+	ob := o.ob
 	// Remove reference to avoid memory leaks
 	o.ob = nil
 	o.handlers = nil
 	o.disposed = true
-}
-
-func (o *Observer) eachHandler(wg *sync.WaitGroup, h Handler, v interface{}) {
-	defer wg.Done()
-	h.OnEach(o, v)
-}
-
-func (o *Observer) disposeHandler(wg *sync.WaitGroup, h Handler) {
-	defer wg.Done()
-	h.OnDispose(o)
+	o.mu.Unlock()
+	// Removing takes the observable's lock, which is held while dispatching to us,
+	// so it must be done after letting go of our own lock
+	if remove && ob != nil {
+		ob.remove(o.id)
+	}
 }
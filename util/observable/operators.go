@@ -0,0 +1,27 @@
+package observable
+
+// Filter makes the observable of the source's values which pass the predicate.
+// The filtered observable gets disposed together with the source.
+func Filter[T any](src *Observable[T], pred func(v T) bool) *Observable[T] {
+	dst := New[T]()
+	src.Observe().HandleFunc(func(o *Observer[T], v T) {
+		if pred(v) {
+			dst.Dispatch(v)
+		}
+	}, func(o *Observer[T]) {
+		dst.Dispose()
+	})
+	return dst
+}
+
+// Map makes the observable of the source's values mapped by the function.
+// The mapped observable gets disposed together with the source.
+func Map[T, U any](src *Observable[T], fn func(v T) U) *Observable[U] {
+	dst := New[U]()
+	src.Observe().HandleFunc(func(o *Observer[T], v T) {
+		dst.Dispatch(fn(v))
+	}, func(o *Observer[T]) {
+		dst.Dispose()
+	})
+	return dst
+}
@@ -0,0 +1,123 @@
+package observable
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow decides what happens to the dispatched value once the subscription's buffer is full.
+type Overflow uint8
+
+const (
+	// Block the dispatching until the subscriber makes room for the value.
+	Block Overflow = iota
+	// Drop the oldest buffered value to make room for the value.
+	DropOldest
+	// Drop the value itself, keeping the buffered values.
+	DropNewest
+)
+
+var overflowNames = map[Overflow]string{
+	Block:      "block",
+	DropOldest: "drop oldest",
+	DropNewest: "drop newest",
+}
+
+func (ov Overflow) String() string {
+	return overflowNames[ov]
+}
+
+// Subscription receives the dispatched values over a buffered channel.
+type Subscription[T any] struct {
+	// The number of values dropped due to the full buffer, kept first for the 64-bit alignment.
+	dropped uint64
+
+	ob       *Observable[T]
+	id       int
+	ch       chan T
+	overflow Overflow
+	closing  <-chan struct{}
+	done     chan struct{}
+	mu       sync.RWMutex
+	closed   bool
+	once     sync.Once
+}
+
+func newSubscription[T any](ob *Observable[T], id int, size int, overflow Overflow) *Subscription[T] {
+	// Dropping values needs a buffer to drop them from
+	if size < 1 && overflow != Block {
+		size = 1
+	}
+	return &Subscription[T]{
+		ob:       ob,
+		id:       id,
+		ch:       make(chan T, size),
+		overflow: overflow,
+		closing:  ob.closing,
+		done:     make(chan struct{}),
+	}
+}
+
+// C delivers the dispatched values, and gets closed once the subscription is disposed.
+func (s *Subscription[T]) C() <-chan T {
+	return s.ch
+}
+
+// The number of values dropped so far due to the full buffer.
+func (s *Subscription[T]) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription[T]) Dispose() {
+	s.close()
+	// The blocked dispatching has let go of the observable's lock by now
+	if s.ob != nil {
+		s.ob.remove(s.id)
+	}
+}
+
+func (s *Subscription[T]) send(v T) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	switch s.overflow {
+	case Block:
+		select {
+		case s.ch <- v:
+		case <-s.done:
+		case <-s.closing:
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- v:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- v:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func (s *Subscription[T]) close() {
+	s.once.Do(func() {
+		// Let go of the blocked sending first, which holds the lock
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.ch)
+	})
+}
@@ -0,0 +1,324 @@
+// Package pcapng writes and reads packet captures in the pcapng format, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html. Only the blocks needed
+// to capture the datagrams of a single interface are written, while reading skips the blocks it doesn't know.
+package pcapng
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotPcapng    = errors.New("not a pcapng capture")
+	ErrBlockInvalid = errors.New("invalid pcapng block")
+)
+
+type LinkType uint16
+
+const (
+	LinkTypeEthernet LinkType = 1
+	// Raw IPv4 or IPv6 packets without any link-layer header.
+	LinkTypeRaw LinkType = 101
+)
+
+// Direction of the packet as seen from the capturing side.
+type Direction uint8
+
+const (
+	DirectionUnknown Direction = iota
+	Inbound
+	Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "in"
+	case Outbound:
+		return "out"
+	}
+	return "-"
+}
+
+const (
+	blockSectionHeader        = 0x0a0d0d0a
+	blockInterfaceDescription = 0x00000001
+	blockEnhancedPacket       = 0x00000006
+	byteOrderMagic            = 0x1a2b3c4d
+
+	optEnd = 0
+	// The resolution of the interface's timestamps.
+	optTimestampResolution = 9
+	// The direction of the enhanced packet, among other flags.
+	optFlags = 2
+
+	// Block type + block total length, with the block total length repeated at the end.
+	blockOverhead = 12
+	// Largest block taken when reading, which is plenty for datagrams.
+	blockMaxSize = 1 << 20
+	snapLen      = 0
+)
+
+// Writer writes the packets of a single interface. It's safe to use from multiple goroutines,
+// which is how the datagrams sent and received at the same time get written.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+	// Reused for building the body of the packet blocks and the whole block.
+	body  []byte
+	block []byte
+}
+
+// Start the capture of packets of the link type, writing the section header and the interface.
+func NewWriter(w io.Writer, lt LinkType) (*Writer, error) {
+	pw := &Writer{w: w}
+	// Byte order magic, version 1.0, section length unknown
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	if err := pw.writeBlock(blockSectionHeader, shb); err != nil {
+		return nil, err
+	}
+	// Nanosecond timestamps
+	idb := binary.LittleEndian.AppendUint16(nil, uint16(lt))
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, snapLen)
+	idb = appendOption(idb, optTimestampResolution, []byte{9})
+	idb = appendOption(idb, optEnd, nil)
+	if err := pw.writeBlock(blockInterfaceDescription, idb); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (w *Writer) WritePacket(ts time.Time, data []byte, dir Direction) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ns := uint64(ts.UnixNano())
+	epb := binary.LittleEndian.AppendUint32(w.body[:0], 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ns>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ns))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = append(epb, data...)
+	epb = pad(epb)
+	if dir != DirectionUnknown {
+		epb = appendOption(epb, optFlags, binary.LittleEndian.AppendUint32(nil, uint32(dir)))
+		epb = appendOption(epb, optEnd, nil)
+	}
+	w.body = epb
+	return w.writeBlock(blockEnhancedPacket, epb)
+}
+
+// Write the block in a single write, so the blocks never interleave even when the writes fail halfway.
+func (w *Writer) writeBlock(typ uint32, body []byte) error {
+	size := uint32(blockOverhead + len(body))
+	b := binary.LittleEndian.AppendUint32(w.block[:0], typ)
+	b = binary.LittleEndian.AppendUint32(b, size)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, size)
+	w.block = b
+	_, err := w.w.Write(b)
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return pad(append(b, value...))
+}
+
+// Pad to 32 bits, which every block and option is aligned to.
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func padded(n int) int {
+	return (n + 3) &^ 3
+}
+
+// Packet read from the capture.
+type Packet struct {
+	Time      time.Time
+	Interface int
+	LinkType  LinkType
+	Direction Direction
+	Data      []byte
+	// The length of the packet on the wire, which is more than the data if the capture has truncated it.
+	Length int
+}
+
+type iface struct {
+	linkType LinkType
+	// Timestamp units per second.
+	resolution uint64
+}
+
+// Reader reads the packets of every interface of every section of the capture.
+type Reader struct {
+	r      io.Reader
+	order  binary.ByteOrder
+	ifaces []iface
+}
+
+// Start reading the capture, which must start with a section header.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: r}
+	typ, _, err := pr.readBlock()
+	if err == ErrBlockInvalid || err == io.ErrUnexpectedEOF || err == nil && typ != blockSectionHeader {
+		return nil, ErrNotPcapng
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// Read the next packet, skipping the blocks of any other kind. Returns io.EOF at the end of the capture.
+func (r *Reader) Next() (*Packet, error) {
+	for {
+		typ, body, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case blockSectionHeader:
+			r.ifaces = r.ifaces[:0]
+		case blockInterfaceDescription:
+			if len(body) < 8 {
+				return nil, ErrBlockInvalid
+			}
+			r.ifaces = append(r.ifaces, iface{
+				linkType:   LinkType(r.order.Uint16(body)),
+				resolution: r.resolution(body[8:]),
+			})
+		case blockEnhancedPacket:
+			return r.packet(body)
+		}
+	}
+}
+
+func (r *Reader) packet(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, ErrBlockInvalid
+	}
+	id := int(r.order.Uint32(body))
+	if id >= len(r.ifaces) {
+		return nil, ErrBlockInvalid
+	}
+	ifc := r.ifaces[id]
+	ts := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+	captured := int(r.order.Uint32(body[12:]))
+	if captured > len(body)-20 {
+		return nil, ErrBlockInvalid
+	}
+	p := &Packet{
+		Time:      time.Unix(int64(ts/ifc.resolution), int64(ts%ifc.resolution*uint64(time.Second)/ifc.resolution)),
+		Interface: id,
+		LinkType:  ifc.linkType,
+		Data:      body[20 : 20+captured],
+		Length:    int(r.order.Uint32(body[16:])),
+	}
+	r.options(body[20+padded(captured):], func(code uint16, value []byte) {
+		if code == optFlags && len(value) >= 4 {
+			p.Direction = Direction(r.order.Uint32(value) & 3)
+		}
+	})
+	return p, nil
+}
+
+// The timestamp units per second out of the interface's options, microseconds by default.
+func (r *Reader) resolution(opts []byte) uint64 {
+	res := uint64(1000000)
+	r.options(opts, func(code uint16, value []byte) {
+		if code != optTimestampResolution || len(value) < 1 {
+			return
+		}
+		exp := uint64(value[0] & 0x7f)
+		base := uint64(10)
+		if value[0]&0x80 != 0 {
+			base = 2
+		}
+		// Anything finer than nanoseconds is of no use for time.Time anyway
+		res = 1
+		for i := uint64(0); i < exp && res < 1e9; i++ {
+			res *= base
+		}
+	})
+	return res
+}
+
+func (r *Reader) options(b []byte, fn func(code uint16, value []byte)) {
+	for len(b) >= 4 {
+		code, n := r.order.Uint16(b), int(r.order.Uint16(b[2:]))
+		if code == optEnd || 4+n > len(b) {
+			return
+		}
+		fn(code, b[4:4+n])
+		if 4+padded(n) > len(b) {
+			return
+		}
+		b = b[4+padded(n):]
+	}
+}
+
+// Read the next block, returning its type and its body between the lengths.
+// The byte order of the section is picked up from every section header.
+func (r *Reader) readBlock() (uint32, []byte, error) {
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(r.r, head); err != nil {
+		return 0, nil, err
+	}
+	if binary.LittleEndian.Uint32(head) == blockSectionHeader {
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(r.r, magic); err != nil {
+			return 0, nil, noEOF(err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == byteOrderMagic:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == byteOrderMagic:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, ErrBlockInvalid
+		}
+		body, err := r.readBody(head, 4)
+		if err != nil {
+			return 0, nil, err
+		}
+		return blockSectionHeader, append(magic, body...), nil
+	}
+	if r.order == nil {
+		return 0, nil, ErrBlockInvalid
+	}
+	body, err := r.readBody(head, 0)
+	return r.order.Uint32(head), body, err
+}
+
+// Read the rest of the block's body after the bytes already read of it, dropping the trailing length.
+func (r *Reader) readBody(head []byte, read int) ([]byte, error) {
+	size := int(r.order.Uint32(head[4:]))
+	if size < blockOverhead+read || size%4 != 0 || size > blockMaxSize {
+		return nil, ErrBlockInvalid
+	}
+	b := make([]byte, size-8-read)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, noEOF(err)
+	}
+	return b[:len(b)-4], nil
+}
+
+// The capture ending in the middle of a block is truncated rather than complete.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package pcapng
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+	require := require.New(t)
+	a := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	b := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 9001}
+	c := &net.UDPAddr{IP: net.ParseIP("::1"), Port: 9002}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, LinkTypeRaw)
+	require.Nil(err)
+	start := time.Unix(1700000000, 123456789)
+	require.Nil(w.WritePacket(start, EncodeUDP(a, b, []byte("Hello")), Outbound))
+	require.Nil(w.WritePacket(start.Add(time.Millisecond), EncodeUDP(c, c, []byte("Hello, world!")), Inbound))
+	require.Nil(w.WritePacket(start.Add(time.Second), EncodeUDP(b, a, nil), DirectionUnknown))
+
+	// Test the packets read back in order, with their timestamps, directions and addresses
+	r, err := NewReader(&buf)
+	require.Nil(err)
+	expected := []struct {
+		ts       time.Time
+		dir      Direction
+		src, dst *net.UDPAddr
+		payload  string
+	}{
+		{start, Outbound, a, b, "Hello"},
+		{start.Add(time.Millisecond), Inbound, c, c, "Hello, world!"},
+		{start.Add(time.Second), DirectionUnknown, b, a, ""},
+	}
+	for _, e := range expected {
+		p, err := r.Next()
+		require.Nil(err)
+		require.True(e.ts.Equal(p.Time), p.Time)
+		require.Equal(e.dir, p.Direction)
+		require.Equal(LinkTypeRaw, p.LinkType)
+		require.Equal(len(p.Data), p.Length)
+		src, dst, payload, err := DecodeUDP(p.LinkType, p.Data)
+		require.Nil(err)
+		require.Equal(e.src.String(), src.String())
+		require.Equal(e.dst.String(), dst.String())
+		require.Equal(e.payload, string(payload))
+	}
+	_, err = r.Next()
+	require.Equal(io.EOF, err)
+
+	// Test the checksums of the encapsulation check out
+	{
+		p := EncodeUDP(a, b, []byte("Hello"))
+		require.Equal(uint16(0xffff), checksum(0, p[:ipv4HeaderSize]))
+		pseudo := append(append(a.IP.To4(), b.IP.To4()...), 0, protocolUDP, 0, byte(len(p)-ipv4HeaderSize))
+		require.Equal(uint16(0xffff), checksum(0, append(pseudo, p[ipv4HeaderSize:]...)))
+	}
+
+	// Test reading something other than a capture
+	{
+		_, err := NewReader(bytes.NewReader([]byte("Hello, world! Hello, world!")))
+		require.Equal(ErrNotPcapng, err)
+		_, err = NewReader(bytes.NewReader(nil))
+		require.Equal(io.EOF, err)
+	}
+
+	// Test reading a truncated capture
+	{
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, LinkTypeRaw)
+		require.Nil(err)
+		require.Nil(w.WritePacket(start, EncodeUDP(a, b, []byte("Hello")), Outbound))
+		r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-4]))
+		require.Nil(err)
+		_, err = r.Next()
+		require.Equal(io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestDecodeUDP(t *testing.T) {
+	require := require.New(t)
+	a := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	b := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 9001}
+
+	// Test the datagrams of Ethernet captures, with or without VLAN tags
+	{
+		ip := EncodeUDP(a, b, []byte("Hello"))
+		eth := append(make([]byte, 12), 0x08, 0x00)
+		vlan := append(make([]byte, 12), 0x81, 0x00, 0, 1, 0x08, 0x00)
+		for _, frame := range [][]byte{append(eth, ip...), append(vlan, ip...)} {
+			src, dst, payload, err := DecodeUDP(LinkTypeEthernet, frame)
+			require.Nil(err)
+			require.Equal(a.String(), src.String())
+			require.Equal(b.String(), dst.String())
+			require.Equal("Hello", string(payload))
+		}
+	}
+
+	// Test the unspecified address of dual-stack sockets takes the family of the other side
+	{
+		unspec := &net.UDPAddr{IP: net.IPv6unspecified, Port: 9002}
+		ip := EncodeUDP(unspec, a, []byte("Hello"))
+		require.Equal(byte(0x45), ip[0])
+		src, dst, _, err := DecodeUDP(LinkTypeRaw, ip)
+		require.Nil(err)
+		require.Equal("0.0.0.0:9002", src.String())
+		require.Equal(a.String(), dst.String())
+	}
+
+	// Test anything but UDP datagrams gets told apart
+	{
+		ip := EncodeUDP(a, b, []byte("Hello"))
+		ip[9] = 6
+		_, _, _, err := DecodeUDP(LinkTypeRaw, ip)
+		require.Equal(ErrNotUDP, err)
+		_, _, _, err = DecodeUDP(LinkTypeRaw, nil)
+		require.Equal(ErrNotUDP, err)
+		_, _, _, err = DecodeUDP(LinkType(1000), EncodeUDP(a, b, nil))
+		require.Equal(ErrNotUDP, err)
+		_, _, _, err = DecodeUDP(LinkTypeEthernet, append(append(make([]byte, 12), 0x08, 0x06), make([]byte, 28)...))
+		require.Equal(ErrNotUDP, err)
+	}
+}
@@ -0,0 +1,146 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+var ErrNotUDP = errors.New("not a UDP datagram")
+
+const (
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	udpHeaderSize  = 8
+	ethHeaderSize  = 14
+	protocolUDP    = 17
+	etherTypeIPv4  = 0x0800
+	etherTypeIPv6  = 0x86dd
+	etherTypeVLAN  = 0x8100
+)
+
+// Encapsulate the UDP payload into an IPv4 packet, or an IPv6 packet unless both addresses are IPv4,
+// for captures of LinkTypeRaw. Tools like Wireshark then tell the addresses apart and dissect the payload.
+func EncodeUDP(src, dst *net.UDPAddr, payload []byte) []byte {
+	udpLen := udpHeaderSize + len(payload)
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	// Sockets bound to the unspecified address, such as [::] of dual-stack ones,
+	// take the address family of the other side
+	if len(src.IP) == 0 || src.IP.IsUnspecified() && dst4 != nil {
+		src4 = net.IPv4zero.To4()
+	}
+	if len(dst.IP) == 0 || dst.IP.IsUnspecified() && src4 != nil {
+		dst4 = net.IPv4zero.To4()
+	}
+	var b []byte
+	var pseudo []byte
+	if src4 != nil && dst4 != nil {
+		b = make([]byte, ipv4HeaderSize, ipv4HeaderSize+udpLen)
+		b[0] = 0x45
+		binary.BigEndian.PutUint16(b[2:], uint16(ipv4HeaderSize+udpLen))
+		// Don't fragment
+		binary.BigEndian.PutUint16(b[6:], 0x4000)
+		b[8] = 64
+		b[9] = protocolUDP
+		copy(b[12:], src4)
+		copy(b[16:], dst4)
+		binary.BigEndian.PutUint16(b[10:], ^checksum(0, b))
+		pseudo = append(append(append([]byte(nil), src4...), dst4...), 0, protocolUDP, byte(udpLen>>8), byte(udpLen))
+	} else {
+		b = make([]byte, ipv6HeaderSize, ipv6HeaderSize+udpLen)
+		b[0] = 0x60
+		binary.BigEndian.PutUint16(b[4:], uint16(udpLen))
+		b[6] = protocolUDP
+		b[7] = 64
+		copy(b[8:], src.IP.To16())
+		copy(b[24:], dst.IP.To16())
+		pseudo = append(append([]byte(nil), b[8:40]...), 0, 0, byte(udpLen>>8), byte(udpLen), 0, 0, 0, protocolUDP)
+	}
+	udp := len(b)
+	b = binary.BigEndian.AppendUint16(b, uint16(src.Port))
+	b = binary.BigEndian.AppendUint16(b, uint16(dst.Port))
+	b = binary.BigEndian.AppendUint16(b, uint16(udpLen))
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = append(b, payload...)
+	sum := ^checksum(0, append(pseudo, b[udp:]...))
+	// Zero means no checksum, so a computed zero gets sent as all ones
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(b[udp+6:], sum)
+	return b
+}
+
+// Decode the UDP datagram out of the packet of the link type, which is either LinkTypeRaw or LinkTypeEthernet.
+func DecodeUDP(lt LinkType, data []byte) (src, dst *net.UDPAddr, payload []byte, err error) {
+	if lt == LinkTypeEthernet {
+		if len(data) < ethHeaderSize {
+			return nil, nil, nil, ErrNotUDP
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[ethHeaderSize:]
+		if etherType == etherTypeVLAN && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+		if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+			return nil, nil, nil, ErrNotUDP
+		}
+	} else if lt != LinkTypeRaw {
+		return nil, nil, nil, ErrNotUDP
+	}
+	if len(data) < 1 {
+		return nil, nil, nil, ErrNotUDP
+	}
+	src, dst = &net.UDPAddr{}, &net.UDPAddr{}
+	switch data[0] >> 4 {
+	case 4:
+		ihl := int(data[0]&0x0f) * 4
+		if ihl < ipv4HeaderSize || len(data) < ihl || data[9] != protocolUDP {
+			return nil, nil, nil, ErrNotUDP
+		}
+		// Only the first fragment carries the UDP header
+		if binary.BigEndian.Uint16(data[6:])&0x1fff != 0 {
+			return nil, nil, nil, ErrNotUDP
+		}
+		src.IP = append(net.IP(nil), data[12:16]...)
+		dst.IP = append(net.IP(nil), data[16:20]...)
+		data = data[ihl:]
+	case 6:
+		// Extension headers aren't followed, the UDP header must come right after the IPv6 header
+		if len(data) < ipv6HeaderSize || data[6] != protocolUDP {
+			return nil, nil, nil, ErrNotUDP
+		}
+		src.IP = append(net.IP(nil), data[8:24]...)
+		dst.IP = append(net.IP(nil), data[24:40]...)
+		data = data[ipv6HeaderSize:]
+	default:
+		return nil, nil, nil, ErrNotUDP
+	}
+	if len(data) < udpHeaderSize {
+		return nil, nil, nil, ErrNotUDP
+	}
+	src.Port = int(binary.BigEndian.Uint16(data))
+	dst.Port = int(binary.BigEndian.Uint16(data[2:]))
+	n := int(binary.BigEndian.Uint16(data[4:]))
+	if n < udpHeaderSize || n > len(data) {
+		// A truncated capture still has whatever payload got captured
+		n = len(data)
+	}
+	return src, dst, data[udpHeaderSize:n], nil
+}
+
+// The ones' complement sum of the 16-bit words, starting from the sum.
+func checksum(sum uint32, b []byte) uint16 {
+	for len(b) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) > 0 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
@@ -0,0 +1,139 @@
+// Package ratelimit shapes the rate of bytes sent with token buckets.
+package ratelimit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrWaitCanceled = errors.New("rate limit wait canceled")
+)
+
+// Limit of the rate in bytes per second, letting bursts of up to Burst bytes through at once.
+// A zero rate means no limit, while a burst of zero is a second's worth of the rate.
+type Limit struct {
+	Rate  int
+	Burst int
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst <= 0 {
+		return float64(l.Rate)
+	}
+	return float64(l.Burst)
+}
+
+// Bucket is a token bucket refilling at the rate of its limit, up to its burst. It's safe for concurrent use,
+// and its limit may be changed at any time, waking up the waiters to take the new limit into account.
+type Bucket struct {
+	mu     sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+	// Closed and replaced whenever the limit changes.
+	changed chan struct{}
+	now     func() time.Time
+}
+
+// Create the bucket with the limit, starting out full.
+func New(limit Limit) *Bucket {
+	b := &Bucket{
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+	b.last = b.now()
+	b.set(limit)
+	return b
+}
+
+func (b *Bucket) Limit() Limit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit
+}
+
+// Change the limit, keeping the tokens gathered so far up to the new burst.
+func (b *Bucket) SetLimit(limit Limit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.set(limit)
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *Bucket) set(limit Limit) {
+	wasUnlimited := b.limit.Unlimited()
+	b.limit = limit
+	if wasUnlimited || b.tokens > limit.burst() {
+		b.tokens = limit.burst()
+	}
+}
+
+// Whether the bucket limits the rate at all, which lets the callers skip working out the size of what they send.
+func (b *Bucket) Limited() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.limit.Unlimited()
+}
+
+// Wait until n bytes may be sent, taking their tokens, or until cancel is closed.
+// More bytes than the burst go through once the bucket is full, leaving it in debt,
+// so they hold back whatever comes next rather than never going through.
+func (b *Bucket) Wait(n int, cancel <-chan struct{}) error {
+	for {
+		wait, changed := b.take(n)
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-cancel:
+			timer.Stop()
+			return ErrWaitCanceled
+		}
+	}
+}
+
+// Take the tokens of n bytes if there are enough of them, otherwise tell how long until there are.
+func (b *Bucket) take(n int) (time.Duration, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit.Unlimited() {
+		return 0, nil
+	}
+	b.refill()
+	need := float64(n)
+	if burst := b.limit.burst(); need > burst {
+		need = burst
+	}
+	if b.tokens >= need {
+		b.tokens -= float64(n)
+		return 0, nil
+	}
+	wait := time.Duration((need - b.tokens) / float64(b.limit.Rate) * float64(time.Second))
+	if wait <= 0 {
+		wait = time.Nanosecond
+	}
+	return wait, b.changed
+}
+
+func (b *Bucket) refill() {
+	now := b.now()
+	if !b.limit.Unlimited() {
+		b.tokens += now.Sub(b.last).Seconds() * float64(b.limit.Rate)
+		if burst := b.limit.burst(); b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+}
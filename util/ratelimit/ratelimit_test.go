@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket(t *testing.T) {
+	require := require.New(t)
+	now := time.Unix(0, 0)
+	b := New(Limit{Rate: 1000, Burst: 100})
+	b.now = func() time.Time {
+		return now
+	}
+	b.last = now
+
+	// Test the burst goes through at once, while the rest waits for the tokens to refill
+	{
+		wait, _ := b.take(100)
+		require.Zero(wait)
+		wait, _ = b.take(50)
+		require.Equal(50*time.Millisecond, wait)
+		now = now.Add(50 * time.Millisecond)
+		wait, _ = b.take(50)
+		require.Zero(wait)
+	}
+
+	// Test more than the burst goes through once the bucket is full, holding back what comes next
+	{
+		now = now.Add(time.Second)
+		wait, _ := b.take(300)
+		require.Zero(wait)
+		wait, _ = b.take(1)
+		require.Equal(201*time.Millisecond, wait)
+	}
+
+	// Test changing the limit wakes up the waiters, and lifting it lets everything through
+	{
+		_, changed := b.take(1)
+		b.SetLimit(Limit{})
+		<-changed
+		require.False(b.Limited())
+		wait, _ := b.take(1 << 20)
+		require.Zero(wait)
+		b.SetLimit(Limit{Rate: 1000})
+		require.Equal(Limit{Rate: 1000}, b.Limit())
+		// The bucket of a lifted limit starts out full, holding a second's worth of the rate
+		wait, _ = b.take(1000)
+		require.Zero(wait)
+	}
+
+	// Test waiting gets canceled
+	{
+		cancel := make(chan struct{})
+		close(cancel)
+		require.Equal(ErrWaitCanceled, b.Wait(1, cancel))
+	}
+}
+
+func TestWait(t *testing.T) {
+	require := require.New(t)
+	b := New(Limit{Rate: 10000, Burst: 1000})
+
+	// Test the rate holds over real time, once the burst is spent
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.Nil(b.Wait(500, nil))
+	}
+	elapsed := time.Since(start)
+	require.True(elapsed >= 90*time.Millisecond, elapsed)
+	require.True(elapsed < time.Second, elapsed)
+}
@@ -0,0 +1,147 @@
+package simnet
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock which only moves forward when it's advanced,
+// firing the scheduled timers in order along the way.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers timerHeap
+	nextId uint64
+}
+
+type Timer struct {
+	clock *Clock
+	when  time.Time
+	id    uint64
+	fn    func()
+	index int
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc schedules the function to be called once the clock has advanced by the duration.
+// Timers scheduled for the same time fire in the order they were scheduled.
+func (c *Clock) AfterFunc(d time.Duration, fn func()) *Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextId++
+	t := &Timer{
+		clock: c,
+		when:  c.now.Add(d),
+		id:    c.nextId,
+		fn:    fn,
+	}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+// Stop the timer, reporting whether it was stopped before it fired.
+func (t *Timer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	return true
+}
+
+// Advance the clock by the duration, firing every timer due until then.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	for c.fireNext(end) {
+	}
+	c.mu.Lock()
+	if end.After(c.now) {
+		c.now = end
+	}
+	c.mu.Unlock()
+}
+
+// Step advances the clock right to the next timer and fires it,
+// reporting whether there was any timer to fire.
+func (c *Clock) Step() bool {
+	c.mu.Lock()
+	if len(c.timers) <= 0 {
+		c.mu.Unlock()
+		return false
+	}
+	when := c.timers[0].when
+	c.mu.Unlock()
+	return c.fireNext(when)
+}
+
+// The number of pending timers.
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (c *Clock) fireNext(end time.Time) bool {
+	c.mu.Lock()
+	if len(c.timers) <= 0 || c.timers[0].when.After(end) {
+		c.mu.Unlock()
+		return false
+	}
+	t := heap.Pop(&c.timers).(*Timer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	c.mu.Unlock()
+	// Fire outside of the lock so the timer is free to schedule new timers
+	t.fn()
+	return true
+}
+
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].id < h[j].id
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(v interface{}) {
+	t := v.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
@@ -0,0 +1,45 @@
+package simnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	require := require.New(t)
+	start := time.Unix(0, 0)
+	c := NewClock(start)
+
+	fired := make([]int, 0)
+	c.AfterFunc(2*time.Second, func() {
+		fired = append(fired, 2)
+	})
+	c.AfterFunc(time.Second, func() {
+		fired = append(fired, 1)
+		// Timers are free to schedule new timers
+		c.AfterFunc(0, func() {
+			fired = append(fired, 3)
+		})
+	})
+	stopped := c.AfterFunc(time.Second, func() {
+		fired = append(fired, 4)
+	})
+	require.True(stopped.Stop())
+	require.False(stopped.Stop())
+
+	c.Advance(500 * time.Millisecond)
+	require.Empty(fired)
+	require.Equal(start.Add(500*time.Millisecond), c.Now())
+
+	c.Advance(time.Second)
+	require.Equal([]int{1, 3}, fired)
+	require.Equal(start.Add(1500*time.Millisecond), c.Now())
+
+	require.True(c.Step())
+	require.Equal([]int{1, 3, 2}, fired)
+	require.Equal(start.Add(2*time.Second), c.Now())
+	require.False(c.Step())
+	require.Zero(c.Pending())
+}
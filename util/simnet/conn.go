@@ -0,0 +1,91 @@
+package simnet
+
+import (
+	"net"
+	"sync"
+)
+
+type datagram struct {
+	b    []byte
+	addr *net.UDPAddr
+}
+
+// Conn is a connection on the simulated network, implementing the same read and write
+// methods as net.UDPConn. Reads block until a datagram gets delivered by the virtual clock.
+type Conn struct {
+	network *Network
+	laddr   *net.UDPAddr
+	mu      sync.Mutex
+	cond    *sync.Cond
+	inbox   []datagram
+	closed  bool
+}
+
+func newConn(n *Network, laddr *net.UDPAddr) *Conn {
+	c := &Conn{
+		network: n,
+		laddr:   laddr,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *Conn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, ErrConnClosed
+	}
+	c.network.send(c.laddr, b, addr)
+	return len(b), nil
+}
+
+func (c *Conn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.inbox) <= 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return 0, nil, ErrConnClosed
+	}
+	d := c.inbox[0]
+	c.inbox[0] = datagram{}
+	c.inbox = c.inbox[1:]
+	return copy(b, d.b), d.addr, nil
+}
+
+// The number of delivered datagrams waiting to be read.
+func (c *Conn) Buffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.inbox)
+}
+
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrConnClosed
+	}
+	c.closed = true
+	c.inbox = nil
+	c.network.remove(c)
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *Conn) push(addr *net.UDPAddr, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.inbox = append(c.inbox, datagram{b, addr})
+	c.cond.Signal()
+}
@@ -0,0 +1,243 @@
+package simnet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAddressInUse = errors.New("address already in use")
+	ErrConnClosed   = errors.New("use of closed connection")
+)
+
+// Config describes the behavior of the links in the simulated network.
+type Config struct {
+	// Probability of a datagram getting lost, between 0 and 1.
+	Loss float64
+	// Probability of a datagram getting delivered twice, between 0 and 1.
+	Duplicate float64
+	// Probability of a datagram getting delayed past the datagrams sent after it, between 0 and 1.
+	Reorder float64
+	// One-way delay of every datagram.
+	Latency time.Duration
+	// Maximum random delay added on top of the latency.
+	Jitter time.Duration
+	// Link bandwidth in bytes per second, or zero for unlimited bandwidth.
+	Bandwidth int
+	// Datagrams larger than the MTU get dropped, or zero for unlimited size.
+	MTU int
+}
+
+// Stats counts what happened to the datagrams sent over the network.
+type Stats struct {
+	Sent       int
+	Delivered  int
+	Lost       int
+	Duplicated int
+	Reordered  int
+	// Datagrams dropped for exceeding the MTU.
+	Oversized int
+	// Datagrams sent to an address nobody listens on.
+	Unroutable int
+}
+
+// Network is a deterministic in-memory packet network. Every random decision is drawn
+// from a seeded source and every delivery is scheduled on a virtual clock, so the same seed
+// and the same sequence of writes always produce the same sequence of deliveries.
+type Network struct {
+	clock *Clock
+	mu    sync.Mutex
+	rng   *rand.Rand
+	cfg   Config
+	conns map[string]*Conn
+	stats Stats
+	// The time the link becomes free to serialize the next datagram when the bandwidth is limited.
+	busy     time.Time
+	nextPort int
+}
+
+func New(seed int64, cfg Config) *Network {
+	return &Network{
+		clock:    NewClock(time.Unix(0, 0)),
+		rng:      rand.New(rand.NewSource(seed)),
+		cfg:      cfg,
+		conns:    make(map[string]*Conn),
+		nextPort: 10000,
+	}
+}
+
+func (n *Network) Clock() *Clock {
+	return n.clock
+}
+
+// Advance the virtual clock by the duration, delivering every datagram due until then.
+func (n *Network) Advance(d time.Duration) {
+	n.clock.Advance(d)
+}
+
+// Step advances the virtual clock right to the next event, reporting whether there was any.
+func (n *Network) Step() bool {
+	return n.clock.Step()
+}
+
+// Flush advances the virtual clock until every datagram in flight has been delivered.
+func (n *Network) Flush() {
+	for n.clock.Step() {
+	}
+}
+
+// Run the virtual clock along with the wall clock, advancing it every interval until stopped,
+// which lets real-time code like the wire protocol's timers run over the network.
+// Determinism is lost this way, since the goroutine scheduling decides the order of the writes.
+func (n *Network) Realtime(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				n.Advance(now.Sub(last))
+				last = now
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+func (n *Network) Config() Config {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.cfg
+}
+
+// Change the behavior of the links, affecting only the datagrams sent afterwards.
+func (n *Network) SetConfig(cfg Config) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cfg = cfg
+}
+
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// Listen opens a connection on the address. A zero port picks the next free port.
+func (n *Network) Listen(addr *net.UDPAddr) (*Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	laddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	if addr != nil {
+		laddr.IP, laddr.Port, laddr.Zone = addr.IP, addr.Port, addr.Zone
+	}
+	if laddr.Port == 0 {
+		for {
+			n.nextPort++
+			laddr.Port = n.nextPort
+			if _, ok := n.conns[laddr.String()]; !ok {
+				break
+			}
+		}
+	}
+	key := laddr.String()
+	if _, ok := n.conns[key]; ok {
+		return nil, ErrAddressInUse
+	}
+	c := newConn(n, laddr)
+	n.conns[key] = c
+	return c, nil
+}
+
+func (n *Network) send(src *net.UDPAddr, b []byte, dst *net.UDPAddr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stats.Sent++
+	if n.cfg.MTU > 0 && len(b) > n.cfg.MTU {
+		n.stats.Oversized++
+		return
+	}
+	if n.chance(n.cfg.Loss) {
+		n.stats.Lost++
+		return
+	}
+	delay := n.serialize(len(b))
+	copies := 1
+	if n.chance(n.cfg.Duplicate) {
+		n.stats.Duplicated++
+		copies++
+	}
+	p := append([]byte(nil), b...)
+	for i := 0; i < copies; i++ {
+		d := delay + n.cfg.Latency + n.jitter()
+		if n.chance(n.cfg.Reorder) {
+			n.stats.Reordered++
+			d += n.cfg.Latency + n.cfg.Jitter + time.Millisecond
+		}
+		n.clock.AfterFunc(d, func() {
+			n.deliver(src, p, dst)
+		})
+	}
+}
+
+func (n *Network) deliver(src *net.UDPAddr, b []byte, dst *net.UDPAddr) {
+	n.mu.Lock()
+	c, ok := n.conns[dst.String()]
+	if ok {
+		n.stats.Delivered++
+	} else {
+		n.stats.Unroutable++
+	}
+	n.mu.Unlock()
+	if ok {
+		c.push(src, b)
+	}
+}
+
+func (n *Network) remove(c *Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	key := c.laddr.String()
+	if n.conns[key] == c {
+		delete(n.conns, key)
+	}
+}
+
+// The serialization delay of a datagram over the link when the bandwidth is limited.
+func (n *Network) serialize(size int) time.Duration {
+	if n.cfg.Bandwidth <= 0 {
+		return 0
+	}
+	now := n.clock.Now()
+	if n.busy.Before(now) {
+		n.busy = now
+	}
+	n.busy = n.busy.Add(time.Duration(size) * time.Second / time.Duration(n.cfg.Bandwidth))
+	return n.busy.Sub(now)
+}
+
+func (n *Network) jitter() time.Duration {
+	if n.cfg.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(n.rng.Int63n(int64(n.cfg.Jitter) + 1))
+}
+
+func (n *Network) chance(p float64) bool {
+	return p > 0 && n.rng.Float64() < p
+}
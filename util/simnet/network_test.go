@@ -0,0 +1,130 @@
+package simnet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetwork(t *testing.T) {
+	require := require.New(t)
+
+	// Test delivery after the latency
+	{
+		n := New(1, Config{Latency: 10 * time.Millisecond})
+		a, b := listenPair(t, n)
+		_, err := a.WriteToUDP([]byte("Hello, world!"), b.laddr)
+		require.Nil(err)
+		n.Advance(9 * time.Millisecond)
+		require.Zero(b.Buffered())
+		n.Advance(time.Millisecond)
+		require.Equal(1, b.Buffered())
+
+		buf := make([]byte, 64)
+		nr, addr, err := b.ReadFromUDP(buf)
+		require.Nil(err)
+		require.Equal("Hello, world!", string(buf[:nr]))
+		require.Equal(a.laddr, addr)
+	}
+
+	// Test oversized datagrams get dropped
+	{
+		n := New(1, Config{MTU: 4})
+		a, b := listenPair(t, n)
+		a.WriteToUDP([]byte("Hello"), b.laddr)
+		a.WriteToUDP([]byte("Hell"), b.laddr)
+		n.Flush()
+		require.Equal(1, b.Buffered())
+		require.Equal(1, n.Stats().Oversized)
+	}
+
+	// Test the bandwidth limit delays the datagrams sent back to back
+	{
+		n := New(1, Config{Bandwidth: 1000})
+		a, b := listenPair(t, n)
+		a.WriteToUDP(make([]byte, 100), b.laddr)
+		a.WriteToUDP(make([]byte, 100), b.laddr)
+		n.Advance(100 * time.Millisecond)
+		require.Equal(1, b.Buffered())
+		n.Advance(100 * time.Millisecond)
+		require.Equal(2, b.Buffered())
+	}
+
+	// Test closed connections
+	{
+		n := New(1, Config{})
+		a, b := listenPair(t, n)
+		require.Nil(b.Close())
+		_, _, err := b.ReadFromUDP(make([]byte, 1))
+		require.Equal(ErrConnClosed, err)
+		a.WriteToUDP([]byte("Hello"), b.laddr)
+		n.Flush()
+		require.Equal(1, n.Stats().Unroutable)
+	}
+
+	// Test the real-time clock delivers the datagrams without advancing the clock by hand
+	{
+		n := New(1, Config{Latency: 10 * time.Millisecond})
+		a, b := listenPair(t, n)
+		stop := n.Realtime(time.Millisecond)
+		defer stop()
+		a.WriteToUDP([]byte("Hello"), b.laddr)
+		buf := make([]byte, 16)
+		nr, _, err := b.ReadFromUDP(buf)
+		require.Nil(err)
+		require.Equal("Hello", string(buf[:nr]))
+		stop()
+		stop()
+	}
+}
+
+func TestNetworkDeterminism(t *testing.T) {
+	require := require.New(t)
+	cfg := Config{
+		Loss:      0.2,
+		Duplicate: 0.1,
+		Reorder:   0.1,
+		Latency:   20 * time.Millisecond,
+		Jitter:    5 * time.Millisecond,
+	}
+	run := func(seed int64) ([]string, Stats) {
+		n := New(seed, cfg)
+		a, b := listenPair(t, n)
+		for i := 0; i < 200; i++ {
+			a.WriteToUDP([]byte(fmt.Sprint(i)), b.laddr)
+		}
+		n.Flush()
+		received := make([]string, 0)
+		buf := make([]byte, 16)
+		for b.Buffered() > 0 {
+			nr, _, err := b.ReadFromUDP(buf)
+			require.Nil(err)
+			received = append(received, string(buf[:nr]))
+		}
+		return received, n.Stats()
+	}
+
+	expected, stats := run(42)
+	actual, actualStats := run(42)
+	require.Equal(expected, actual)
+	require.Equal(stats, actualStats)
+	require.NotZero(stats.Lost)
+	require.NotZero(stats.Duplicated)
+	require.NotZero(stats.Reordered)
+	require.Equal(stats.Sent-stats.Lost+stats.Duplicated, len(expected))
+
+	other, _ := run(43)
+	require.NotEqual(expected, other)
+}
+
+func listenPair(t *testing.T, n *Network) (*Conn, *Conn) {
+	a, err := n.Listen(nil)
+	require.Nil(t, err)
+	b, err := n.Listen(nil)
+	require.Nil(t, err)
+	_, err = n.Listen(b.laddr)
+	require.Equal(t, ErrAddressInUse, err)
+	return a, b
+}